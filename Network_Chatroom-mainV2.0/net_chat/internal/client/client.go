@@ -2,18 +2,27 @@ package client
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"net_chat/internal/protocol"
 	"os"
 	"sync"
+	"time"
 )
 
 // Client 客户端
 type Client struct {
 	conn       net.Conn               //维护的连接
+	connMu     sync.RWMutex           //保护conn，断线重连时会替换conn
+	addr       string                 //服务端地址，断线重连时使用
+	token      string                 //服务端签发的会话令牌，断线后用它恢复登录而不必保存密码
+	tlsConfig  *tls.Config            //不为nil时使用TLS连接
+	certLogin  bool                   //通过客户端证书登录，重连时服务端会直接登录
+	legacy     bool                   //直接用密码登录，只用于还没有挑战-应答校验值的老账号完成升级
 	username   string                 //用户名
+	guest      bool                   //访客身份，很多功能不可用
 	msgChan    chan *protocol.Message //客户端自己维护的消息队列，用于在读取和处理消息协程之间的通信
 	quit       chan struct{}          //退出信号
 	wg         sync.WaitGroup         //协程控制组
@@ -21,6 +30,14 @@ type Client struct {
 	startOnce  sync.Once              //保证
 	users      []string
 	InputLines chan string
+	peerMu     sync.Mutex           //保护chatPeer，读消息协程和输入协程都会访问
+	chatPeer   string               //当前正在私聊的对象，收到他的消息时直接回复已读
+	historyMu  sync.Mutex           //保护history
+	history    protocol.HistoryPage //最近收到的一页历史消息，翻页时使用其中的位置
+	export     *exportState         //正在接收的导出文件
+	sentMu     sync.Mutex           //保护lastSent和lastRecv
+	lastSent   map[string]string    //自己在聊天室（键为空）和各私聊中最后发送的消息ID，撤回、修改时使用
+	lastRecv   map[string]string    //在聊天室（键为空）和各私聊中最近收到的他人消息ID，回复时使用
 }
 
 var (
@@ -40,16 +57,108 @@ func NewClient() *Client {
 
 // Connect 建立连接
 func (c *Client) Connect(addr string) error {
-	conn, err := net.Dial("tcp", addr)
+	conn, err := c.dial(addr)
 	if err != nil {
 		log.Fatal("无法与服务端建立连接", err)
 		return err
 	}
 
+	c.addr = addr
 	c.conn = conn
 	return nil
 }
 
+// send 向服务端发送一条消息
+func (c *Client) send(msg *protocol.Message) error {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return protocol.SendMsg(c.conn, msg)
+}
+
+// 从登录结果中取出用户名
+func (c *Client) loginUsername(content interface{}) string {
+	var result protocol.LoginResult
+	if err := protocol.DecodeContent(content, &result); err != nil {
+		return ""
+	}
+	return result.Username
+}
+
+// 设置会话令牌
+func (c *Client) setToken(content interface{}) string {
+	var result protocol.LoginResult
+	if err := protocol.DecodeContent(content, &result); err != nil {
+		fmt.Println("解析登录结果失败:", err)
+		return ""
+	}
+	c.connMu.Lock()
+	c.token = result.Token
+	c.connMu.Unlock()
+	return result.Welcome
+}
+
+// 断线后最多重连的次数
+const maxReconnect = 5
+
+// reconnect 断线后重新建立连接，并凭会话令牌恢复登录
+// 成功时返回新连接的reader
+func (c *Client) reconnect() (*bufio.Reader, bool) {
+	c.connMu.RLock()
+	token := c.token
+	c.connMu.RUnlock()
+	if token == "" && !c.certLogin {
+		return nil, false
+	}
+
+	for i := 1; i <= maxReconnect; i++ {
+		//已经退出就不再重连
+		select {
+		case <-c.quit:
+			return nil, false
+		case <-time.After(time.Duration(i) * time.Second):
+		}
+		fmt.Printf("与服务端的连接断开，正在进行第%d次重连...\n", i)
+
+		conn, err := c.dial(c.addr)
+		if err != nil {
+			continue
+		}
+		reader := bufio.NewReader(conn)
+		//证书登录的客户端连上后服务端直接登录，其他客户端凭令牌恢复会话
+		if !c.certLogin {
+			if err := protocol.SendMsg(conn, &protocol.Message{Type: "resume", Content: token}); err != nil {
+				_ = conn.Close()
+				continue
+			}
+		}
+		msg, err := protocol.ReadMsg(reader)
+		if err != nil {
+			_ = conn.Close()
+			continue
+		}
+		if msg.Type != "resume_success" && msg.Type != "login_success" {
+			_ = conn.Close()
+			fmt.Println("恢复会话失败:", msg.Content)
+			//令牌失效后重试也没有意义
+			if msg.Content != "用户在线中" {
+				return nil, false
+			}
+			continue
+		}
+
+		//替换连接，之后的发送都走新连接
+		c.connMu.Lock()
+		old := c.conn
+		c.conn = conn
+		c.connMu.Unlock()
+		_ = old.Close()
+		c.setToken(msg.Content)
+		fmt.Println("重连成功")
+		return reader, true
+	}
+	return nil, false
+}
+
 // Start 客户端协程启动
 // 注意：保持与原来一致——Start(true) 会只启动 readLoop，Start(false) 会同时启动 readLoop 与 handleMessages。
 // （避免大范围重构以满足“别全部改”的要求）
@@ -80,6 +189,11 @@ func (c *Client) readLoop() {
 		default:
 			msg, err := protocol.ReadMsg(reader)
 			if err != nil {
+				//已登录的用户先尝试凭令牌重连
+				if r, ok := c.reconnect(); ok {
+					reader = r
+					continue
+				}
 				fmt.Println("读取消息错误", err)
 				//用once.Do保证quit只关闭一次
 				c.once.Do(func() {
@@ -129,7 +243,11 @@ func (c *Client) Logout() error {
 	msg := &protocol.Message{
 		Type: "logout",
 	}
-	err := protocol.SendMsg(c.conn, msg)
+	err := c.send(msg)
+	//登出后令牌已被服务端吊销，不再重连
+	c.connMu.Lock()
+	c.token = ""
+	c.connMu.Unlock()
 	//登出要通知所有协程结束，用once来保证只关闭一次quit
 	c.once.Do(func() {
 		close(c.quit)
@@ -142,8 +260,12 @@ func (c *Client) Close() {
 	c.once.Do(func() {
 		close(c.quit)
 	})
-	if c.conn != nil {
-		if err := c.conn.Close(); err != nil {
+	c.connMu.Lock()
+	c.token = ""
+	conn := c.conn
+	c.connMu.Unlock()
+	if conn != nil {
+		if err := conn.Close(); err != nil {
 			fmt.Printf("关闭连接失败:%s", err)
 		}
 	}
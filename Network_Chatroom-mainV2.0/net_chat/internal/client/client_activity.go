@@ -30,5 +30,5 @@ func (c *Client) RequestActivityRanking(inputLines <-chan string) error {
 	msg := &protocol.Message{
 		Type: msgType,
 	}
-	return protocol.SendMsg(c.conn, msg)
+	return c.send(msg)
 }
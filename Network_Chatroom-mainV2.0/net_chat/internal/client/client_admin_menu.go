@@ -0,0 +1,58 @@
+package client
+
+import (
+	"fmt"
+	"net_chat/internal/protocol"
+	"strings"
+)
+
+// AdminMenu 管理员功能
+func (c *Client) AdminMenu(inputLines <-chan string) {
+	fmt.Println("\n======= 管理员功能 =======")
+	fmt.Println("1. 重置用户密码")
+	fmt.Println("2. 设置用户的邀请额度")
+	fmt.Println("3. 查看连接统计")
+	fmt.Println("4. 重建搜索索引")
+	fmt.Print("请选择操作(输入exit返回): ")
+	line, ok := <-inputLines
+	if !ok {
+		return
+	}
+	switch strings.TrimSpace(line) {
+	case "1":
+		if err := c.ResetPassword(inputLines); err != nil {
+			fmt.Println("[错误]重置密码失败", err)
+		}
+	case "2":
+		if err := c.SetInviteQuota(inputLines); err != nil {
+			fmt.Println("[错误]设置邀请额度失败", err)
+		}
+	case "3":
+		if err := c.send(&protocol.Message{Type: "stats"}); err != nil {
+			fmt.Println("[错误]请求连接统计失败", err)
+		}
+	case "4":
+		if err := c.send(&protocol.Message{Type: "search_reindex"}); err != nil {
+			fmt.Println("[错误]请求重建搜索索引失败", err)
+		}
+	case "exit":
+	default:
+		fmt.Println("无效选择")
+	}
+}
+
+// 打印服务端的连接统计
+func printStats(content interface{}) {
+	var stats map[string]int64
+	if err := protocol.DecodeContent(content, &stats); err != nil {
+		fmt.Println("[错误] 解析连接统计失败:", err)
+		return
+	}
+	fmt.Println("\n======= 连接统计 =======")
+	fmt.Println("当前连接数:", stats["active"])
+	fmt.Println("累计接受:", stats["accepted"])
+	fmt.Println("黑白名单拒绝:", stats["rejected_denied"])
+	fmt.Println("总数已满拒绝:", stats["rejected_total"])
+	fmt.Println("单IP超限拒绝:", stats["rejected_per_ip"])
+	fmt.Println("速率过快拒绝:", stats["rejected_rate"])
+}
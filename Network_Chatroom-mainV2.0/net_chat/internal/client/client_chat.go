@@ -10,7 +10,7 @@ func (c *Client) SendChatMessage(content string, to string) error {
 		From:    c.username,
 		To:      to,
 	}
-	err := protocol.SendMsg(c.conn, msg)
+	err := c.send(msg)
 	if err != nil {
 		return err
 	}
@@ -8,6 +8,12 @@ import (
 func ShowChatRoom(client *Client, inputLines <-chan string) {
 	fmt.Println("\n======= 在聊天室中发送消息 =======")
 	fmt.Println("输入消息并按回车发送，输入 'exit' 不再发送消息")
+	fmt.Println(editHelp)
+	fmt.Println(replyHelp)
+	fmt.Println(reactionHelp)
+	//进入和离开聊天室时都把消息标记为已读，停留期间收到的消息已经显示过
+	client.markRoomRead(mainRoom)
+	defer client.markRoomRead(mainRoom)
 
 	for {
 		// 直接阻塞读取下一行（在任意时刻，只有 main 路径在阻塞读 inputLines）
@@ -23,6 +29,10 @@ func ShowChatRoom(client *Client, inputLines <-chan string) {
 		if input == "exit" {
 			return
 		}
+		if client.handleEditCommand(input, "") || client.handleReplyCommand(input, "") ||
+			client.handleReactionCommand(input, "") {
+			continue
+		}
 		if err := client.SendChatMessage(input, ""); err != nil {
 			fmt.Println("[错误] 发送消息失败:", err)
 		}
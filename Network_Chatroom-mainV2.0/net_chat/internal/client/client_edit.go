@@ -0,0 +1,84 @@
+package client
+
+import (
+	"fmt"
+	"net_chat/internal/protocol"
+	"regexp"
+	"strings"
+)
+
+// 消息ID的格式，用来区分/edit后面跟的是ID还是内容
+var messageIDPattern = regexp.MustCompile(`^\d+-\d+$`)
+
+const editHelp = "输入 '/edit 新内容' 修改刚发送的消息，'/recall' 撤回刚发送的消息，也可以在命令后指定消息ID"
+
+// 记下自己在聊天室（peer为空）或与peer的私聊中最后发送的消息ID
+func (c *Client) rememberSent(peer, id string) {
+	if id == "" {
+		return
+	}
+	c.sentMu.Lock()
+	if c.lastSent == nil {
+		c.lastSent = make(map[string]string)
+	}
+	c.lastSent[peer] = id
+	c.sentMu.Unlock()
+}
+
+func (c *Client) lastSentID(peer string) string {
+	c.sentMu.Lock()
+	defer c.sentMu.Unlock()
+	return c.lastSent[peer]
+}
+
+// 处理聊天中的/edit和/recall命令，不是这两个命令时返回false
+// 格式：/edit [消息ID] 新内容，/recall [消息ID]，不指定ID时操作自己最后发送的消息
+func (c *Client) handleEditCommand(input, peer string) bool {
+	cmd, rest, _ := strings.Cut(input, " ")
+	if cmd != "/edit" && cmd != "/recall" {
+		return false
+	}
+	rest = strings.TrimSpace(rest)
+	id := ""
+	if first, remain, _ := strings.Cut(rest, " "); messageIDPattern.MatchString(first) {
+		id, rest = first, strings.TrimSpace(remain)
+	}
+	if id == "" {
+		if id = c.lastSentID(peer); id == "" {
+			fmt.Println("[错误] 还没有发送过消息，请指定消息ID")
+			return true
+		}
+	}
+	req := protocol.EditRequest{ID: id, Peer: peer}
+	msgType := "delete_message"
+	if cmd == "/edit" {
+		if rest == "" {
+			fmt.Println("[错误] 请输入修改后的内容")
+			return true
+		}
+		req.Content = rest
+		msgType = "edit_message"
+	}
+	if err := c.send(&protocol.Message{Type: msgType, Content: req}); err != nil {
+		fmt.Println("[错误] 发送请求失败:", err)
+	}
+	return true
+}
+
+// 打印消息被修改或撤回的通知
+func printMessageUpdate(msg *protocol.Message) {
+	var u protocol.MessageUpdate
+	if err := protocol.DecodeContent(msg.Content, &u); err != nil {
+		fmt.Println("[错误] 无法解析消息更新:", err)
+		return
+	}
+	who := u.Sender
+	if u.EditedBy != u.Sender {
+		who = fmt.Sprintf("%s(由%s)", u.Sender, u.EditedBy)
+	}
+	if u.Deleted {
+		fmt.Printf("[系统] %s 撤回了一条消息(%s)\n", who, u.ID)
+		return
+	}
+	fmt.Printf("[系统] %s 修改了消息(%s): %s\n", who, u.ID, u.Content)
+}
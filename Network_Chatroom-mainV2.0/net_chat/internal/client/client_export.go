@@ -0,0 +1,126 @@
+package client
+
+import (
+	"fmt"
+	"net_chat/internal/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exportState 正在接收的导出文件，只在处理消息的协程中访问
+type exportState struct {
+	id   string
+	seq  int
+	path string
+	file *os.File
+}
+
+// Export 导出聊天室或私聊记录到本地文件
+func (c *Client) Export(inputLines <-chan string) error {
+	fmt.Println("请输入导出条件，格式为 聊天室名或@用户名|格式|开始日期|结束日期，格式可选json、csv、md、html，日期格式为2006-01-02，留空表示不限")
+	fmt.Print("例如 main_room|html|2024-01-01|2024-01-31 或 @bob|md||(输入exit取消)：")
+	line, ok := <-inputLines
+	if !ok {
+		return fmt.Errorf("无法得到用户输入")
+	}
+	line = strings.TrimSpace(line)
+	if line == "" || line == "exit" {
+		return nil
+	}
+	fields := strings.Split(line, "|")
+	for len(fields) < 4 {
+		fields = append(fields, "")
+	}
+	_, offset := time.Now().Zone()
+	req := protocol.ExportRequest{
+		Format:   strings.TrimSpace(fields[1]),
+		TzOffset: offset,
+	}
+	if target := strings.TrimSpace(fields[0]); strings.HasPrefix(target, "@") {
+		req.Peer = strings.TrimPrefix(target, "@")
+	} else {
+		req.Room = target
+	}
+	if req.Format == "" {
+		req.Format = "html"
+	}
+	if day := strings.TrimSpace(fields[2]); day != "" {
+		t, err := time.ParseInLocation("2006-01-02", day, time.Local)
+		if err != nil {
+			return fmt.Errorf("开始日期格式错误")
+		}
+		req.From = t.Unix()
+	}
+	if day := strings.TrimSpace(fields[3]); day != "" {
+		t, err := time.ParseInLocation("2006-01-02", day, time.Local)
+		if err != nil {
+			return fmt.Errorf("结束日期格式错误")
+		}
+		//包含结束日期当天
+		req.To = t.AddDate(0, 0, 1).Unix() - 1
+	}
+	if err := c.send(&protocol.Message{Type: "export", Content: req}); err != nil {
+		return err
+	}
+	fmt.Println("[系统] 已提交导出请求，完成后会提示文件位置")
+	return nil
+}
+
+// 收到一段导出内容，第一段时创建文件，最后一段时关闭文件
+func (c *Client) writeExportChunk(content interface{}) {
+	var chunk protocol.ExportChunk
+	if err := protocol.DecodeContent(content, &chunk); err != nil {
+		fmt.Println("[错误] 无法解析导出内容:", err)
+		return
+	}
+	if chunk.Seq == 0 {
+		c.abortExport()
+		//只使用文件名部分，避免写到当前目录之外
+		name := filepath.Base(filepath.Clean(chunk.Filename))
+		if name == "." || name == string(filepath.Separator) {
+			name = "export_" + chunk.ID
+		}
+		file, err := os.Create(name)
+		if err != nil {
+			fmt.Println("[错误] 无法创建导出文件:", err)
+			return
+		}
+		c.export = &exportState{id: chunk.ID, path: name, file: file}
+	}
+	e := c.export
+	if e == nil || e.id != chunk.ID || e.seq != chunk.Seq {
+		//缺少前面的内容，文件已不完整
+		if e != nil && e.id == chunk.ID {
+			fmt.Println("[错误] 导出内容不完整，已放弃")
+			c.abortExport()
+		}
+		return
+	}
+	if _, err := e.file.WriteString(chunk.Data); err != nil {
+		fmt.Println("[错误] 写入导出文件失败:", err)
+		c.abortExport()
+		return
+	}
+	e.seq++
+	if chunk.Done {
+		if err := e.file.Close(); err != nil {
+			fmt.Println("[错误] 保存导出文件失败:", err)
+		} else {
+			path, _ := filepath.Abs(e.path)
+			fmt.Printf("[系统] 导出完成，共%d条消息，已保存到%s\n", chunk.Count, path)
+		}
+		c.export = nil
+	}
+}
+
+// 导出失败或被新的导出取代时删除不完整的文件
+func (c *Client) abortExport() {
+	if c.export == nil {
+		return
+	}
+	_ = c.export.file.Close()
+	_ = os.Remove(c.export.path)
+	c.export = nil
+}
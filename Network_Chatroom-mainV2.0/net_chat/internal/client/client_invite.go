@@ -0,0 +1,41 @@
+package client
+
+import (
+	"fmt"
+	"net_chat/internal/protocol"
+	"strings"
+)
+
+// CreateInvite 生成邀请码
+func (c *Client) CreateInvite(inputLines <-chan string) error {
+	fmt.Print("请输入可使用次数和有效小时数(格式为次数|小时，直接回车使用默认值1次、72小时)(输入exit取消)：")
+	line, ok := <-inputLines
+	if !ok {
+		return fmt.Errorf("无法得到用户输入")
+	}
+	line = strings.TrimSpace(line)
+	if line == "exit" {
+		return nil
+	}
+	return c.send(&protocol.Message{
+		Type:    "invite_create",
+		Content: line,
+	})
+}
+
+// SetInviteQuota 管理员设置用户的邀请额度
+func (c *Client) SetInviteQuota(inputLines <-chan string) error {
+	fmt.Print("请输入用户名和额度(格式为用户名|额度)(输入exit取消)：")
+	line, ok := <-inputLines
+	if !ok {
+		return fmt.Errorf("无法得到用户输入")
+	}
+	line = strings.TrimSpace(line)
+	if line == "" || line == "exit" {
+		return nil
+	}
+	return c.send(&protocol.Message{
+		Type:    "invite_quota",
+		Content: line,
+	})
+}
@@ -11,7 +11,7 @@ func (c *Client) RequestUserList(inputLines <-chan string) error {
 	msg := &protocol.Message{
 		Type: "list",
 	}
-	if err := protocol.SendMsg(c.conn, msg); err != nil {
+	if err := c.send(msg); err != nil {
 		return err
 	}
 
@@ -38,35 +38,165 @@ func (c *Client) Login(inputLines <-chan string) error {
 			continue
 		}
 
-		// 发送登录请求
-		err := protocol.SendMsg(c.conn, &protocol.Message{
-			Type:    "login",
-			Content: userinfo,
-		})
-		if err != nil {
-			return fmt.Errorf("发送登录请求失败：%v", err)
+		// 优先使用挑战-应答登录，密码不会经过网络
+		// 只有用户明确选择，或者服务端在挑战之前就说明只支持密码登录时，才发送密码
+		var msg *protocol.Message
+		var serverSig string
+		var err error
+		if !c.legacy {
+			if msg, serverSig, err = c.loginWithProof(username, password); err != nil {
+				return err
+			}
+			if msg.Type == "login_legacy" {
+				fmt.Println(msg.Content)
+			}
+		}
+		if c.legacy || msg.Type == "login_legacy" {
+			err := c.send(&protocol.Message{
+				Type:    "login",
+				Content: userinfo,
+			})
+			if err != nil {
+				return fmt.Errorf("发送登录请求失败：%v", err)
+			}
+			if msg, err = c.waitMsg(); err != nil {
+				return err
+			}
+		}
+
+		// 开启了两步验证的账号还需要输入验证码
+		if msg.Type == "login_totp_required" {
+			if msg, err = c.loginTOTP(inputLines, msg); err != nil {
+				return err
+			}
+		}
+		// 挑战-应答登录时校验服务端签名，确认对方确实持有自己的校验值
+		if msg.Type == "login_success" && serverSig != "" {
+			var result protocol.LoginResult
+			if err := protocol.DecodeContent(msg.Content, &result); err != nil || result.ServerSignature != serverSig {
+				return fmt.Errorf("服务端身份校验失败")
+			}
 		}
 
-		// 等待服务器响应（保留你原先的方式：直接从 c.msgChan 读取）
-		select {
-		case msg := <-c.msgChan:
-			if msg.Type == "login_success" {
-				c.username = username
-				fmt.Println(msg.Content) // 欢迎信息
-				return nil               // 登录成功，退出循环
-			} else if msg.Type == "login_fail" {
-				fmt.Println("登录失败：", msg.Content)
-				// 不退出循环，重新获取用户名
-			} else {
-				// 既不是 login_success 也不是 login_fail，打印并继续等待下一次输入
-				fmt.Println("收到非登录类型消息（忽略）:", msg.Type)
+		if msg.Type == "login_success" {
+			c.username = username
+			//只保存服务端签发的令牌，不保存密码，断线后用令牌重连
+			fmt.Println(c.setToken(msg.Content)) // 欢迎信息
+			return nil                           // 登录成功，退出循环
+		} else if msg.Type == "login_fail" {
+			fmt.Println("登录失败：", msg.Content)
+			if serverSig != "" {
+				fmt.Println("很久没有登录的老账号需要设置CHAT_LEGACY_LOGIN=true后用密码登录一次完成升级")
 			}
-		case <-c.quit:
-			return fmt.Errorf("客户端已退出")
+			// 不退出循环，重新获取用户名
+		} else {
+			// 既不是 login_success 也不是 login_fail，打印并继续等待下一次输入
+			fmt.Println("收到非登录类型消息（忽略）:", msg.Type)
 		}
 	}
 }
 
+// SetLegacyLogin 直接用密码登录，供还没有挑战-应答校验值的老账号升级使用
+func (c *Client) SetLegacyLogin(on bool) {
+	c.legacy = on
+}
+
+// GuestLogin 访客登录，服务端会分配一个临时用户名
+func (c *Client) GuestLogin() error {
+	if err := c.send(&protocol.Message{Type: "guest_login"}); err != nil {
+		return fmt.Errorf("发送访客登录请求失败：%v", err)
+	}
+	msg, err := c.waitMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Type != "login_success" {
+		return fmt.Errorf("%v", msg.Content)
+	}
+	var result protocol.LoginResult
+	if err := protocol.DecodeContent(msg.Content, &result); err != nil {
+		return fmt.Errorf("解析登录结果失败：%v", err)
+	}
+	c.username = result.Username
+	c.guest = true
+	fmt.Println(result.Welcome)
+	fmt.Println("当前为访客身份，只能在开放的聊天室中阅读和发言")
+	return nil
+}
+
+// loginWithProof 挑战-应答登录，返回服务端最后的回复和期望的服务端签名
+func (c *Client) loginWithProof(username, password string) (*protocol.Message, string, error) {
+	clientNonce, err := protocol.NewNonce()
+	if err != nil {
+		return nil, "", fmt.Errorf("生成随机数失败：%v", err)
+	}
+	err = c.send(&protocol.Message{
+		Type:    "login_start",
+		Content: protocol.ScramStart{Username: username, ClientNonce: clientNonce},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("发送登录请求失败：%v", err)
+	}
+	msg, err := c.waitMsg()
+	if err != nil || msg.Type != "login_challenge" {
+		return msg, "", err
+	}
+
+	var challenge protocol.ScramChallenge
+	if err := protocol.DecodeContent(msg.Content, &challenge); err != nil {
+		return nil, "", fmt.Errorf("解析登录挑战失败：%v", err)
+	}
+	// 服务端的随机数必须以自己的随机数开头，防止重放旧的挑战
+	if !strings.HasPrefix(challenge.Nonce, clientNonce) {
+		return nil, "", fmt.Errorf("服务端返回的挑战无效")
+	}
+	proof, serverSig, err := protocol.ScramClientProof(username, password, clientNonce, &challenge)
+	if err != nil {
+		return nil, "", fmt.Errorf("计算登录证明失败：%v", err)
+	}
+	err = c.send(&protocol.Message{
+		Type:    "login_proof",
+		Content: protocol.ScramProof{Proof: proof},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("发送登录证明失败：%v", err)
+	}
+	msg, err = c.waitMsg()
+	return msg, serverSig, err
+}
+
+// loginTOTP 输入两步验证码，直到登录成功或服务端要求重新登录
+func (c *Client) loginTOTP(inputLines <-chan string, msg *protocol.Message) (*protocol.Message, error) {
+	for msg.Type == "login_totp_required" || msg.Type == "login_totp_fail" {
+		fmt.Print(msg.Content, "：")
+		code, ok := <-inputLines
+		if !ok {
+			return nil, fmt.Errorf("无法得到用户输入（输入通道已关闭）")
+		}
+		err := c.send(&protocol.Message{
+			Type:    "login_totp",
+			Content: strings.TrimSpace(code),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("发送验证码失败：%v", err)
+		}
+		if msg, err = c.waitMsg(); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// waitMsg 登录、注册阶段还没有启动handleMessages，直接从 c.msgChan 读取服务端的回复
+func (c *Client) waitMsg() (*protocol.Message, error) {
+	select {
+	case msg := <-c.msgChan:
+		return msg, nil
+	case <-c.quit:
+		return nil, fmt.Errorf("客户端已退出")
+	}
+}
+
 // 简单的用户名和密码校验函数
 func validateUserinfo(userinfo string) error {
 	if userinfo == "" {
@@ -9,6 +9,7 @@ func (c *Client) LoginRegisterMenu(inputLines <-chan string) {
 	for {
 		fmt.Println("1.登录")
 		fmt.Println("2.注册")
+		fmt.Println("3.访客登录")
 		line, ok := <-inputLines
 		if !ok {
 			// stdin 关闭或 goroutine 结束
@@ -18,7 +19,7 @@ func (c *Client) LoginRegisterMenu(inputLines <-chan string) {
 		fmt.Printf("[调试 ] 接收到的输入: '%s' (长度: %d)\n", choice, len(choice))
 		// 处理空输入（用户直接按回车）
 		if choice == "" {
-			fmt.Println("请输入有效数字（1-2）")
+			fmt.Println("请输入有效数字（1-3）")
 			continue
 		}
 		switch choice {
@@ -38,10 +39,16 @@ func (c *Client) LoginRegisterMenu(inputLines <-chan string) {
 			} else {
 				continue
 			}
+		case "3":
+			if err := c.GuestLogin(); err != nil {
+				fmt.Println("访客登录失败：", err)
+				continue
+			}
+			c.Start(false)
 		default:
 			fmt.Println("无效选择，请重新输入")
 		}
-		if choice == "1" && c.username != "" {
+		if (choice == "1" || choice == "3") && c.username != "" {
 			break
 		}
 
@@ -13,7 +13,15 @@ func (c *Client) MainMenu(inputLines <-chan string) {
 		fmt.Println("3. 显示在线用户列表")
 		fmt.Println("4. 查看活跃度排行")
 		fmt.Println("5. 查看聊天室的最近消息")
-		fmt.Println("6. 退出")
+		fmt.Println("6. 修改密码")
+		fmt.Println("7. 开启两步验证")
+		fmt.Println("8. 生成邀请码")
+		fmt.Println("9. 管理员功能")
+		fmt.Println("10. 查看聊天室未读消息")
+		fmt.Println("11. 搜索聊天记录")
+		fmt.Println("12. 导出聊天记录")
+		fmt.Println("13. 查看提到我的消息")
+		fmt.Println("14. 退出")
 		fmt.Print("请选择操作: ")
 
 		line, ok := <-inputLines
@@ -25,7 +33,7 @@ func (c *Client) MainMenu(inputLines <-chan string) {
 		fmt.Printf("[调试 ] 接收到的输入: '%s' (长度: %d)\n", choice, len(choice))
 		// 处理空输入（用户直接按回车）
 		if choice == "" {
-			fmt.Println("请输入有效数字（1-4）")
+			fmt.Println("请输入有效数字（1-14）")
 			continue
 		} // 去前后空格
 		switch choice {
@@ -48,6 +56,36 @@ func (c *Client) MainMenu(inputLines <-chan string) {
 				fmt.Println("[错误]请求历史消息失败", err)
 			}
 		case "6":
+			if err := c.ChangePassword(inputLines); err != nil {
+				fmt.Println("[错误]修改密码失败", err)
+			}
+		case "7":
+			if err := c.EnableTOTP(inputLines); err != nil {
+				fmt.Println("[错误]开启两步验证失败", err)
+			}
+		case "8":
+			if err := c.CreateInvite(inputLines); err != nil {
+				fmt.Println("[错误]生成邀请码失败", err)
+			}
+		case "9":
+			c.AdminMenu(inputLines)
+		case "10":
+			if err := c.RequestRoomUnread(); err != nil {
+				fmt.Println("[错误]查询未读消息失败", err)
+			}
+		case "11":
+			if err := c.Search(inputLines); err != nil {
+				fmt.Println("[错误]搜索失败", err)
+			}
+		case "12":
+			if err := c.Export(inputLines); err != nil {
+				fmt.Println("[错误]导出失败", err)
+			}
+		case "13":
+			if err := c.RequestMentions(inputLines); err != nil {
+				fmt.Println("[错误]查看提及失败", err)
+			}
+		case "14":
 			err := c.Logout()
 			if err != nil {
 				fmt.Println("无法正常退出", err)
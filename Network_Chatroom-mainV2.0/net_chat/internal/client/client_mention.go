@@ -0,0 +1,64 @@
+package client
+
+import (
+	"fmt"
+	"net_chat/internal/protocol"
+	"strings"
+)
+
+// RequestMentions 查看提到自己的消息
+func (c *Client) RequestMentions(inputLines <-chan string) error {
+	if c.guest {
+		return fmt.Errorf("访客无法使用该功能")
+	}
+	fmt.Print("直接回车查看最新的提及，输入消息ID查看更早的提及(输入exit取消)：")
+	line, ok := <-inputLines
+	if !ok {
+		return fmt.Errorf("无法得到用户输入")
+	}
+	line = strings.TrimSpace(line)
+	if line == "exit" {
+		return nil
+	}
+	if line != "" && !messageIDPattern.MatchString(line) {
+		return fmt.Errorf("消息ID格式错误")
+	}
+	return c.send(&protocol.Message{Type: "mentions", Content: protocol.MentionQuery{Before: line}})
+}
+
+// 有人在聊天中提到了自己
+func printMention(content interface{}) {
+	var m protocol.Mention
+	if err := protocol.DecodeContent(content, &m); err != nil {
+		fmt.Println("[错误] 无法解析提及:", err)
+		return
+	}
+	fmt.Printf("\n[提及] %s 在聊天室 %s 中提到了你: %s <%s>\n",
+		m.Message.Sender, m.Message.Room, m.Message.Content, m.Message.ID)
+}
+
+// 打印提到自己的消息列表
+func printMentions(content interface{}) {
+	var list protocol.MentionList
+	if err := protocol.DecodeContent(content, &list); err != nil {
+		fmt.Println("[错误] 无法解析提及:", err)
+		return
+	}
+	if len(list.Mentions) == 0 {
+		fmt.Println("[系统] 没有提到你的消息")
+		return
+	}
+	fmt.Printf("\n---- 提到你的消息(%d条未读) ----\n", list.Unseen)
+	for _, m := range list.Mentions {
+		mark := ""
+		if m.Unseen {
+			mark = "[新] "
+		}
+		fmt.Printf("%s聊天室 %s\n", mark, m.Message.Room)
+		printChatMessages([]protocol.ChatMessage{m.Message})
+	}
+	if list.HasMore {
+		last := list.Mentions[len(list.Mentions)-1].Message.ID
+		fmt.Printf("(还有更早的提及，输入消息ID %s 继续查看)\n", last)
+	}
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"fmt"
+	"net_chat/internal/protocol"
+	"strings"
+)
+
+// ChangePassword 修改密码
+func (c *Client) ChangePassword(inputLines <-chan string) error {
+	fmt.Print("请输入当前密码和新密码(格式为旧密码|新密码)(输入exit取消)：")
+	line, ok := <-inputLines
+	if !ok {
+		return fmt.Errorf("无法得到用户输入")
+	}
+	line = strings.TrimSpace(line)
+	if line == "exit" {
+		return nil
+	}
+	parts := strings.Split(line, "|")
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return fmt.Errorf("格式错误，请使用‘旧密码|新密码’的格式")
+	}
+	return c.send(&protocol.Message{
+		Type:    "change_password",
+		Content: line,
+	})
+}
+
+// ResetPassword 管理员为用户重置密码
+func (c *Client) ResetPassword(inputLines <-chan string) error {
+	fmt.Print("请输入要重置密码的用户名(输入exit取消)：")
+	line, ok := <-inputLines
+	if !ok {
+		return fmt.Errorf("无法得到用户输入")
+	}
+	target := strings.TrimSpace(line)
+	if target == "" || target == "exit" {
+		return nil
+	}
+	return c.send(&protocol.Message{
+		Type:    "reset_password",
+		Content: target,
+	})
+}
@@ -25,14 +25,19 @@ func ShowPrivateChat(client *Client, inputLines <-chan string) {
 		return
 	}
 
-	fmt.Printf("与 %s 私聊中，输入消息并按回车发送，输入 'exit' 退出私聊\n", targetUser)
+	fmt.Printf("与 %s 私聊中，输入消息并按回车发送，输入 '/history' 翻看聊天记录，输入 'exit' 退出私聊\n", targetUser)
+	fmt.Println(editHelp)
+	fmt.Println(replyHelp)
+	fmt.Println(reactionHelp)
+	client.setChatPeer(targetUser)
+	defer client.setChatPeer("")
 
 	msg := &protocol.Message{
 		Type: "privatebegin",
 		From: client.username,
 		To:   targetUser,
 	}
-	err := protocol.SendMsg(client.conn, msg)
+	err := client.send(msg)
 	if err != nil {
 		fmt.Println("发送消息时发生错误", err)
 	}
@@ -49,6 +54,17 @@ func ShowPrivateChat(client *Client, inputLines <-chan string) {
 		if input == "exit" {
 			return
 		}
+		if input == "/history" {
+			if err := client.browseHistory(inputLines, targetUser); err != nil {
+				fmt.Println("[错误] 获取聊天记录失败，", err)
+			}
+			fmt.Printf("继续与 %s 私聊\n", targetUser)
+			continue
+		}
+		if client.handleEditCommand(input, targetUser) || client.handleReplyCommand(input, targetUser) ||
+			client.handleReactionCommand(input, targetUser) {
+			continue
+		}
 		if err := client.SendChatMessage(input, targetUser); err != nil {
 			fmt.Println("[错误] 发送失败，", err)
 		}
@@ -61,3 +77,52 @@ func ShowPrivateChat(client *Client, inputLines <-chan string) {
 		}
 	}
 }
+
+// 打印登录时收到的离线私聊，并确认送达
+func (c *Client) ackOfflineMessages(content interface{}) {
+	var batch protocol.OfflineMessages
+	if err := protocol.DecodeContent(content, &batch); err != nil {
+		fmt.Println("[错误] 无法解析离线消息:", err)
+		return
+	}
+	if len(batch.Messages) == 0 {
+		return
+	}
+	fmt.Printf("\n---- 来自 %s 的%d条离线私聊 ----\n", batch.From, len(batch.Messages))
+	printChatMessages(batch.Messages)
+	last := batch.Messages[len(batch.Messages)-1].ID
+	c.rememberReceived(batch.From, last)
+	c.ackPrivate(batch.From, last)
+}
+
+func (c *Client) setChatPeer(peer string) {
+	c.peerMu.Lock()
+	c.chatPeer = peer
+	c.peerMu.Unlock()
+}
+
+// 确认收到from发来的消息，正在与其私聊时同时确认已读
+func (c *Client) ackPrivate(from, id string) {
+	if id == "" {
+		return
+	}
+	if err := c.send(&protocol.Message{Type: "pm_delivered", To: from, ID: id}); err != nil {
+		return
+	}
+	c.peerMu.Lock()
+	reading := c.chatPeer == from
+	c.peerMu.Unlock()
+	if reading {
+		_ = c.send(&protocol.Message{Type: "pm_read", To: from, ID: id})
+	}
+}
+
+// 打印对方的送达/已读回执
+func printReceipt(msg *protocol.Message) {
+	switch msg.Content {
+	case "delivered":
+		fmt.Printf("[回执] %s 已收到你的消息\n", msg.From)
+	case "read":
+		fmt.Printf("[回执] %s 已读你的消息\n", msg.From)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net_chat/internal/protocol"
 	"strings"
+	"time"
 )
 
 // 具体处理方法
@@ -14,17 +15,45 @@ func (c *Client) processMessages(msg *protocol.Message) {
 	case "register_fail":
 		fmt.Println("注册失败", msg.Content)
 	case "login_success":
-		fmt.Println(msg.Content)
+		fmt.Println(c.setToken(msg.Content))
 	case "login_fail":
 		fmt.Println("登录失败", msg.Content)
 	case "notice":
 		fmt.Println("\n系统通知", msg.Content)
 	case "chat":
-		fmt.Printf("[%s]:%s\n", msg.From, msg.Content)
+		if msg.ReplyTo != nil {
+			fmt.Println(formatReply(msg.ReplyTo))
+		}
+		fmt.Printf("%s[%s]:%s\n", formatTs(msg.Ts), msg.From, msg.Content)
+		if msg.From == c.username {
+			c.rememberSent("", msg.ID)
+		} else {
+			c.rememberReceived("", msg.ID)
+		}
 	case "private_chat":
-		fmt.Printf("[私聊][%s]:%s\n", msg.From, msg.Content)
+		if msg.ReplyTo != nil {
+			fmt.Println(formatReply(msg.ReplyTo))
+		}
+		fmt.Printf("%s[私聊][%s]:%s\n", formatTs(msg.Ts), msg.From, msg.Content)
+		c.rememberReceived(msg.From, msg.ID)
+		c.ackPrivate(msg.From, msg.ID)
+	case "offline_messages":
+		c.ackOfflineMessages(msg.Content)
+	case "pm_status":
+		printReceipt(msg)
 	case "private_chat_sent":
 		fmt.Println("[系统]:", msg.Content)
+		c.rememberSent(msg.To, msg.ID)
+	case "message_edited", "message_deleted":
+		printMessageUpdate(msg)
+	case "thread":
+		printThread(msg.Content)
+	case "reaction_update":
+		printReactionUpdate(msg)
+	case "mention":
+		printMention(msg.Content)
+	case "mentions":
+		printMentions(msg.Content)
 	case "error":
 		fmt.Println("[错误]", msg.Content)
 	case "user_list":
@@ -40,11 +69,42 @@ func (c *Client) processMessages(msg *protocol.Message) {
 	case "activitytotal":
 		fmt.Println("总榜")
 		fmt.Println(msg.Content)
-	case "recent_room_messages":
-		fmt.Println("最近聊天记录(输入exit退出查看):")
-		fmt.Println(msg.Content)
-	case "recent_private_messages":
-		fmt.Println(msg.Content)
+	case "recent_room_messages", "recent_private_messages":
+		c.showHistory(msg.Content)
+	case "room_unread":
+		printRoomUnread(msg.Content)
+	case "search_result":
+		printSearchResult(msg.Content)
+	case "search_fail":
+		fmt.Println("[错误] 搜索失败:", msg.Content)
+	case "export_chunk":
+		c.writeExportChunk(msg.Content)
+	case "export_fail":
+		c.abortExport()
+		fmt.Println("[错误] 导出失败:", msg.Content)
+	case "password_change_required":
+		fmt.Println("\n[系统]", msg.Content, "(请在主菜单选择修改密码)")
+	case "change_password_success":
+		//旧令牌已被吊销，保存新令牌
+		fmt.Println("[系统]", c.setToken(msg.Content))
+	case "change_password_fail":
+		fmt.Println("[错误] 修改密码失败:", msg.Content)
+	case "reset_password_success":
+		fmt.Println("[系统]", msg.Content)
+	case "reset_password_fail":
+		fmt.Println("[错误]", msg.Content)
+	case "totp_enroll":
+		printTOTPEnrollment(msg.Content)
+	case "totp_enabled":
+		fmt.Println("[系统]", msg.Content)
+	case "totp_fail":
+		fmt.Println("[错误]", msg.Content)
+	case "invite_created":
+		fmt.Println("[系统]", msg.Content)
+	case "invite_fail":
+		fmt.Println("[错误]", msg.Content)
+	case "stats":
+		printStats(msg.Content)
 	case "logout_success":
 		//用户退出后关闭所有客户端协程
 		fmt.Println(msg.Content)
@@ -57,3 +117,11 @@ func (c *Client) processMessages(msg *protocol.Message) {
 		fmt.Printf("[未知消息类型 %s] %s\n", msg.Type, msg.Content)
 	}
 }
+
+// 按本地时区显示服务端时间戳，没有时间戳时返回空
+func formatTs(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return "[" + time.Unix(ts, 0).Format("15:04:05") + "]"
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+	"net_chat/internal/protocol"
+	"strings"
+)
+
+const reactionHelp = "输入 '/react 表情' 给最近收到的消息添加表情，'/unreact 表情' 取消，也可以在表情前指定消息ID"
+
+// 处理聊天中的/react和/unreact命令，不是这两个命令时返回false
+// 格式：/react [消息ID] 表情，不指定ID时对最近收到的消息操作
+func (c *Client) handleReactionCommand(input, peer string) bool {
+	cmd, rest, _ := strings.Cut(input, " ")
+	if cmd != "/react" && cmd != "/unreact" {
+		return false
+	}
+	rest = strings.TrimSpace(rest)
+	id := ""
+	if first, remain, _ := strings.Cut(rest, " "); messageIDPattern.MatchString(first) {
+		id, rest = first, strings.TrimSpace(remain)
+	}
+	if id == "" {
+		if id = c.lastReceivedID(peer); id == "" {
+			fmt.Println("[错误] 还没有收到消息，请指定消息ID")
+			return true
+		}
+	}
+	if rest == "" {
+		fmt.Println("[错误] 请输入表情，例如 /react 👍")
+		return true
+	}
+	err := c.send(&protocol.Message{
+		Type:    strings.TrimPrefix(cmd, "/"),
+		Content: protocol.ReactionRequest{ID: id, Peer: peer, Emoji: rest},
+	})
+	if err != nil {
+		fmt.Println("[错误] 发送请求失败:", err)
+	}
+	return true
+}
+
+// 表情回应的摘要，如 👍×2(alice、bob) 🎉×1(cat)，每种表情最多列出3个用户
+func formatReactions(reactions []protocol.Reaction) string {
+	parts := make([]string, 0, len(reactions))
+	for _, r := range reactions {
+		users := r.Users
+		more := ""
+		if len(users) > 3 {
+			users, more = users[:3], "等"
+		}
+		parts = append(parts, fmt.Sprintf("%s×%d(%s%s)", r.Emoji, r.Count, strings.Join(users, "、"), more))
+	}
+	return strings.Join(parts, " ")
+}
+
+// 打印表情回应的变化
+func printReactionUpdate(msg *protocol.Message) {
+	var u protocol.ReactionUpdate
+	if err := protocol.DecodeContent(msg.Content, &u); err != nil {
+		fmt.Println("[错误] 无法解析表情回应:", err)
+		return
+	}
+	action := "添加了"
+	if !u.Added {
+		action = "取消了"
+	}
+	summary := formatReactions(u.Reactions)
+	if summary == "" {
+		summary = "无"
+	}
+	fmt.Printf("[表情] %s 对消息<%s>%s%s，当前: %s\n", u.User, u.ID, action, u.Emoji, summary)
+}
@@ -3,23 +3,127 @@ package client
 import (
 	"fmt"
 	"net_chat/internal/protocol"
+	"strings"
+	"time"
 )
 
-// RequestRecentMessages 在 client.go 中添加请求历史消息的方法
+// 浏览历史消息时的操作说明
+const historyHelp = "p 上一页(更早)  n 下一页(更新)  t 2006-01-02 15:04 跳转到该时间  exit 退出查看"
+
+// RequestRecentMessages 浏览聊天室的历史消息
 func (c *Client) RequestRecentMessages(inputLines <-chan string) error {
-	msg := &protocol.Message{
-		Type: "room_messages",
+	return c.browseHistory(inputLines, "")
+}
+
+// browseHistory 分页浏览聊天记录，peer为空时浏览聊天室，否则浏览与peer的私聊
+func (c *Client) browseHistory(inputLines <-chan string, peer string) error {
+	fmt.Println(historyHelp)
+	c.setHistory(protocol.HistoryPage{})
+	if err := c.requestHistory(peer, protocol.HistoryQuery{}); err != nil {
+		return err
 	}
 	for {
-		select {
-		case input := <-inputLines:
-			if input == "exit" {
-				return nil
-			} else {
-				fmt.Println("输入exit退出")
+		line, ok := <-inputLines
+		if !ok {
+			return nil
+		}
+		input := strings.TrimSpace(line)
+		page := c.lastHistory()
+		var q protocol.HistoryQuery
+		switch {
+		case input == "exit":
+			return nil
+		case input == "p":
+			if page.Before == "" {
+				fmt.Println("没有更早的消息")
+				continue
 			}
+			q.Before = page.Before
+		case input == "n":
+			if page.After == "" {
+				fmt.Println("没有更新的消息")
+				continue
+			}
+			q.After = page.After
+		case strings.HasPrefix(input, "t "):
+			t, err := time.ParseInLocation("2006-01-02 15:04", strings.TrimSpace(input[2:]), time.Local)
+			if err != nil {
+				fmt.Println("时间格式应为 2006-01-02 15:04")
+				continue
+			}
+			q.Time = t.Unix()
+		default:
+			fmt.Println(historyHelp)
+			continue
+		}
+		if err := c.requestHistory(peer, q); err != nil {
+			return err
 		}
-		return protocol.SendMsg(c.conn, msg)
 	}
+}
+
+// 请求一页历史消息
+func (c *Client) requestHistory(peer string, q protocol.HistoryQuery) error {
+	msg := &protocol.Message{Type: "room_messages", Content: q}
+	if peer != "" {
+		msg = &protocol.Message{Type: "private_messages", To: peer, Content: q}
+	}
+	return c.send(msg)
+}
+
+func (c *Client) setHistory(page protocol.HistoryPage) {
+	c.historyMu.Lock()
+	c.history = page
+	c.historyMu.Unlock()
+}
 
+func (c *Client) lastHistory() protocol.HistoryPage {
+	c.historyMu.Lock()
+	defer c.historyMu.Unlock()
+	return c.history
+}
+
+// 显示收到的一页历史消息，并记下翻页位置
+func (c *Client) showHistory(content interface{}) {
+	var page protocol.HistoryPage
+	if err := protocol.DecodeContent(content, &page); err != nil {
+		fmt.Println("[错误] 无法解析历史消息:", err)
+		return
+	}
+	if len(page.Messages) == 0 {
+		//没有消息时保留原来的翻页位置
+		fmt.Println("暂无历史消息")
+		return
+	}
+	c.setHistory(page)
+	if page.Peer != "" {
+		fmt.Printf("---- 与 %s 的聊天记录 ----\n", page.Peer)
+	} else {
+		fmt.Printf("---- 聊天室 %s 的聊天记录 ----\n", page.Room)
+	}
+	printChatMessages(page.Messages)
+	if page.HasMore {
+		fmt.Println("(还有更多消息)")
+	}
+}
+
+// 按时间顺序打印消息，时间按本地时区显示，消息ID可用于回复、修改和撤回
+func printChatMessages(msgs []protocol.ChatMessage) {
+	for _, m := range msgs {
+		if m.ReplyTo != nil {
+			fmt.Println(formatReply(m.ReplyTo))
+		}
+		t := time.Unix(m.Ts, 0).Format("2006-01-02 15:04:05")
+		switch {
+		case m.Deleted:
+			fmt.Printf("[%s] %s: (消息已撤回) <%s>\n", t, m.Sender, m.ID)
+		case m.Edited > 0:
+			fmt.Printf("[%s] %s: %s (已编辑) <%s>\n", t, m.Sender, m.Content, m.ID)
+		default:
+			fmt.Printf("[%s] %s: %s <%s>\n", t, m.Sender, m.Content, m.ID)
+		}
+		if len(m.Reactions) > 0 {
+			fmt.Println("  " + formatReactions(m.Reactions))
+		}
+	}
 }
@@ -3,13 +3,14 @@ package client
 import (
 	"fmt"
 	"net_chat/internal/protocol"
+	"strconv"
 	"strings"
 )
 
 // Register 注册:
 func (c *Client) Register(inputLines <-chan string) error {
 	for {
-		fmt.Print("请输入要注册的用户名和密码(格式为”用户名|密码)（输入exit退出注册界面）:")
+		fmt.Print("请输入要注册的用户名和密码(格式为”用户名|密码，需要邀请码时为用户名|密码|邀请码)（输入exit退出注册界面）:")
 		//1. 从用户输入通道中获取用户输入
 		userinfo, ok := <-inputLines
 		if !ok {
@@ -27,8 +28,8 @@ func (c *Client) Register(inputLines <-chan string) error {
 
 		//3.2分别验证用户名和密码
 		parts := strings.Split(userinfo, "|")
-		if len(parts) != 2 {
-			fmt.Println("格式错误，请使用‘用户名|密码’的格式")
+		if len(parts) != 2 && len(parts) != 3 {
+			fmt.Println("格式错误，请使用‘用户名|密码’或‘用户名|密码|邀请码’的格式")
 			continue
 		}
 		username := strings.TrimSpace(parts[0])
@@ -39,8 +40,13 @@ func (c *Client) Register(inputLines <-chan string) error {
 			continue
 		}
 
-		//4. 在数据库中检查
-		err := protocol.SendMsg(c.conn, &protocol.Message{
+		//4. 先完成服务端下发的工作量证明
+		if err := c.solveRegisterChallenge(); err != nil {
+			return err
+		}
+
+		//5. 在数据库中检查
+		err := c.send(&protocol.Message{
 			Type:    "register",
 			Content: userinfo,
 		})
@@ -65,3 +71,29 @@ func (c *Client) Register(inputLines <-chan string) error {
 		}
 	}
 }
+
+// solveRegisterChallenge 向服务端申请工作量证明挑战，自动解出后提交
+func (c *Client) solveRegisterChallenge() error {
+	if err := c.send(&protocol.Message{Type: "register_challenge"}); err != nil {
+		return fmt.Errorf("申请注册挑战失败%w", err)
+	}
+	msg, err := c.waitMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Type != "register_challenge" {
+		return fmt.Errorf("收到非注册挑战类型的消息:%s", msg.Type)
+	}
+	var ch protocol.PowChallenge
+	if err := protocol.DecodeContent(msg.Content, &ch); err != nil {
+		return fmt.Errorf("解析注册挑战失败%w", err)
+	}
+	if ch.Difficulty > 0 {
+		fmt.Printf("正在完成注册验证（难度%d）...\n", ch.Difficulty)
+	}
+	counter := protocol.SolvePow(&ch)
+	return c.send(&protocol.Message{
+		Type:    "register_pow",
+		Content: strconv.FormatUint(counter, 10),
+	})
+}
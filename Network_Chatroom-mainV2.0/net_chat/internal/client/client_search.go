@@ -0,0 +1,74 @@
+package client
+
+import (
+	"fmt"
+	"net_chat/internal/protocol"
+	"strings"
+	"time"
+)
+
+// Search 搜索聊天记录
+func (c *Client) Search(inputLines <-chan string) error {
+	fmt.Println("请输入搜索条件，格式为 关键词|发送者|开始日期|结束日期，不需要的条件留空，日期格式为2006-01-02")
+	fmt.Print("例如 周末|alice|| 或 |bob|2024-01-01|2024-01-31(输入exit取消)：")
+	line, ok := <-inputLines
+	if !ok {
+		return fmt.Errorf("无法得到用户输入")
+	}
+	line = strings.TrimSpace(line)
+	if line == "" || line == "exit" {
+		return nil
+	}
+	fields := strings.Split(line, "|")
+	for len(fields) < 4 {
+		fields = append(fields, "")
+	}
+	q := protocol.SearchQuery{
+		Keyword: strings.TrimSpace(fields[0]),
+		Sender:  strings.TrimSpace(fields[1]),
+	}
+	if q.Keyword == "" && q.Sender == "" {
+		return fmt.Errorf("关键词和发送者至少填一个")
+	}
+	if day := strings.TrimSpace(fields[2]); day != "" {
+		t, err := time.ParseInLocation("2006-01-02", day, time.Local)
+		if err != nil {
+			return fmt.Errorf("开始日期格式错误")
+		}
+		q.From = t.Unix()
+	}
+	if day := strings.TrimSpace(fields[3]); day != "" {
+		t, err := time.ParseInLocation("2006-01-02", day, time.Local)
+		if err != nil {
+			return fmt.Errorf("结束日期格式错误")
+		}
+		//包含结束日期当天
+		q.To = t.AddDate(0, 0, 1).Unix() - 1
+	}
+	return c.send(&protocol.Message{Type: "search", Content: q})
+}
+
+// 打印搜索结果
+func printSearchResult(content interface{}) {
+	var result protocol.SearchResult
+	if err := protocol.DecodeContent(content, &result); err != nil {
+		fmt.Println("[错误] 无法解析搜索结果:", err)
+		return
+	}
+	if len(result.Messages) == 0 {
+		fmt.Println("[系统] 没有找到相关消息")
+		return
+	}
+	fmt.Printf("\n---- 找到%d条消息 ----\n", len(result.Messages))
+	for _, m := range result.Messages {
+		t := time.Unix(m.Ts, 0).Format("2006-01-02 15:04:05")
+		where := "聊天室 " + m.Room
+		if m.Conversation != "" {
+			where = "私聊 " + strings.Replace(m.Conversation, ":", "与", 1)
+		}
+		fmt.Printf("[%s][%s] %s: %s\n", t, where, m.Sender, m.Content)
+	}
+	if result.HasMore {
+		fmt.Println("(结果较多，只显示最近的部分，可以缩小时间范围)")
+	}
+}
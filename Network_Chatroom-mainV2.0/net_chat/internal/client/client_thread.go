@@ -0,0 +1,104 @@
+package client
+
+import (
+	"fmt"
+	"net_chat/internal/protocol"
+	"strings"
+)
+
+const replyHelp = "输入 '/reply 内容' 回复最近收到的消息，'/reply 消息ID 内容' 回复指定消息，'/thread 消息ID' 查看某条消息的所有回复"
+
+// 记下在聊天室（peer为空）或与peer的私聊中最近收到的他人消息ID
+func (c *Client) rememberReceived(peer, id string) {
+	if id == "" {
+		return
+	}
+	c.sentMu.Lock()
+	if c.lastRecv == nil {
+		c.lastRecv = make(map[string]string)
+	}
+	c.lastRecv[peer] = id
+	c.sentMu.Unlock()
+}
+
+func (c *Client) lastReceivedID(peer string) string {
+	c.sentMu.Lock()
+	defer c.sentMu.Unlock()
+	return c.lastRecv[peer]
+}
+
+// 处理聊天中的/reply和/thread命令，不是这两个命令时返回false
+func (c *Client) handleReplyCommand(input, peer string) bool {
+	cmd, rest, _ := strings.Cut(input, " ")
+	rest = strings.TrimSpace(rest)
+	switch cmd {
+	case "/reply":
+		id := ""
+		if first, remain, _ := strings.Cut(rest, " "); messageIDPattern.MatchString(first) {
+			id, rest = first, strings.TrimSpace(remain)
+		}
+		if id == "" {
+			if id = c.lastReceivedID(peer); id == "" {
+				fmt.Println("[错误] 还没有收到消息，请指定消息ID")
+				return true
+			}
+		}
+		if rest == "" {
+			fmt.Println("[错误] 请输入回复的内容")
+			return true
+		}
+		err := c.send(&protocol.Message{
+			Type:    "chat",
+			Content: rest,
+			From:    c.username,
+			To:      peer,
+			ReplyTo: &protocol.ReplyContext{ID: id},
+		})
+		if err != nil {
+			fmt.Println("[错误] 发送消息失败:", err)
+		}
+		return true
+	case "/thread":
+		if !messageIDPattern.MatchString(rest) {
+			fmt.Println("[错误] 请指定消息ID，例如 /thread 1700000000000-0")
+			return true
+		}
+		if err := c.send(&protocol.Message{Type: "thread", Content: protocol.ThreadQuery{ID: rest, Peer: peer}}); err != nil {
+			fmt.Println("[错误] 发送请求失败:", err)
+		}
+		return true
+	}
+	return false
+}
+
+// 回复的引用，显示在消息上方
+func formatReply(r *protocol.ReplyContext) string {
+	if r.Deleted {
+		return "  ↪ 回复的消息已撤回"
+	}
+	return fmt.Sprintf("  ↪ 回复 %s: %s", r.Sender, r.Snippet)
+}
+
+// 打印一条消息及其回复
+func printThread(content interface{}) {
+	var page protocol.ThreadPage
+	if err := protocol.DecodeContent(content, &page); err != nil {
+		fmt.Println("[错误] 无法解析回复:", err)
+		return
+	}
+	fmt.Println("\n---- 原消息 ----")
+	printChatMessages([]protocol.ChatMessage{page.Parent})
+	if len(page.Replies) == 0 {
+		fmt.Println("(还没有回复)")
+		return
+	}
+	fmt.Printf("---- %d条回复 ----\n", len(page.Replies))
+	for _, m := range page.Replies {
+		//回复都指向原消息，不再重复显示引用
+		m.ReplyTo = nil
+		printChatMessages([]protocol.ChatMessage{m})
+	}
+	if page.HasMore {
+		fmt.Println("(回复较多，只显示了最早的部分)")
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// 等待服务端证书登录结果的时间
+const certLoginTimeout = 3 * time.Second
+
+// ConfigureTLS 使用TLS连接服务端
+// caFile为空时使用系统信任的CA；certFile和keyFile不为空时向服务端出示客户端证书，服务端登记过该证书即可免密登录
+func (c *Client) ConfigureTLS(caFile, certFile, keyFile, serverName string) error {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("读取CA证书失败:%w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("CA证书格式错误")
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("加载客户端证书失败:%w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	c.tlsConfig = cfg
+	return nil
+}
+
+// 建立连接，配置了TLS时使用TLS
+func (c *Client) dial(addr string) (net.Conn, error) {
+	if c.tlsConfig != nil {
+		return tls.Dial("tcp", addr, c.tlsConfig)
+	}
+	return net.Dial("tcp", addr)
+}
+
+// 是否配置了客户端证书
+func (c *Client) hasClientCert() bool {
+	return c.tlsConfig != nil && len(c.tlsConfig.Certificates) > 0
+}
+
+// CertLogin 出示了客户端证书时，等待服务端的证书登录结果
+// 返回true表示已经登录，不需要再输入用户名和密码
+func (c *Client) CertLogin() bool {
+	if !c.hasClientCert() {
+		return false
+	}
+	select {
+	case msg := <-c.msgChan:
+		if msg.Type == "login_success" {
+			c.certLogin = true
+			welcome := c.setToken(msg.Content)
+			c.username = c.loginUsername(msg.Content)
+			fmt.Println(welcome)
+			return true
+		}
+		fmt.Println(msg.Content)
+	case <-time.After(certLoginTimeout):
+	case <-c.quit:
+	}
+	return false
+}
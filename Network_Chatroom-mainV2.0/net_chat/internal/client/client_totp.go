@@ -0,0 +1,44 @@
+package client
+
+import (
+	"fmt"
+	"net_chat/internal/protocol"
+	"strings"
+)
+
+// EnableTOTP 开启两步验证：先向服务端申请密钥，再输入验证器中的验证码确认
+func (c *Client) EnableTOTP(inputLines <-chan string) error {
+	if err := c.send(&protocol.Message{Type: "totp_enroll"}); err != nil {
+		return err
+	}
+	fmt.Println("请将收到的密钥添加到验证器中，并妥善保存恢复码")
+	fmt.Print("请输入验证器中的6位验证码以完成开启(输入exit取消)：")
+	line, ok := <-inputLines
+	if !ok {
+		return fmt.Errorf("无法得到用户输入")
+	}
+	code := strings.TrimSpace(line)
+	if code == "" || code == "exit" {
+		return nil
+	}
+	return c.send(&protocol.Message{
+		Type:    "totp_confirm",
+		Content: code,
+	})
+}
+
+// 打印两步验证的密钥和恢复码
+func printTOTPEnrollment(content interface{}) {
+	var enroll protocol.TOTPEnrollment
+	if err := protocol.DecodeContent(content, &enroll); err != nil {
+		fmt.Println("[错误] 解析两步验证信息失败:", err)
+		return
+	}
+	fmt.Println("\n======= 两步验证 =======")
+	fmt.Println("验证器地址:", enroll.URI)
+	fmt.Println("密钥:", enroll.Secret)
+	fmt.Println("恢复码(每个只能使用一次):")
+	for _, code := range enroll.RecoveryCodes {
+		fmt.Println("  ", code)
+	}
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"fmt"
+	"net_chat/internal/protocol"
+)
+
+// 默认聊天室，与服务端一致
+const mainRoom = "main_room"
+
+// RequestRoomUnread 查询各聊天室的未读数
+func (c *Client) RequestRoomUnread() error {
+	return c.send(&protocol.Message{Type: "room_unread"})
+}
+
+// 将聊天室中目前的消息全部标记为已读
+func (c *Client) markRoomRead(room string) {
+	//访客不记录已读位置
+	if c.guest {
+		return
+	}
+	if err := c.send(&protocol.Message{Type: "mark_read", Content: room}); err != nil {
+		fmt.Println("[错误] 标记已读失败:", err)
+	}
+}
+
+// 打印聊天室未读汇总
+func printRoomUnread(content interface{}) {
+	var summary []protocol.RoomUnread
+	if err := protocol.DecodeContent(content, &summary); err != nil {
+		fmt.Println("[错误] 无法解析未读数:", err)
+		return
+	}
+	if len(summary) == 0 {
+		fmt.Println("[系统] 暂无聊天室")
+		return
+	}
+	fmt.Println("\n---- 聊天室未读消息 ----")
+	for _, r := range summary {
+		count := fmt.Sprintf("%d", r.Unread)
+		if r.Unread >= 999 {
+			count = "999+"
+		}
+		fmt.Printf("%s: %s条未读\n", r.Room, count)
+	}
+}
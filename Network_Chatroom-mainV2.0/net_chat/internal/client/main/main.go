@@ -17,6 +17,19 @@ func main() {
 		addr = "localhost:8080" // 本地开发时的默认值；容器中会被覆盖为 server:8080
 	}
 
+	//TLS配置：CHAT_TLS=true开启，CHAT_TLS_CERT/CHAT_TLS_KEY为客户端证书（机器人免密登录）
+	if os.Getenv("CHAT_TLS") == "true" || os.Getenv("CHAT_TLS_CERT") != "" {
+		err := client.ConfigureTLS(os.Getenv("CHAT_TLS_CA"), os.Getenv("CHAT_TLS_CERT"),
+			os.Getenv("CHAT_TLS_KEY"), os.Getenv("CHAT_TLS_SERVER_NAME"))
+		if err != nil {
+			fmt.Printf("加载TLS配置失败%v\n", err)
+			return
+		}
+	}
+
+	//老账号还没有挑战-应答校验值时，设置CHAT_LEGACY_LOGIN=true用密码登录一次完成升级
+	client.SetLegacyLogin(os.Getenv("CHAT_LEGACY_LOGIN") == "true")
+
 	if err := client.Connect(addr); err != nil {
 		fmt.Printf("连接服务器失败%v\n", err)
 		return
@@ -26,8 +39,12 @@ func main() {
 	client.Start(true)
 
 	// 登录流程（带重试），传入 inputLines
-	//登录-注册菜单
-	client.LoginRegisterMenu(client.InputLines)
+	//出示了已登记的客户端证书时直接登录，否则进入登录-注册菜单
+	if client.CertLogin() {
+		client.Start(false)
+	} else {
+		client.LoginRegisterMenu(client.InputLines)
+	}
 
 	//主菜单循环（从 inputLines 阻塞读取）
 	client.MainMenu(client.InputLines)
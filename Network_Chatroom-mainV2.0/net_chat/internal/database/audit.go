@@ -0,0 +1,15 @@
+package database
+
+import (
+	"fmt"
+)
+
+// AddAudit 记录一条审计日志
+// actor为操作人，target为被操作的用户，detail中不要写入密码等敏感信息
+func AddAudit(actor, action, target, detail string) error {
+	query := "INSERT INTO audit_log(actor, action, target, detail) VALUES(?,?,?,?)"
+	if _, err := DB.Exec(query, actor, action, target, detail); err != nil {
+		return fmt.Errorf("写入审计日志失败:%w", err)
+	}
+	return nil
+}
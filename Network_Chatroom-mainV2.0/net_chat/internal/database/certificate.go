@@ -0,0 +1,43 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GetUserByCertificate 根据客户端证书中的标识（SAN、CN）查找登记的用户
+// 按传入的顺序，第一个登记过的标识生效
+func GetUserByCertificate(subjects []string) (string, error) {
+	if len(subjects) == 0 {
+		return "", fmt.Errorf("证书中没有可用的标识")
+	}
+	args := make([]interface{}, len(subjects))
+	for i, subject := range subjects {
+		args[i] = subject
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(subjects)), ",")
+	query := fmt.Sprintf("SELECT subject, username FROM user_certificates WHERE subject IN (%s)", placeholders)
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return "", fmt.Errorf("查询证书对应的用户失败:%w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[string]string)
+	for rows.Next() {
+		var subject, username string
+		if err := rows.Scan(&subject, &username); err != nil {
+			return "", fmt.Errorf("查询证书对应的用户失败:%w", err)
+		}
+		found[subject] = username
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("查询证书对应的用户失败:%w", err)
+	}
+	for _, subject := range subjects {
+		if username, ok := found[subject]; ok {
+			return username, nil
+		}
+	}
+	return "", fmt.Errorf("证书标识%v没有登记", subjects)
+}
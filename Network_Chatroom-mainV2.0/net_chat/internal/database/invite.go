@@ -0,0 +1,82 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInviteInvalid 邀请码不存在、已过期或已用完
+var ErrInviteInvalid = errors.New("邀请码无效、已过期或已用完")
+
+// ErrNoInviteQuota 剩余的邀请额度不够
+var ErrNoInviteQuota = errors.New("剩余的邀请额度不足")
+
+// CreateInvite 生成邀请码，ttl为0表示永不过期
+// useQuota为true时（普通用户）按可用次数扣减邀请额度，额度不足时返回ErrNoInviteQuota
+func CreateInvite(creator string, maxUses int, ttl time.Duration, useQuota bool) (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成邀请码失败:%w", err)
+	}
+	code := base32.StdEncoding.EncodeToString(buf)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", fmt.Errorf("开启事务失败:%w", err)
+	}
+	defer tx.Rollback()
+
+	if useQuota {
+		result, err := tx.Exec("UPDATE users SET invite_quota = invite_quota - ? WHERE username = ? AND invite_quota >= ?", maxUses, creator, maxUses)
+		if err != nil {
+			return "", fmt.Errorf("扣减邀请额度失败:%w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return "", ErrNoInviteQuota
+		}
+	}
+
+	var expiresAt sql.NullTime
+	if ttl > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().Add(ttl), Valid: true}
+	}
+	query := "INSERT INTO invite_codes(code, created_by, max_uses, expires_at) VALUES(?,?,?,?)"
+	if _, err := tx.Exec(query, code, creator, maxUses, expiresAt); err != nil {
+		return "", fmt.Errorf("保存邀请码失败:%w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("保存邀请码失败:%w", err)
+	}
+	return code, nil
+}
+
+// 在注册事务中消耗一次邀请码
+// 条件更新保证并发注册时不会超出可用次数
+func consumeInvite(tx *sql.Tx, code string) error {
+	query := "UPDATE invite_codes SET uses = uses + 1 WHERE code = ? AND uses < max_uses AND (expires_at IS NULL OR expires_at > NOW())"
+	result, err := tx.Exec(query, code)
+	if err != nil {
+		return fmt.Errorf("校验邀请码失败:%w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInviteInvalid
+	}
+	return nil
+}
+
+// SetInviteQuota 设置用户的邀请额度
+func SetInviteQuota(username string, quota int) error {
+	//额度没有变化时受影响行数也是0，所以先确认用户存在
+	var id int
+	if err := DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&id); err != nil {
+		return fmt.Errorf("用户'%s'不存在或查询失败:%w", username, err)
+	}
+	if _, err := DB.Exec("UPDATE users SET invite_quota = ? WHERE id = ?", quota, id); err != nil {
+		return fmt.Errorf("设置邀请额度失败:%w", err)
+	}
+	return nil
+}
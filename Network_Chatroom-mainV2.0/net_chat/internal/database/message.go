@@ -0,0 +1,221 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//从Redis归档的聊天消息
+//chat_messages按消息ID中的毫秒时间做RANGE分区，每月一个分区，p_future存放还没有建立分区的消息，
+//定期为之后的月份建立分区；按月删除旧消息时可以直接DROP PARTITION
+//scope为 room:<聊天室> 或 pm:<用户A>:<用户B>，与Redis中stream的名字对应
+
+const createMessagesTable = `CREATE TABLE IF NOT EXISTS chat_messages (
+	scope VARCHAR(191) NOT NULL,
+	id_ms BIGINT NOT NULL,
+	id_seq BIGINT NOT NULL,
+	sender VARCHAR(64) NOT NULL,
+	content TEXT NOT NULL,
+	ts BIGINT NOT NULL,
+	reply_to VARCHAR(32) NOT NULL DEFAULT '',
+	PRIMARY KEY (scope, id_ms, id_seq),
+	INDEX idx_sender (sender, id_ms)
+) DEFAULT CHARSET=utf8mb4
+PARTITION BY RANGE (id_ms) (PARTITION p_future VALUES LESS THAN MAXVALUE)`
+
+// 每次批量写入的条数
+const archiveInsertBatch = 200
+
+// ArchivedMessage 一条归档的消息
+type ArchivedMessage struct {
+	Scope   string
+	ID      string //Redis中的stream ID
+	Sender  string
+	Content string
+	Ts      int64  //发送时间（unix秒）
+	ReplyTo string //回复的消息ID，不是回复时为空
+}
+
+// ArchiveRange 归档消息的查询范围，Before和After都不包含本身
+type ArchiveRange struct {
+	Before string //只返回该ID之前的消息
+	After  string //只返回该ID之后的消息
+	Since  int64  //只返回该时间（unix毫秒）及之后的消息
+	Limit  int64
+	Latest bool //为true时返回范围内最新的Limit条，否则返回最早的Limit条
+}
+
+// InitMessageArchive 创建归档表，并为当前和之后两个月建立分区
+func InitMessageArchive(now time.Time) error {
+	if _, err := DB.Exec(createMessagesTable); err != nil {
+		return fmt.Errorf("创建消息归档表失败:%w", err)
+	}
+	//较早建立的归档表没有回复字段
+	if err := ensureColumn("chat_messages", "reply_to", "VARCHAR(32) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	return EnsureMessagePartitions(now, 2)
+}
+
+// EnsureMessagePartitions 确保从now所在月份开始的months+1个月都有分区
+func EnsureMessagePartitions(now time.Time, months int) error {
+	rows, err := DB.Query(`SELECT PARTITION_NAME, PARTITION_DESCRIPTION FROM information_schema.PARTITIONS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'chat_messages' AND PARTITION_NAME IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("查询消息表分区失败:%w", err)
+	}
+	//已有分区的最大上界，只能在它之后继续建立分区
+	var maxBound int64
+	for rows.Next() {
+		var name, desc string
+		if err := rows.Scan(&name, &desc); err != nil {
+			rows.Close()
+			return err
+		}
+		if bound, err := strconv.ParseInt(desc, 10, 64); err == nil && bound > maxBound {
+			maxBound = bound
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= months; i++ {
+		month := first.AddDate(0, i, 0)
+		bound := month.AddDate(0, 1, 0).UnixMilli()
+		if bound <= maxBound {
+			continue
+		}
+		stmt := fmt.Sprintf(`ALTER TABLE chat_messages REORGANIZE PARTITION p_future INTO (
+			PARTITION p%s VALUES LESS THAN (%d),
+			PARTITION p_future VALUES LESS THAN MAXVALUE)`, month.Format("200601"), bound)
+		if _, err := DB.Exec(stmt); err != nil {
+			return fmt.Errorf("建立%s的消息分区失败:%w", month.Format("2006-01"), err)
+		}
+		maxBound = bound
+	}
+	return nil
+}
+
+// 把stream ID拆成毫秒时间和序号
+func splitStreamID(id string) (ms, seq int64, err error) {
+	msPart, seqPart, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("无效的消息ID:%s", id)
+	}
+	if ms, err = strconv.ParseInt(msPart, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("无效的消息ID:%s", id)
+	}
+	if seq, err = strconv.ParseInt(seqPart, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("无效的消息ID:%s", id)
+	}
+	return ms, seq, nil
+}
+
+// ArchiveMessages 写入归档，已经存在的消息跳过，重复归档不会出错
+func ArchiveMessages(msgs []ArchivedMessage) error {
+	for start := 0; start < len(msgs); start += archiveInsertBatch {
+		end := min(start+archiveInsertBatch, len(msgs))
+		batch := msgs[start:end]
+		args := make([]interface{}, 0, len(batch)*7)
+		for _, m := range batch {
+			ms, seq, err := splitStreamID(m.ID)
+			if err != nil {
+				return err
+			}
+			args = append(args, m.Scope, ms, seq, m.Sender, m.Content, m.Ts, m.ReplyTo)
+		}
+		query := "INSERT IGNORE INTO chat_messages(scope, id_ms, id_seq, sender, content, ts, reply_to) VALUES " +
+			strings.TrimSuffix(strings.Repeat("(?,?,?,?,?,?,?),", len(batch)), ",")
+		if _, err := DB.Exec(query, args...); err != nil {
+			return fmt.Errorf("写入消息归档失败:%w", err)
+		}
+	}
+	return nil
+}
+
+// GetArchivedMessages 按范围查询归档消息，按时间顺序返回，hasMore表示范围内还有更多
+func GetArchivedMessages(scope string, r ArchiveRange) ([]ArchivedMessage, bool, error) {
+	where := []string{"scope = ?"}
+	args := []interface{}{scope}
+	if r.Before != "" {
+		ms, seq, err := splitStreamID(r.Before)
+		if err != nil {
+			return nil, false, err
+		}
+		where = append(where, "(id_ms < ? OR (id_ms = ? AND id_seq < ?))")
+		args = append(args, ms, ms, seq)
+	}
+	if r.After != "" {
+		ms, seq, err := splitStreamID(r.After)
+		if err != nil {
+			return nil, false, err
+		}
+		where = append(where, "(id_ms > ? OR (id_ms = ? AND id_seq > ?))")
+		args = append(args, ms, ms, seq)
+	}
+	if r.Since > 0 {
+		where = append(where, "id_ms >= ?")
+		args = append(args, r.Since)
+	}
+	order := "ASC"
+	if r.Latest {
+		order = "DESC"
+	}
+	query := fmt.Sprintf("SELECT id_ms, id_seq, sender, content, ts, reply_to FROM chat_messages WHERE %s ORDER BY id_ms %s, id_seq %s LIMIT ?",
+		strings.Join(where, " AND "), order, order)
+	//多取一条用来判断是否还有更多
+	args = append(args, r.Limit+1)
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("查询消息归档失败:%w", err)
+	}
+	defer rows.Close()
+	var msgs []ArchivedMessage
+	for rows.Next() {
+		var ms, seq int64
+		m := ArchivedMessage{Scope: scope}
+		if err := rows.Scan(&ms, &seq, &m.Sender, &m.Content, &m.Ts, &m.ReplyTo); err != nil {
+			return nil, false, err
+		}
+		m.ID = fmt.Sprintf("%d-%d", ms, seq)
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	hasMore := int64(len(msgs)) > r.Limit
+	if hasMore {
+		msgs = msgs[:r.Limit]
+	}
+	if r.Latest {
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	}
+	return msgs, hasMore, nil
+}
+
+// GetArchivedMessage 按ID读取一条归档消息，不存在时返回nil
+func GetArchivedMessage(scope, id string) (*ArchivedMessage, error) {
+	ms, seq, err := splitStreamID(id)
+	if err != nil {
+		return nil, err
+	}
+	m := ArchivedMessage{Scope: scope, ID: id}
+	err = DB.QueryRow("SELECT sender, content, ts, reply_to FROM chat_messages WHERE scope = ? AND id_ms = ? AND id_seq = ?",
+		scope, ms, seq).Scan(&m.Sender, &m.Content, &m.Ts, &m.ReplyTo)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询消息归档失败:%w", err)
+	}
+	return &m, nil
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//密码哈希采用带版本的格式，可以同时识别多种算法：
+//  Argon2id: $argon2id$v=19$m=65536,t=3,p=2$<盐>$<哈希>（与libsodium、PHC格式一致）
+//  bcrypt:   $2a$10$...（老用户）
+//新密码一律使用Argon2id，登录时发现旧算法或参数偏弱会自动重新哈希
+
+// Argon2Params Argon2id的参数
+type Argon2Params struct {
+	Memory  uint32 //内存，单位KiB
+	Time    uint32 //迭代次数
+	Threads uint8  //并行度
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// PasswordParams 当前使用的参数，可以通过环境变量 ARGON2_MEMORY(KiB)、ARGON2_TIME、ARGON2_THREADS 调整
+var PasswordParams = Argon2Params{
+	Memory:  64 * 1024,
+	Time:    3,
+	Threads: 2,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+// ErrPasswordMismatch 密码不匹配
+var ErrPasswordMismatch = errors.New("密码不匹配")
+
+// InitPasswordHashing 从环境变量读取Argon2id参数
+func InitPasswordHashing() error {
+	readUint := func(name string, bits int, set func(uint64)) error {
+		v := os.Getenv(name)
+		if v == "" {
+			return nil
+		}
+		n, err := strconv.ParseUint(v, 10, bits)
+		if err != nil || n == 0 {
+			return fmt.Errorf("环境变量%s的值无效:%s", name, v)
+		}
+		set(n)
+		return nil
+	}
+	if err := readUint("ARGON2_MEMORY", 32, func(n uint64) { PasswordParams.Memory = uint32(n) }); err != nil {
+		return err
+	}
+	if err := readUint("ARGON2_TIME", 32, func(n uint64) { PasswordParams.Time = uint32(n) }); err != nil {
+		return err
+	}
+	return readUint("ARGON2_THREADS", 8, func(n uint64) { PasswordParams.Threads = uint8(n) })
+}
+
+// HashPassword 使用当前参数对密码做Argon2id哈希
+func HashPassword(password string) (string, error) {
+	p := PasswordParams
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("生成盐值失败:%w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// VerifyPassword 校验密码，needsRehash表示哈希使用的是旧算法或比当前更弱的参数，应当用明文密码重新哈希
+func VerifyPassword(hash, password string) (needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return verifyArgon2id(hash, password)
+	case strings.HasPrefix(hash, "$2"):
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			return false, ErrPasswordMismatch
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("无法识别的密码哈希格式")
+	}
+}
+
+func verifyArgon2id(hash, password string) (bool, error) {
+	//$argon2id$v=19$m=65536,t=3,p=2$salt$key 按$分割后第一段为空
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, fmt.Errorf("无效的argon2id哈希")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, fmt.Errorf("不支持的argon2版本:%s", parts[2])
+	}
+	var p Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return false, fmt.Errorf("无效的argon2id参数:%w", err)
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("无效的argon2id盐值:%w", err)
+	}
+	key, err := enc.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("无效的argon2id哈希值:%w", err)
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return false, ErrPasswordMismatch
+	}
+	cur := PasswordParams
+	weaker := p.Memory < cur.Memory || p.Time < cur.Time || p.Threads < cur.Threads ||
+		uint32(len(salt)) < cur.SaltLen || uint32(len(key)) < cur.KeyLen
+	return weaker, nil
+}
@@ -0,0 +1,210 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"time"
+)
+
+//把消息归档到MySQL
+//每个聊天室和私聊的stream上建立archiver消费组，归档程序读取新消息、写入MySQL成功后再XACK，
+//程序中途退出时未确认的消息会在下次启动时重新处理，其他实例挂掉留下的消息超过一定时间后被认领
+//开启归档后，保留策略只会删除已经确认归档的消息
+
+const archiveGroup = "archiver"
+
+// 每次读取的条数，以及认领其他消费者未确认消息前等待的时间
+const (
+	archiveBatch   = 200
+	archiveMinIdle = 5 * time.Minute
+)
+
+// 开启后保留策略不会删除还没有归档的消息
+var archiveWatermark bool
+
+// EnableArchiveWatermark 开启MySQL归档时调用
+func EnableArchiveWatermark() {
+	archiveWatermark = true
+}
+
+// RoomScope 聊天室在归档和搜索中的标识
+func RoomScope(room string) string {
+	return "room:" + room
+}
+
+// PrivateScope 私聊会话在归档和搜索中的标识
+func PrivateScope(userA, userB string) string {
+	return ConversationScope(ConversationID(userA, userB))
+}
+
+// ConversationScope 由ConversationID得到私聊会话的标识
+func ConversationScope(conversation string) string {
+	return "pm:" + conversation
+}
+
+// OldestID 返回stream中还保存着的最早一条消息ID，没有消息时返回空
+func OldestID(scope string) (string, error) {
+	msgs, err := Rdb.XRangeN(Rctx, "stream:"+scope, "-", "+", 1).Result()
+	if err != nil || len(msgs) == 0 {
+		return "", err
+	}
+	return msgs[0].ID, nil
+}
+
+// 在stream上建立消费组，从第一条消息开始消费
+func ensureArchiveGroup(key string) error {
+	err := Rdb.XGroupCreate(Rctx, key, archiveGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// ConsumeArchive 把所有stream中还没归档的消息交给handle，handle成功后确认，返回处理的条数
+// scope为RoomScope或PrivateScope的格式
+func ConsumeArchive(consumer string, handle func(scope string, msgs []redis.XMessage) error) (int, error) {
+	total := 0
+	for _, pattern := range []string{roomStreamPrefix + "*", "stream:pm:*"} {
+		iter := Rdb.ScanType(Rctx, 0, pattern, 100, "stream").Iterator()
+		for iter.Next(Rctx) {
+			n, err := consumeStream(iter.Val(), consumer, handle)
+			total += n
+			if err != nil {
+				return total, fmt.Errorf("归档%s失败:%w", iter.Val(), err)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func consumeStream(key, consumer string, handle func(scope string, msgs []redis.XMessage) error) (int, error) {
+	if err := ensureArchiveGroup(key); err != nil {
+		return 0, err
+	}
+	scope := strings.TrimPrefix(key, "stream:")
+	process := func(msgs []redis.XMessage) error {
+		ids := make([]string, 0, len(msgs))
+		valid := make([]redis.XMessage, 0, len(msgs))
+		for _, msg := range msgs {
+			ids = append(ids, msg.ID)
+			//读取前已被删除的消息只有ID，直接确认
+			if len(msg.Values) > 0 {
+				valid = append(valid, msg)
+			}
+		}
+		if len(valid) > 0 {
+			if err := handle(scope, valid); err != nil {
+				return err
+			}
+		}
+		return Rdb.XAck(Rctx, key, archiveGroup, ids...).Err()
+	}
+
+	//认领其他消费者长时间未确认的消息
+	//（go-redis v8解析不了Redis 7的XAUTOCLAIM返回值，这里用XPENDING+XCLAIM）
+	pending, err := Rdb.XPendingExt(Rctx, &redis.XPendingExtArgs{
+		Stream: key,
+		Group:  archiveGroup,
+		Start:  "-",
+		End:    "+",
+		Count:  archiveBatch,
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+	var stale []string
+	for _, p := range pending {
+		if p.Consumer != consumer && p.Idle >= archiveMinIdle {
+			stale = append(stale, p.ID)
+		}
+	}
+	total := 0
+	if len(stale) > 0 {
+		claimed, err := Rdb.XClaim(Rctx, &redis.XClaimArgs{
+			Stream:   key,
+			Group:    archiveGroup,
+			Consumer: consumer,
+			MinIdle:  archiveMinIdle,
+			Messages: stale,
+		}).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return 0, err
+		}
+		if len(claimed) > 0 {
+			if err := process(claimed); err != nil {
+				return 0, err
+			}
+			total += len(claimed)
+		}
+	}
+
+	//先处理自己以前读取但没有确认的（ID为0），再读取新消息（ID为>）
+	for _, start := range []string{"0", ">"} {
+		for {
+			streams, err := Rdb.XReadGroup(Rctx, &redis.XReadGroupArgs{
+				Group:    archiveGroup,
+				Consumer: consumer,
+				Streams:  []string{key, start},
+				Count:    archiveBatch,
+				Block:    -1,
+			}).Result()
+			if errors.Is(err, redis.Nil) {
+				break
+			}
+			if err != nil {
+				return total, err
+			}
+			if len(streams) == 0 || len(streams[0].Messages) == 0 {
+				break
+			}
+			msgs := streams[0].Messages
+			if err := process(msgs); err != nil {
+				return total, err
+			}
+			total += len(msgs)
+			if len(msgs) < archiveBatch {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// 返回stream中还没有确认归档的第一条消息ID，小于它的消息都已归档；还没有消费组时返回"0-0"
+func archivedBefore(key string) (string, error) {
+	//Redis 7的XINFO GROUPS多了几个字段，go-redis v8解析不了，这里自己解析键值对
+	groups, err := Rdb.Do(Rctx, "XINFO", "GROUPS", key).Slice()
+	if err != nil {
+		return "", err
+	}
+	for _, g := range groups {
+		fields, ok := g.([]interface{})
+		if !ok {
+			continue
+		}
+		info := make(map[string]interface{}, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			if name, ok := fields[i].(string); ok {
+				info[name] = fields[i+1]
+			}
+		}
+		if info["name"] != archiveGroup {
+			continue
+		}
+		if pending, _ := info["pending"].(int64); pending > 0 {
+			summary, err := Rdb.XPending(Rctx, key, archiveGroup).Result()
+			if err != nil {
+				return "", err
+			}
+			return summary.Lower, nil
+		}
+		lastDelivered, _ := info["last-delivered-id"].(string)
+		return nextID(lastDelivered), nil
+	}
+	return "0-0", nil
+}
@@ -0,0 +1,160 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+)
+
+//离线私聊投递
+//投递位置与送达回执共用 pmack:<user>（见receipt.go），发送者 -> 该用户的客户端确认收到的最后一条消息的stream ID
+//pmpending:<user> 集合，可能还有消息没有确认收到的发送者
+//位置只在客户端回复pm_delivered/pm_read后前移，登录时重发这个位置之后的消息，连接在发送前断开也不会遗漏
+
+// 每次从stream中读取的条数
+const deliveryPageSize = 100
+
+// 只在新ID比原来的大时才更新，避免并发投递时位置倒退
+var setMaxIDScript = redis.NewScript(`
+local cur = redis.call('HGET', KEYS[1], ARGV[1])
+if cur then
+	local cm, cs = string.match(cur, '(%d+)-(%d+)')
+	local nm, ns = string.match(ARGV[2], '(%d+)-(%d+)')
+	cm, cs, nm, ns = tonumber(cm), tonumber(cs), tonumber(nm), tonumber(ns)
+	if nm < cm or (nm == cm and ns <= cs) then
+		return 0
+	end
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// 把哈希表中某个字段保存的stream ID前移到id，返回是否前移了
+func setMaxID(key, field, id string) (bool, error) {
+	n, err := setMaxIDScript.Run(Rctx, Rdb, []string{key}, field, id).Int()
+	return n == 1, err
+}
+
+func pendingKey(user string) string {
+	return fmt.Sprintf("pmpending:%s", user)
+}
+
+// 新私聊消息保存后调用：记下发送者有待确认的消息
+// 第一次收到该发送者的消息、又没有老版本的未读计数时，把投递位置设在这条消息之前
+var pendingScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 and redis.call('HEXISTS', KEYS[2], ARGV[1]) == 0 then
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+end
+redis.call('SADD', KEYS[3], ARGV[1])
+return 1
+`)
+
+func markPending(user, sender, streamKey, id string) error {
+	prev, err := previousID(streamKey, id)
+	if err != nil {
+		return err
+	}
+	keys := []string{ackKey(user), fmt.Sprintf("unread:%s", user), pendingKey(user)}
+	return pendingScript.Run(Rctx, Rdb, keys, sender, prev).Err()
+}
+
+// stream中id之前的一条消息ID，没有时返回"0-0"
+func previousID(streamKey, id string) (string, error) {
+	msgs, err := Rdb.XRevRangeN(Rctx, streamKey, "("+id, "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(msgs) == 0 {
+		return "0-0", nil
+	}
+	return msgs[0].ID, nil
+}
+
+// GetPendingSenders 返回可能有未确认私聊的发送者
+func GetPendingSenders(user string) ([]string, error) {
+	return Rdb.SMembers(Rctx, pendingKey(user)).Result()
+}
+
+// ClearPendingSender 发送者的消息都已确认后调用，删除后又有新消息时重新加回
+func ClearPendingSender(user, sender string) error {
+	if err := Rdb.SRem(Rctx, pendingKey(user), sender).Err(); err != nil {
+		return err
+	}
+	pending, err := GetPendingPrivateMessages(user, sender, 0)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	return Rdb.SAdd(Rctx, pendingKey(user), sender).Err()
+}
+
+// GetPendingPrivateMessages 按时间顺序返回sender发给user、还没有投递的私聊消息
+// legacy为未读计数，只在还没有投递位置的老数据上使用：此时取sender最近的legacy条消息
+func GetPendingPrivateMessages(user, sender string, legacy int64) ([]redis.XMessage, error) {
+	key := privateStreamKey(user, sender)
+	last, err := Rdb.HGet(Rctx, ackKey(user), sender).Result()
+	if errors.Is(err, redis.Nil) {
+		found, err := lastMessagesFrom(key, sender, legacy)
+		if err != nil || len(found) == 0 {
+			return found, err
+		}
+		//老数据第一次投递时补上投递位置，之后未读计数清零也能按位置重发
+		prev, err := previousID(key, found[0].ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := Rdb.HSetNX(Rctx, ackKey(user), sender, prev).Err(); err != nil {
+			return nil, err
+		}
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []redis.XMessage
+	start := "(" + last
+	for {
+		msgs, err := Rdb.XRangeN(Rctx, key, start, "+", deliveryPageSize).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			if msg.Values["sender"] == sender {
+				pending = append(pending, msg)
+			}
+		}
+		if len(msgs) < deliveryPageSize {
+			return pending, nil
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+	}
+}
+
+// 从后往前找到sender最近的n条消息，按时间顺序返回
+func lastMessagesFrom(key, sender string, n int64) ([]redis.XMessage, error) {
+	var found []redis.XMessage
+	end := "+"
+	for int64(len(found)) < n {
+		msgs, err := Rdb.XRevRangeN(Rctx, key, end, "-", deliveryPageSize).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			if msg.Values["sender"] == sender {
+				found = append(found, msg)
+				if int64(len(found)) == n {
+					break
+				}
+			}
+		}
+		if len(msgs) < deliveryPageSize {
+			break
+		}
+		end = "(" + msgs[len(msgs)-1].ID
+	}
+	//反转成时间顺序
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+	return found, nil
+}
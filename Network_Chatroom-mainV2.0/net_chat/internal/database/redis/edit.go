@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//stream中的消息不可修改，修改和撤回记录在msgedit:<scope>哈希中，field为消息ID，值为MessageEdit的JSON
+//读取历史、搜索和导出时用它覆盖原消息
+//edited:<scope>  有序集合，有修改记录的消息ID，分值为消息时间（毫秒），清理过期消息时使用
+
+// ErrMessageDeleted 消息已撤回，不能再修改或撤回
+var ErrMessageDeleted = errors.New("该消息已撤回")
+
+// MessageEdit 一条消息最后一次修改或撤回的记录
+type MessageEdit struct {
+	Content  string `json:"content,omitempty"` //修改后的内容，撤回时为空
+	Deleted  bool   `json:"deleted,omitempty"` //已撤回，之后不能再修改
+	EditedBy string `json:"edited_by"`         //操作者，管理员处理他人消息时与发送者不同
+	EditedAt int64  `json:"edited_at"`         //操作时间（unix秒）
+}
+
+func editKey(scope string) string {
+	return "msgedit:" + scope
+}
+
+func editedKey(scope string) string {
+	return "edited:" + scope
+}
+
+// GetMessageEdit 读取一条消息的修改记录，没有修改过时返回nil
+func GetMessageEdit(scope, id string) (*MessageEdit, error) {
+	data, err := Rdb.HGet(Rctx, editKey(scope), id).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e MessageEdit
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// 已撤回的消息返回0，否则保存修改记录并返回1，检查和写入在同一个脚本中完成
+var saveEditScript = redis.NewScript(`
+local prev = redis.call('HGET', KEYS[1], ARGV[1])
+if prev and cjson.decode(prev).deleted then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+return 1
+`)
+
+// SaveMessageEdit 记录修改或撤回，修改后的内容同时加入搜索索引，消息已撤回时返回ErrMessageDeleted
+func SaveMessageEdit(scope, id, sender string, e MessageEdit) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	score := strconv.FormatFloat(idMillis(id), 'f', 0, 64)
+	ok, err := saveEditScript.Run(Rctx, Rdb, []string{editKey(scope), editedKey(scope)}, id, data, score).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrMessageDeleted
+	}
+	if e.Deleted {
+		return nil
+	}
+	return addToIndex("stream:"+scope, id, sender, e.Content)
+}
+
+// GetMessageEdits 批量读取修改记录，只返回有记录的消息
+func GetMessageEdits(scope string, ids []string) (map[string]MessageEdit, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	vals, err := Rdb.HMGet(Rctx, editKey(scope), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	edits := make(map[string]MessageEdit)
+	for i, v := range vals {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var e MessageEdit
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, fmt.Errorf("解析消息%s的修改记录失败:%w", ids[i], err)
+		}
+		edits[ids[i]] = e
+	}
+	return edits, nil
+}
+
+// 删除已经被裁剪掉的消息的修改记录
+func pruneEdits(scope, cut string) error {
+	ms, _ := parseID(cut)
+	ids, err := Rdb.ZRangeByScore(Rctx, editedKey(scope), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatUint(ms, 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, id := range ids {
+		if CompareID(id, cut) < 0 {
+			stale = append(stale, id)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(stale))
+	for i, id := range stale {
+		members[i] = id
+	}
+	pipe := Rdb.TxPipeline()
+	pipe.HDel(Rctx, editKey(scope), stale...)
+	pipe.ZRem(Rctx, editedKey(scope), members...)
+	_, err = pipe.Exec(Rctx)
+	return err
+}
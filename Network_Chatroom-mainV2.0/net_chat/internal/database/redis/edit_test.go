@@ -0,0 +1,57 @@
+package redis
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSaveEditAfterDelete(t *testing.T) {
+	newTestRedis(t)
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Unix()
+	id, _ := AddRoomMessage("main", "alice", "hi", "", ts)
+	scope := RoomScope("main")
+
+	if err := SaveMessageEdit(scope, id, "alice", MessageEdit{Content: "hello", EditedBy: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveMessageEdit(scope, id, "alice", MessageEdit{Deleted: true, EditedBy: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []MessageEdit{{Content: "again", EditedBy: "alice"}, {Deleted: true, EditedBy: "mod"}} {
+		if err := SaveMessageEdit(scope, id, "alice", e); !errors.Is(err, ErrMessageDeleted) {
+			t.Fatalf("save after delete = %v", err)
+		}
+	}
+	e, err := GetMessageEdit(scope, id)
+	if err != nil || e == nil || !e.Deleted || e.EditedBy != "alice" {
+		t.Fatalf("edit = %+v, %v", e, err)
+	}
+}
+
+func TestPruneEdits(t *testing.T) {
+	newTestRedis(t)
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Unix()
+	scope := RoomScope("main")
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, _ := AddRoomMessage("main", "alice", "hi", "", ts)
+		if err := SaveMessageEdit(scope, id, "alice", MessageEdit{Content: "edited", EditedBy: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := pruneEdits(scope, ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	edits, err := GetMessageEdits(scope, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || edits[ids[2]].Content != "edited" {
+		t.Fatalf("edits after prune = %+v", edits)
+	}
+	if n, _ := Rdb.ZCard(Rctx, editedKey(scope)).Result(); n != 1 {
+		t.Fatalf("edited index has %d entries", n)
+	}
+}
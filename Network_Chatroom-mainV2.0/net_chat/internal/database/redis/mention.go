@@ -0,0 +1,142 @@
+package redis
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//@提及
+//mentions:<user>        有序集合，成员为"<范围>|<消息ID>"，分值为消息时间（毫秒），只保留最近MaxMentions条
+//mentionunseen:<user>   有序集合，还没看过的提及，成员和分值与mentions相同
+//实时推送或在列表中显示过的提及逐条从未看集合中去掉，互不影响
+
+// MaxMentions 每个用户保留的提及数
+const MaxMentions = 200
+
+func mentionKey(user string) string {
+	return "mentions:" + user
+}
+
+func mentionUnseenKey(user string) string {
+	return "mentionunseen:" + user
+}
+
+// MentionRef 一条提及指向的消息
+type MentionRef struct {
+	Scope string
+	ID    string
+}
+
+func (r MentionRef) member() string {
+	return r.Scope + "|" + r.ID
+}
+
+// 保存一条未看的提及，超出上限时删除最早的，并去掉未看集合中已经被删除的提及
+var addMentionScript = redis.NewScript(`
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[2], ARGV[1], ARGV[2])
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[3]) - 1)
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', '(' .. oldest[2])
+end
+return 1
+`)
+
+// AddMentions 记录消息提到了users，记为未看
+func AddMentions(scope, id string, users []string) error {
+	ref := MentionRef{Scope: scope, ID: id}
+	score := strconv.FormatFloat(idMillis(id), 'f', 0, 64)
+	for _, user := range users {
+		keys := []string{mentionKey(user), mentionUnseenKey(user)}
+		if err := addMentionScript.Run(Rctx, Rdb, keys, score, ref.member(), MaxMentions).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMentions 按时间倒序返回用户比before更早的最多limit条提及，before为空时从最新的开始
+// unseen中为其中还没看过的提及
+func GetMentions(user, before string, limit int) (refs []MentionRef, unseen map[MentionRef]bool, hasMore bool, err error) {
+	max := "+inf"
+	if before != "" {
+		max = strings.SplitN(before, "-", 2)[0]
+	}
+	members, err := Rdb.ZRevRangeByScore(Rctx, mentionKey(user), &redis.ZRangeBy{Min: "-inf", Max: max}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, false, err
+	}
+	for _, m := range members {
+		i := strings.LastIndex(m, "|")
+		if i < 0 {
+			continue
+		}
+		ref := MentionRef{Scope: m[:i], ID: m[i+1:]}
+		//同一毫秒内的消息按序号再比较一次
+		if before != "" && CompareID(ref.ID, before) >= 0 {
+			continue
+		}
+		if len(refs) == limit {
+			hasMore = true
+			break
+		}
+		refs = append(refs, ref)
+	}
+	if len(refs) == 0 {
+		return refs, nil, hasMore, nil
+	}
+	pipe := Rdb.Pipeline()
+	cmds := make([]*redis.FloatCmd, len(refs))
+	for i, r := range refs {
+		cmds[i] = pipe.ZScore(Rctx, mentionUnseenKey(user), r.member())
+	}
+	//已看过的提及返回redis.Nil，单独检查每条命令
+	if _, err := pipe.Exec(Rctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, false, err
+	}
+	unseen = make(map[MentionRef]bool)
+	for i, cmd := range cmds {
+		if cmd.Err() == nil {
+			unseen[refs[i]] = true
+		}
+	}
+	return refs, unseen, hasMore, nil
+}
+
+// CountUnseenMentions 返回用户还没看过的提及数
+func CountUnseenMentions(user string) (int64, error) {
+	return Rdb.ZCard(Rctx, mentionUnseenKey(user)).Result()
+}
+
+// MarkMentionsSeen 把这些提及记为已看，不影响其他未看的提及
+func MarkMentionsSeen(user string, refs []MentionRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	return Rdb.ZRem(Rctx, mentionUnseenKey(user), mentionMembers(refs)...).Err()
+}
+
+// RemoveMentions 去掉指向已经不存在的消息的提及
+func RemoveMentions(user string, refs []MentionRef) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	members := mentionMembers(refs)
+	pipe := Rdb.TxPipeline()
+	pipe.ZRem(Rctx, mentionKey(user), members...)
+	pipe.ZRem(Rctx, mentionUnseenKey(user), members...)
+	_, err := pipe.Exec(Rctx)
+	return err
+}
+
+func mentionMembers(refs []MentionRef) []interface{} {
+	members := make([]interface{}, len(refs))
+	for i, r := range refs {
+		members[i] = r.member()
+	}
+	return members
+}
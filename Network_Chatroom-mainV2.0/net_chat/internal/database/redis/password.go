@@ -0,0 +1,29 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+)
+
+// 管理员重置的一次性密码只能在有效期内登录一次
+// pwreset:<user> 存在表示一次性密码还可以使用，登录时删除
+func oneTimePasswordKey(username string) string {
+	return "pwreset:" + username
+}
+
+// SetOneTimePassword 记录用户有一个可以使用的一次性密码，覆盖之前的
+func SetOneTimePassword(username string, ttl time.Duration) error {
+	if err := Rdb.Set(Rctx, oneTimePasswordKey(username), "1", ttl).Err(); err != nil {
+		return fmt.Errorf("记录一次性密码失败:%w", err)
+	}
+	return nil
+}
+
+// UseOneTimePassword 消耗一次性密码，返回false表示已经用过或已过期
+func UseOneTimePassword(username string) (bool, error) {
+	n, err := Rdb.Del(Rctx, oneTimePasswordKey(username)).Result()
+	if err != nil {
+		return false, fmt.Errorf("消耗一次性密码失败:%w", err)
+	}
+	return n == 1, nil
+}
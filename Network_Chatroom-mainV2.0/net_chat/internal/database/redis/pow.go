@@ -0,0 +1,38 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func registerAttemptKey(now time.Time) string {
+	return fmt.Sprintf("pow:register:%d", now.Unix()/60)
+}
+
+// RecordRegisterAttempt 记录一次通过工作量证明的注册，用于在注册高峰时提高难度
+// 只统计解出的挑战，单纯反复申请挑战不会推高其他人的难度
+func RecordRegisterAttempt(now time.Time) error {
+	key := registerAttemptKey(now)
+	pipe := Rdb.TxPipeline()
+	pipe.Incr(Rctx, key)
+	pipe.Expire(Rctx, key, 2*time.Minute)
+	if _, err := pipe.Exec(Rctx); err != nil {
+		return fmt.Errorf("记录注册次数失败:%w", err)
+	}
+	return nil
+}
+
+// CountRegisterAttempts 返回当前这一分钟内通过工作量证明的注册次数
+func CountRegisterAttempts(now time.Time) (int64, error) {
+	n, err := Rdb.Get(Rctx, registerAttemptKey(now)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("读取注册次数失败:%w", err)
+	}
+	return n, nil
+}
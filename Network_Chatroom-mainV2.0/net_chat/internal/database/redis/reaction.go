@@ -0,0 +1,190 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//表情回应
+//reactions:<范围>|<消息ID>          哈希表，表情 -> 人数
+//reactors:<范围>|<消息ID>|<表情>    集合，回应了该表情的用户
+//reacted:<范围>                     有序集合，有回应的消息ID，分值为消息时间（毫秒），清理过期消息时使用
+
+// MaxReactionKinds 一条消息最多的表情种类
+const MaxReactionKinds = 20
+
+// ErrTooManyReactions 消息上的表情种类已达上限
+var ErrTooManyReactions = errors.New("该消息的表情种类已达上限")
+
+func reactionKey(scope, id string) string {
+	return "reactions:" + scope + "|" + id
+}
+
+func reactorKey(scope, id, emoji string) string {
+	return "reactors:" + scope + "|" + id + "|" + emoji
+}
+
+func reactedKey(scope string) string {
+	return "reacted:" + scope
+}
+
+// 添加时返回新的人数，取消时返回剩余人数，没有变化返回-1，表情种类已满返回-2
+var reactScript = redis.NewScript(`
+if ARGV[3] == '1' then
+	if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 and redis.call('HLEN', KEYS[1]) >= tonumber(ARGV[5]) then
+		return -2
+	end
+	if redis.call('SADD', KEYS[2], ARGV[2]) == 0 then
+		return -1
+	end
+	redis.call('ZADD', KEYS[3], ARGV[4], ARGV[6])
+	return redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+end
+if redis.call('SREM', KEYS[2], ARGV[2]) == 0 then
+	return -1
+end
+local n = redis.call('HINCRBY', KEYS[1], ARGV[1], -1)
+if n <= 0 then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+	if redis.call('HLEN', KEYS[1]) == 0 then
+		redis.call('ZREM', KEYS[3], ARGV[6])
+	end
+end
+return n
+`)
+
+// React 用户对消息添加（add为true）或取消一个表情，返回是否有变化
+func React(scope, id, user, emoji string, add bool) (bool, error) {
+	op := "0"
+	if add {
+		op = "1"
+	}
+	keys := []string{reactionKey(scope, id), reactorKey(scope, id, emoji), reactedKey(scope)}
+	n, err := reactScript.Run(Rctx, Rdb, keys, emoji, user, op,
+		strconv.FormatFloat(idMillis(id), 'f', 0, 64), MaxReactionKinds, id).Int64()
+	if err != nil {
+		return false, err
+	}
+	if n == -2 {
+		return false, ErrTooManyReactions
+	}
+	return n >= 0, nil
+}
+
+// Reaction 一种表情的回应情况
+type Reaction struct {
+	Emoji string
+	Count int64
+	Users []string //按用户名排序
+}
+
+// GetReactions 批量读取消息的表情回应，按人数从多到少排列，只返回有回应的消息
+func GetReactions(scope string, ids []string) (map[string][]Reaction, error) {
+	ids = uniqueIDs(ids)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	pipe := Rdb.Pipeline()
+	counts := make([]*redis.StringStringMapCmd, len(ids))
+	for i, id := range ids {
+		counts[i] = pipe.HGetAll(Rctx, reactionKey(scope, id))
+	}
+	if _, err := pipe.Exec(Rctx); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]Reaction)
+	pipe = Rdb.Pipeline()
+	var users []*redis.StringSliceCmd
+	type ref struct {
+		id    string
+		index int
+	}
+	var refs []ref
+	for i, cmd := range counts {
+		for emoji, v := range cmd.Val() {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				continue
+			}
+			result[ids[i]] = append(result[ids[i]], Reaction{Emoji: emoji, Count: n})
+			refs = append(refs, ref{ids[i], len(result[ids[i]]) - 1})
+			users = append(users, pipe.SMembers(Rctx, reactorKey(scope, ids[i], emoji)))
+		}
+	}
+	if len(users) == 0 {
+		return result, nil
+	}
+	if _, err := pipe.Exec(Rctx); err != nil {
+		return nil, fmt.Errorf("读取表情回应的用户失败:%w", err)
+	}
+	for i, cmd := range users {
+		names := cmd.Val()
+		sort.Strings(names)
+		result[refs[i].id][refs[i].index].Users = names
+	}
+	for _, list := range result {
+		sort.Slice(list, func(a, b int) bool {
+			if list[a].Count != list[b].Count {
+				return list[a].Count > list[b].Count
+			}
+			return list[a].Emoji < list[b].Emoji
+		})
+	}
+	return result, nil
+}
+
+// 去掉重复的ID，保持原来的顺序
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := ids[:0:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// 删除已经被裁剪掉的消息的表情回应
+func pruneReactions(scope, cut string) error {
+	ms, _ := parseID(cut)
+	ids, err := Rdb.ZRangeByScore(Rctx, reactedKey(scope), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatUint(ms, 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, id := range ids {
+		if CompareID(id, cut) >= 0 {
+			continue
+		}
+		emojis, err := Rdb.HKeys(Rctx, reactionKey(scope, id)).Result()
+		if err != nil {
+			return err
+		}
+		keys := []string{reactionKey(scope, id)}
+		for _, emoji := range emojis {
+			keys = append(keys, reactorKey(scope, id, emoji))
+		}
+		if err := Rdb.Del(Rctx, keys...).Err(); err != nil {
+			return err
+		}
+		stale = append(stale, id)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(stale))
+	for i, id := range stale {
+		members[i] = id
+	}
+	return Rdb.ZRem(Rctx, reactedKey(scope), members...).Err()
+}
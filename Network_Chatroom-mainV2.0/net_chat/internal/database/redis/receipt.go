@@ -0,0 +1,91 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"regexp"
+)
+
+//私聊回执
+//pmack:<user>  哈希表记录 发送者 -> user的客户端确认收到的最后一条消息ID，同时是离线私聊的投递位置
+//pmread:<user> 哈希表记录 发送者 -> user已读的最后一条消息ID
+//位置只会前移，一个位置代表该位置及之前的消息都已送达/已读
+
+var streamIDPattern = regexp.MustCompile(`^\d+-\d+$`)
+
+// IsStreamID 检查是否为合法的stream ID格式
+func IsStreamID(id string) bool {
+	return streamIDPattern.MatchString(id)
+}
+
+func ackKey(user string) string {
+	return fmt.Sprintf("pmack:%s", user)
+}
+
+func readKey(user string) string {
+	return fmt.Sprintf("pmread:%s", user)
+}
+
+// IsPrivateMessageFrom 检查id是否是sender发给user的一条私聊消息
+func IsPrivateMessageFrom(user, sender, id string) (bool, error) {
+	if !streamIDPattern.MatchString(id) {
+		return false, nil
+	}
+	msgs, err := Rdb.XRangeN(Rctx, privateStreamKey(user, sender), id, id, 1).Result()
+	if err != nil {
+		return false, err
+	}
+	return len(msgs) == 1 && msgs[0].Values["sender"] == sender, nil
+}
+
+// LastPrivateMessageFrom 返回sender在与user的私聊中发出的最后一条消息ID，没有时返回空
+func LastPrivateMessageFrom(user, sender string) (string, error) {
+	key := privateStreamKey(user, sender)
+	end := "+"
+	for {
+		msgs, err := Rdb.XRevRangeN(Rctx, key, end, "-", deliveryPageSize).Result()
+		if err != nil {
+			return "", err
+		}
+		for _, msg := range msgs {
+			if msg.Values["sender"] == sender {
+				return msg.ID, nil
+			}
+		}
+		if len(msgs) < deliveryPageSize {
+			return "", nil
+		}
+		end = "(" + msgs[len(msgs)-1].ID
+	}
+}
+
+// MarkPrivateAcked 记录user的客户端已收到sender发来的、截至id的消息，返回位置是否前移
+func MarkPrivateAcked(user, sender, id string) (bool, error) {
+	return setMaxID(ackKey(user), sender, id)
+}
+
+// MarkPrivateRead 记录user已读sender发来的、截至id的消息，已读也意味着已送达
+func MarkPrivateRead(user, sender, id string) (bool, error) {
+	if _, err := setMaxID(ackKey(user), sender, id); err != nil {
+		return false, err
+	}
+	return setMaxID(readKey(user), sender, id)
+}
+
+// GetPrivateReceipts 返回sender发给recipient的消息已送达、已读到的位置
+func GetPrivateReceipts(sender, recipient string) (delivered, read string, err error) {
+	delivered, err = Rdb.HGet(Rctx, ackKey(recipient), sender).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", "", err
+	}
+	//第一条消息保存时投递位置设在它之前，这时还没有送达任何消息
+	if delivered == "0-0" {
+		delivered = ""
+	}
+	read, err = Rdb.HGet(Rctx, readKey(recipient), sender).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", "", err
+	}
+	return delivered, read, nil
+}
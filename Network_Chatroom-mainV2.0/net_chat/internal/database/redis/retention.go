@@ -0,0 +1,284 @@
+package redis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"net_chat/internal/search"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//stream的保留策略
+//每次写入后检查该stream，定期清理时检查全部stream；超出条数或过期的消息先交给归档函数（如果有），再用XTRIM MINID删除
+//开启MySQL归档时，还没归档的消息会保留到归档之后
+//同一个stream同时只允许一处清理（trimlock:<stream>），避免写入后的清理和定期清理把同一批消息归档两次
+
+// Retention 一个stream的保留策略，0表示不限制
+type Retention struct {
+	MaxLen int64         //最多保留的条数
+	MaxAge time.Duration //最长保留时间
+}
+
+func (r Retention) enabled() bool {
+	return r.MaxLen > 0 || r.MaxAge > 0
+}
+
+// RetentionPolicy 全部stream的保留策略
+type RetentionPolicy struct {
+	Room    Retention            //聊天室的默认策略
+	Rooms   map[string]Retention //单独设置的聊天室
+	Private Retention            //私聊
+	//删除前归档，为nil时直接删除；归档失败时不删除，等下次再试
+	Archive func(stream string, msgs []redis.XMessage) error
+}
+
+// Enabled 是否有任何stream需要清理
+func (p RetentionPolicy) Enabled() bool {
+	if p.Room.enabled() || p.Private.enabled() {
+		return true
+	}
+	for _, r := range p.Rooms {
+		if r.enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// 启动时设置，之后只读
+var retention RetentionPolicy
+
+// 归档时每次读取的条数
+const archivePageSize = 500
+
+// 清理锁的有效期，持有者异常退出时锁会自动失效
+const trimLockTTL = 5 * time.Minute
+
+// SetRetention 设置保留策略，需要在服务端开始处理消息之前调用
+func SetRetention(p RetentionPolicy) {
+	retention = p
+}
+
+// 返回stream适用的保留策略
+func retentionFor(key string) Retention {
+	if strings.HasPrefix(key, roomStreamPrefix) {
+		if r, ok := retention.Rooms[strings.TrimPrefix(key, roomStreamPrefix)]; ok {
+			return r
+		}
+		return retention.Room
+	}
+	return retention.Private
+}
+
+// 写入后按策略清理该stream，now为服务端时间
+func applyRetention(key string, now time.Time) error {
+	r := retentionFor(key)
+	if !r.enabled() {
+		return nil
+	}
+	_, err := trimStream(key, r, now)
+	return err
+}
+
+// TrimAllStreams 按策略清理全部聊天室和私聊的stream，返回删除的条数
+func TrimAllStreams(now time.Time) (int64, error) {
+	var total int64
+	for _, pattern := range []string{roomStreamPrefix + "*", "stream:pm:*"} {
+		iter := Rdb.ScanType(Rctx, 0, pattern, 100, "stream").Iterator()
+		for iter.Next(Rctx) {
+			r := retentionFor(iter.Val())
+			if !r.enabled() {
+				continue
+			}
+			n, err := trimStream(iter.Val(), r, now)
+			total += n
+			if err != nil {
+				return total, fmt.Errorf("清理%s失败:%w", iter.Val(), err)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// 计算需要保留的第一条消息ID（小于它的都要删除），没有需要删除的返回空
+func trimCutoff(key string, r Retention, now time.Time) (string, error) {
+	var cut string
+	if r.MaxAge > 0 {
+		first, err := Rdb.XRangeN(Rctx, key, "-", "+", 1).Result()
+		if err != nil {
+			return "", err
+		}
+		ageCut := fmt.Sprintf("%d-0", now.Add(-r.MaxAge).UnixMilli())
+		if len(first) == 1 && CompareID(first[0].ID, ageCut) < 0 {
+			cut = ageCut
+		}
+	}
+	if r.MaxLen > 0 {
+		n, err := Rdb.XLen(Rctx, key).Result()
+		if err != nil {
+			return "", err
+		}
+		if n > r.MaxLen {
+			//超出的最后一条之后的ID就是要保留的第一条
+			excess, err := Rdb.XRangeN(Rctx, key, "-", "+", n-r.MaxLen).Result()
+			if err != nil {
+				return "", err
+			}
+			if len(excess) > 0 {
+				lenCut := nextID(excess[len(excess)-1].ID)
+				if cut == "" || CompareID(lenCut, cut) > 0 {
+					cut = lenCut
+				}
+			}
+		}
+	}
+	return cut, nil
+}
+
+// 删除stream中cut之前的消息，需要时先归档；其他地方正在清理该stream时直接返回
+func trimStream(key string, r Retention, now time.Time) (int64, error) {
+	unlock, ok, err := lockTrim(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	defer unlock()
+
+	cut, err := trimCutoff(key, r, now)
+	if err != nil || cut == "" {
+		return 0, err
+	}
+	//还没归档到MySQL的消息不能删除
+	if archiveWatermark {
+		archived, err := archivedBefore(key)
+		if err != nil {
+			return 0, err
+		}
+		if CompareID(archived, cut) < 0 {
+			cut = archived
+		}
+	}
+	scope := strings.TrimPrefix(key, "stream:")
+	start := "-"
+	for {
+		msgs, err := Rdb.XRangeN(Rctx, key, start, "("+cut, archivePageSize).Result()
+		if err != nil {
+			return 0, err
+		}
+		if len(msgs) > 0 {
+			if retention.Archive != nil {
+				if err := retention.Archive(key, msgs); err != nil {
+					return 0, fmt.Errorf("归档失败:%w", err)
+				}
+			}
+			if err := unindexMessages(scope, msgs); err != nil {
+				return 0, fmt.Errorf("清理搜索索引失败:%w", err)
+			}
+		}
+		if len(msgs) < archivePageSize {
+			break
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+	}
+	n, err := Rdb.XTrimMinID(Rctx, key, cut).Result()
+	if err != nil {
+		return n, err
+	}
+	//归档到MySQL的消息仍然要用修改记录和表情回应，只在没有归档时清理
+	if !archiveWatermark {
+		if err := pruneEdits(scope, cut); err != nil {
+			return n, err
+		}
+		if err := pruneReactions(scope, cut); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// 只有持有者才能释放锁
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// 获取stream的清理锁，ok为false表示其他地方正在清理
+func lockTrim(key string) (unlock func(), ok bool, err error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, false, err
+	}
+	lockKey, token := "trimlock:"+key, hex.EncodeToString(buf)
+	ok, err = Rdb.SetNX(Rctx, lockKey, token, trimLockTTL).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return func() {
+		unlockScript.Run(Rctx, Rdb, []string{lockKey}, token)
+	}, true, nil
+}
+
+// 从搜索索引中去掉被删除的消息，修改过的消息还要去掉修改后内容的词
+func unindexMessages(scope string, msgs []redis.XMessage) error {
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	edits, err := GetMessageEdits(scope, ids)
+	if err != nil {
+		return err
+	}
+	pipe := Rdb.Pipeline()
+	for _, msg := range msgs {
+		member := scope + "|" + msg.ID
+		sender, _ := msg.Values["sender"].(string)
+		content, _ := msg.Values["content"].(string)
+		pipe.ZRem(Rctx, searchSenderKey(sender), member)
+		tokens := search.IndexTokens(content)
+		if e, ok := edits[msg.ID]; ok && e.Content != "" {
+			tokens = append(tokens, search.IndexTokens(e.Content)...)
+		}
+		for _, token := range tokens {
+			pipe.ZRem(Rctx, searchWordKey(token), member)
+		}
+	}
+	_, err = pipe.Exec(Rctx)
+	return err
+}
+
+// 解析stream ID
+func parseID(id string) (ms, seq uint64) {
+	parts := strings.SplitN(id, "-", 2)
+	ms, _ = strconv.ParseUint(parts[0], 10, 64)
+	if len(parts) == 2 {
+		seq, _ = strconv.ParseUint(parts[1], 10, 64)
+	}
+	return ms, seq
+}
+
+// CompareID 比较两个stream ID，a<b返回-1，相等返回0，a>b返回1
+func CompareID(a, b string) int {
+	am, as := parseID(a)
+	bm, bs := parseID(b)
+	switch {
+	case am < bm || (am == bm && as < bs):
+		return -1
+	case am == bm && as == bs:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// 紧接在id之后的ID
+func nextID(id string) string {
+	ms, seq := parseID(id)
+	return fmt.Sprintf("%d-%d", ms, seq+1)
+}
@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	if err := InitRedis(mr.Addr(), "", 0); err != nil {
+		t.Fatal(err)
+	}
+	return mr
+}
+
+func TestCompareID(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1-0", "1-0", 0},
+		{"1-0", "2-0", -1},
+		{"2-0", "1-0", 1},
+		{"5-1", "5-2", -1},
+		{"5-10", "5-9", 1},
+		{"10-0", "9-99", 1},
+		{"5", "5-0", 0},
+		{"0-0", "1-0", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareID(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareID(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestNextID(t *testing.T) {
+	tests := []struct {
+		id, want string
+	}{
+		{"0-0", "0-1"},
+		{"1700000000000-0", "1700000000000-1"},
+		{"5-9", "5-10"},
+		{"5", "5-1"},
+	}
+	for _, tt := range tests {
+		if got := nextID(tt.id); got != tt.want {
+			t.Errorf("nextID(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+		if CompareID(tt.id, nextID(tt.id)) >= 0 {
+			t.Errorf("nextID(%q) is not after it", tt.id)
+		}
+	}
+}
+
+func TestTrimRemovesSearchIndex(t *testing.T) {
+	newTestRedis(t)
+	SetRetention(RetentionPolicy{})
+	t.Cleanup(func() { SetRetention(RetentionPolicy{}) })
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Unix()
+	old, _ := AddRoomMessage("main", "alice", "hello world", "", ts)
+	if err := SaveMessageEdit(RoomScope("main"), old, "alice", MessageEdit{Content: "goodbye moon", EditedBy: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	keep, _ := AddRoomMessage("main", "alice", "hello again", "", ts)
+
+	if _, err := trimStream(roomStreamKey("main"), Retention{MaxLen: 1}, time.Unix(ts, 0)); err != nil {
+		t.Fatal(err)
+	}
+	member := RoomScope("main") + "|" + old
+	for _, key := range []string{searchSenderKey("alice"), searchWordKey("hello"), searchWordKey("goodbye")} {
+		if _, err := Rdb.ZScore(Rctx, key, member).Result(); err != redis.Nil {
+			t.Errorf("%s still indexes the trimmed message", key)
+		}
+	}
+	if _, err := Rdb.ZScore(Rctx, searchWordKey("hello"), RoomScope("main")+"|"+keep).Result(); err != nil {
+		t.Errorf("kept message lost its index: %v", err)
+	}
+}
+
+func TestTrimLockArchivesOnce(t *testing.T) {
+	newTestRedis(t)
+	archived := 0
+	SetRetention(RetentionPolicy{Archive: func(stream string, msgs []redis.XMessage) error {
+		archived += len(msgs)
+		//归档期间另一处清理同一个stream，应该直接跳过
+		n, err := trimStream(stream, Retention{MaxLen: 1}, time.Now())
+		if err != nil || n != 0 {
+			t.Errorf("nested trim = %d, %v", n, err)
+		}
+		return nil
+	}})
+	t.Cleanup(func() { SetRetention(RetentionPolicy{}) })
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Unix()
+	for i := 0; i < 3; i++ {
+		AddRoomMessage("main", "alice", "hi", "", ts)
+	}
+	n, err := trimStream(roomStreamKey("main"), Retention{MaxLen: 1}, time.Unix(ts, 0))
+	if err != nil || n != 2 || archived != 2 {
+		t.Fatalf("trim = %d, %v, archived %d", n, err, archived)
+	}
+	//锁已释放，之后的清理可以继续
+	if _, err := Rdb.Get(Rctx, "trimlock:"+roomStreamKey("main")).Result(); err != redis.Nil {
+		t.Fatal("trim lock not released")
+	}
+}
@@ -0,0 +1,98 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strings"
+)
+
+//聊天室已读位置
+//roomread:<user> 哈希表记录 聊天室 -> 该用户已读的最后一条消息ID，未读数从这个位置之后的房间消息计算
+
+// MaxUnreadCount 未读数最多统计到这里，超过时客户端显示为"999+"
+const MaxUnreadCount = 999
+
+const roomStreamPrefix = "stream:room:"
+
+func roomStreamKey(room string) string {
+	return roomStreamPrefix + room
+}
+
+func roomReadKey(user string) string {
+	return fmt.Sprintf("roomread:%s", user)
+}
+
+// ListRooms 返回所有有消息记录的聊天室
+func ListRooms() ([]string, error) {
+	var rooms []string
+	iter := Rdb.Scan(Rctx, 0, roomStreamPrefix+"*", 100).Iterator()
+	for iter.Next(Rctx) {
+		rooms = append(rooms, strings.TrimPrefix(iter.Val(), roomStreamPrefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+// MarkRoomRead 把用户在聊天室中的已读位置前移到id，id为空时标记全部已读
+func MarkRoomRead(user, room, id string) error {
+	key := roomStreamKey(room)
+	if id == "" {
+		msgs, err := Rdb.XRevRangeN(Rctx, key, "+", "-", 1).Result()
+		if err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+		id = msgs[0].ID
+	} else {
+		if !streamIDPattern.MatchString(id) {
+			return fmt.Errorf("无效的消息ID")
+		}
+		msgs, err := Rdb.XRangeN(Rctx, key, id, id, 1).Result()
+		if err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			return fmt.Errorf("消息不存在")
+		}
+	}
+	_, err := setMaxID(roomReadKey(user), room, id)
+	return err
+}
+
+// GetRoomUnread 返回用户在聊天室中的已读位置以及之后他人发送的消息数（最多MaxUnreadCount）
+func GetRoomUnread(user, room string) (lastRead string, unread int64, err error) {
+	lastRead, err = Rdb.HGet(Rctx, roomReadKey(user), room).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", 0, err
+	}
+	start := "-"
+	if lastRead != "" {
+		start = "(" + lastRead
+	}
+	key := roomStreamKey(room)
+	for unread < MaxUnreadCount {
+		msgs, err := Rdb.XRangeN(Rctx, key, start, "+", deliveryPageSize).Result()
+		if err != nil {
+			return "", 0, err
+		}
+		for _, msg := range msgs {
+			//自己发的消息不算未读
+			if msg.Values["sender"] != user {
+				unread++
+			}
+		}
+		if len(msgs) < deliveryPageSize {
+			break
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+	}
+	if unread > MaxUnreadCount {
+		unread = MaxUnreadCount
+	}
+	return lastRead, unread, nil
+}
@@ -0,0 +1,263 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"net_chat/internal/search"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//全文搜索的倒排索引
+//search:word:<词>      有序集合，成员为"<范围>|<消息ID>"，分值为消息时间（毫秒）
+//search:sender:<用户>  同上，按发送者查找
+//范围为 room:<聊天室> 或 pm:<用户A>:<用户B>，去掉"stream:"前缀就是消息所在的stream
+
+const searchPrefix = "search:"
+
+// 每次从索引中取出的候选数，以及一次搜索最多检查的候选数
+const (
+	searchPageSize = 200
+	maxSearchScan  = 5000
+)
+
+func searchWordKey(token string) string {
+	return searchPrefix + "word:" + token
+}
+
+func searchSenderKey(sender string) string {
+	return searchPrefix + "sender:" + sender
+}
+
+// 消息ID中的毫秒时间
+func idMillis(id string) float64 {
+	ms, _ := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	return float64(ms)
+}
+
+// 把一条消息的索引写入pipe
+func indexMessage(pipe redis.Pipeliner, streamKey, id, sender, content string) {
+	z := &redis.Z{
+		Score:  idMillis(id),
+		Member: strings.TrimPrefix(streamKey, "stream:") + "|" + id,
+	}
+	pipe.ZAdd(Rctx, searchSenderKey(sender), z)
+	for _, token := range search.IndexTokens(content) {
+		pipe.ZAdd(Rctx, searchWordKey(token), z)
+	}
+}
+
+// 保存消息后更新索引
+func addToIndex(streamKey, id, sender, content string) error {
+	pipe := Rdb.Pipeline()
+	indexMessage(pipe, streamKey, id, sender, content)
+	_, err := pipe.Exec(Rctx)
+	return err
+}
+
+// SearchOptions 搜索条件，关键词和发送者至少要有一个
+type SearchOptions struct {
+	Keyword string
+	Sender  string
+	From    int64 //开始时间（unix秒），0表示不限
+	To      int64 //结束时间（unix秒，包含），0表示不限
+	Limit   int
+}
+
+// SearchHit 一条搜索结果，Room和Conversation只有一个不为空
+type SearchHit struct {
+	Room         string
+	Conversation string
+	Message      redis.XMessage
+}
+
+// Search 按时间倒序返回符合条件的消息，allow判断请求者能否查看该聊天室或私聊会话
+// hasMore表示还有更多结果（或候选太多，没有全部检查）
+func Search(opts SearchOptions, allow func(room, conversation string) bool) ([]SearchHit, bool, error) {
+	var keys []string
+	if opts.Keyword != "" {
+		tokens := search.QueryTokens(opts.Keyword)
+		if len(tokens) == 0 {
+			return nil, false, fmt.Errorf("关键词太短")
+		}
+		for _, token := range tokens {
+			keys = append(keys, searchWordKey(token))
+		}
+	}
+	if opts.Sender != "" {
+		keys = append(keys, searchSenderKey(opts.Sender))
+	}
+	if len(keys) == 0 {
+		return nil, false, fmt.Errorf("请输入关键词或发送者")
+	}
+
+	//多个条件时先求交集
+	setKey := keys[0]
+	if len(keys) > 1 {
+		seq, err := Rdb.Incr(Rctx, searchPrefix+"tmpseq").Result()
+		if err != nil {
+			return nil, false, err
+		}
+		setKey = fmt.Sprintf("%stmp:%d", searchPrefix, seq)
+		pipe := Rdb.Pipeline()
+		pipe.ZInterStore(Rctx, setKey, &redis.ZStore{Keys: keys, Aggregate: "MAX"})
+		pipe.Expire(Rctx, setKey, time.Minute)
+		if _, err := pipe.Exec(Rctx); err != nil {
+			return nil, false, err
+		}
+		defer Rdb.Del(Rctx, setKey)
+	}
+
+	rng := &redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: searchPageSize}
+	if opts.From > 0 {
+		rng.Min = strconv.FormatInt(opts.From*1000, 10)
+	}
+	if opts.To > 0 {
+		rng.Max = strconv.FormatInt(opts.To*1000+999, 10)
+	}
+	var hits []SearchHit
+	for rng.Offset = 0; rng.Offset < maxSearchScan; rng.Offset += searchPageSize {
+		members, err := Rdb.ZRevRangeByScore(Rctx, setKey, rng).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		found, err := loadHits(members, opts, allow)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, hit := range found {
+			if len(hits) == opts.Limit {
+				return hits, true, nil
+			}
+			hits = append(hits, hit)
+		}
+		if len(members) < searchPageSize {
+			return hits, false, nil
+		}
+	}
+	return hits, true, nil
+}
+
+// 读取有权限查看的候选消息，并排除已被删除或内容不匹配的
+func loadHits(members []string, opts SearchOptions, allow func(room, conversation string) bool) ([]SearchHit, error) {
+	var hits []SearchHit
+	var cmds []*redis.XMessageSliceCmd
+	var edits []*redis.StringCmd
+	pipe := Rdb.Pipeline()
+	for _, member := range members {
+		sep := strings.LastIndex(member, "|")
+		if sep < 0 {
+			continue
+		}
+		scope, id := member[:sep], member[sep+1:]
+		var hit SearchHit
+		switch {
+		case strings.HasPrefix(scope, "room:"):
+			hit.Room = strings.TrimPrefix(scope, "room:")
+		case strings.HasPrefix(scope, "pm:"):
+			hit.Conversation = strings.TrimPrefix(scope, "pm:")
+		default:
+			continue
+		}
+		if !allow(hit.Room, hit.Conversation) {
+			continue
+		}
+		hits = append(hits, hit)
+		cmds = append(cmds, pipe.XRangeN(Rctx, "stream:"+scope, id, id, 1))
+		edits = append(edits, pipe.HGet(Rctx, editKey(scope), id))
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(Rctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	result := hits[:0]
+	for i, cmd := range cmds {
+		msgs, err := cmd.Result()
+		if err != nil || len(msgs) == 0 {
+			continue
+		}
+		msg := msgs[0]
+		if opts.Sender != "" && msg.Values["sender"] != opts.Sender {
+			continue
+		}
+		content, _ := msg.Values["content"].(string)
+		//撤回的消息不出现在结果中，修改过的按修改后的内容匹配
+		if data, err := edits[i].Result(); err == nil {
+			var e MessageEdit
+			if json.Unmarshal([]byte(data), &e) == nil {
+				if e.Deleted {
+					continue
+				}
+				content = e.Content
+			}
+		}
+		if opts.Keyword != "" && !search.Match(content, opts.Keyword) {
+			continue
+		}
+		hits[i].Message = msg
+		result = append(result, hits[i])
+	}
+	return result, nil
+}
+
+// RebuildSearchIndex 删除旧索引，从所有聊天室和私聊的stream重新建立，返回建立索引的消息数
+func RebuildSearchIndex() (int, error) {
+	iter := Rdb.Scan(Rctx, 0, searchPrefix+"*", 500).Iterator()
+	for iter.Next(Rctx) {
+		if err := Rdb.Del(Rctx, iter.Val()).Err(); err != nil {
+			return 0, err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, pattern := range []string{roomStreamPrefix + "*", "stream:pm:*"} {
+		iter := Rdb.ScanType(Rctx, 0, pattern, 100, "stream").Iterator()
+		for iter.Next(Rctx) {
+			n, err := indexStream(iter.Val())
+			if err != nil {
+				return count, fmt.Errorf("为%s建立索引失败:%w", iter.Val(), err)
+			}
+			count += n
+		}
+		if err := iter.Err(); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// 为一个stream中的全部消息建立索引
+func indexStream(key string) (int, error) {
+	count := 0
+	start := "-"
+	for {
+		msgs, err := Rdb.XRangeN(Rctx, key, start, "+", searchPageSize).Result()
+		if err != nil {
+			return count, err
+		}
+		if len(msgs) > 0 {
+			pipe := Rdb.Pipeline()
+			for _, msg := range msgs {
+				sender, _ := msg.Values["sender"].(string)
+				content, _ := msg.Values["content"].(string)
+				indexMessage(pipe, key, msg.ID, sender, content)
+			}
+			if _, err := pipe.Exec(Rctx); err != nil {
+				return count, err
+			}
+			count += len(msgs)
+		}
+		if len(msgs) < searchPageSize {
+			return count, nil
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+	}
+}
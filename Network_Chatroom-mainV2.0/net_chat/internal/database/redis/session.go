@@ -0,0 +1,103 @@
+package redis
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+///////////会话令牌////////////////////
+
+// SessionTTL 会话令牌的有效期
+var SessionTTL = 24 * time.Hour
+
+// ErrSessionInvalid 令牌不存在、已过期或已被吊销
+var ErrSessionInvalid = errors.New("会话已失效,请重新登录")
+
+// redis中只保存令牌的哈希值，即使redis数据泄露也无法直接拿来登录
+func sessionKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("session:%s", hex.EncodeToString(sum[:]))
+}
+
+// 每个用户名下的所有会话，用于注销或修改密码时统一吊销
+func userSessionsKey(username string) string {
+	return fmt.Sprintf("sessions:%s", username)
+}
+
+// CreateSession 为用户生成一个新的不透明令牌，返回令牌和过期时间
+func CreateSession(username string) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, fmt.Errorf("生成会话令牌失败:%w", err)
+	}
+	token := hex.EncodeToString(buf)
+	key := sessionKey(token)
+	expiresAt := time.Now().Add(SessionTTL)
+
+	pipe := Rdb.TxPipeline()
+	pipe.Set(Rctx, key, username, SessionTTL)
+	pipe.SAdd(Rctx, userSessionsKey(username), key)
+	pipe.Expire(Rctx, userSessionsKey(username), SessionTTL)
+	if _, err := pipe.Exec(Rctx); err != nil {
+		return "", time.Time{}, fmt.Errorf("保存会话令牌失败:%w", err)
+	}
+	return token, expiresAt, nil
+}
+
+// GetSessionUser 根据令牌查询对应的用户名
+func GetSessionUser(token string) (string, error) {
+	if token == "" {
+		return "", ErrSessionInvalid
+	}
+	username, err := Rdb.Get(Rctx, sessionKey(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrSessionInvalid
+	}
+	if err != nil {
+		return "", fmt.Errorf("查询会话令牌失败:%w", err)
+	}
+	return username, nil
+}
+
+// RevokeSession 吊销单个令牌
+func RevokeSession(token string) error {
+	if token == "" {
+		return nil
+	}
+	key := sessionKey(token)
+	username, err := Rdb.Get(Rctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("查询会话令牌失败:%w", err)
+	}
+
+	pipe := Rdb.TxPipeline()
+	pipe.Del(Rctx, key)
+	pipe.SRem(Rctx, userSessionsKey(username), key)
+	_, err = pipe.Exec(Rctx)
+	return err
+}
+
+// RevokeUserSessions 吊销某个用户的全部令牌（修改密码、重置密码时使用）
+func RevokeUserSessions(username string) error {
+	setKey := userSessionsKey(username)
+	keys, err := Rdb.SMembers(Rctx, setKey).Result()
+	if err != nil {
+		return fmt.Errorf("查询用户%s的会话失败:%w", username, err)
+	}
+
+	pipe := Rdb.TxPipeline()
+	for _, key := range keys {
+		pipe.Del(Rctx, key)
+	}
+	pipe.Del(Rctx, setKey)
+	_, err = pipe.Exec(Rctx)
+	return err
+}
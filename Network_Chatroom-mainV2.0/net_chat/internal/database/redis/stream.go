@@ -8,16 +8,19 @@ import (
 	"time"
 )
 
-// AddRoomMessage 保存聊天室消息到消息队列中
-func AddRoomMessage(room string, sender string, content string) (string, error) {
+// AddRoomMessage 保存聊天室消息到消息队列中，replyTo为回复的消息ID（可以为空），ts为服务端时间（unix秒）
+func AddRoomMessage(room string, sender string, content string, replyTo string, ts int64) (string, error) {
 	if room == "" || sender == "" {
 		return "", fmt.Errorf("无发送方")
 	}
-	streamKey := fmt.Sprintf("stream:room:%s", room)
+	streamKey := roomStreamKey(room)
 	vals := map[string]interface{}{
 		"sender":  sender,
 		"content": content,
-		"ts":      time.Now().Unix(),
+		"ts":      ts,
+	}
+	if replyTo != "" {
+		vals["reply_to"] = replyTo
 	}
 
 	id, err := Rdb.XAdd(Rctx, &redis.XAddArgs{
@@ -27,19 +30,40 @@ func AddRoomMessage(room string, sender string, content string) (string, error)
 	if err != nil {
 		return "", err
 	}
+	//消息已经保存，索引失败时仍返回id
+	if err := addToIndex(streamKey, id, sender, content); err != nil {
+		return id, fmt.Errorf("更新搜索索引失败%w", err)
+	}
+	if err := addToThread(streamKey, replyTo, id); err != nil {
+		return id, fmt.Errorf("更新回复索引失败%w", err)
+	}
+	if err := applyRetention(streamKey, time.Unix(ts, 0)); err != nil {
+		return id, fmt.Errorf("清理过期消息失败%w", err)
+	}
 	return id, nil
 }
 
-// AddPrivateMessage 保存私聊消息到消息队列中
-func AddPrivateMessage(sender, recipient string, content string, recipientOnlie bool) (string, error) {
-	//对发送和接收者排序，保证私聊两个对象相同的话保存至一个stream中，节省空间
-	users := []string{sender, recipient}
+// ConversationID 两人私聊的会话标识，对双方排序，保证私聊两个对象相同的话保存至一个stream中，节省空间
+func ConversationID(userA, userB string) string {
+	users := []string{userA, userB}
 	sort.Strings(users)
-	streamKey := fmt.Sprintf("stream:pm:%s:%s", users[0], users[1])
+	return users[0] + ":" + users[1]
+}
+
+func privateStreamKey(userA, userB string) string {
+	return "stream:pm:" + ConversationID(userA, userB)
+}
+
+// AddPrivateMessage 保存私聊消息到消息队列中，replyTo为回复的消息ID（可以为空），ts为服务端时间（unix秒）
+func AddPrivateMessage(sender, recipient string, content string, replyTo string, ts int64, recipientOnlie bool) (string, error) {
+	streamKey := privateStreamKey(sender, recipient)
 	vals := map[string]interface{}{
 		"sender":  sender,
 		"content": content,
-		"ts":      time.Now().Unix(),
+		"ts":      ts,
+	}
+	if replyTo != "" {
+		vals["reply_to"] = replyTo
 	}
 
 	id, err := Rdb.XAdd(Rctx, &redis.XAddArgs{
@@ -49,7 +73,21 @@ func AddPrivateMessage(sender, recipient string, content string, recipientOnlie
 	if err != nil {
 		return "", err
 	}
+	//消息已经保存，索引失败时仍返回id
+	if err := addToIndex(streamKey, id, sender, content); err != nil {
+		return id, fmt.Errorf("更新搜索索引失败%w", err)
+	}
+	if err := addToThread(streamKey, replyTo, id); err != nil {
+		return id, fmt.Errorf("更新回复索引失败%w", err)
+	}
+	if err := applyRetention(streamKey, time.Unix(ts, 0)); err != nil {
+		return id, fmt.Errorf("清理过期消息失败%w", err)
+	}
 
+	//在线投递也可能在写出前断开，接收者确认之前都算待投递
+	if err := markPending(recipient, sender, streamKey, id); err != nil {
+		return id, fmt.Errorf("记录待投递消息失败%w", err)
+	}
 	//如果接收者不在线，记录未读数量
 	if !recipientOnlie {
 		unreadKey := fmt.Sprintf("unread:%s", recipient)
@@ -61,50 +99,68 @@ func AddPrivateMessage(sender, recipient string, content string, recipientOnlie
 	return id, nil
 }
 
-// GetRoomLastNMessage 获取房间中最近n条的消息并按时间顺序输出
-func GetRoomLastNMessage(room string, n int64) ([]redis.XMessage, error) {
-	key := fmt.Sprintf("stream:room:%s", room)
-	//反向遍历获取最近的n条消息
-	msgs, err := Rdb.XRevRangeN(Rctx, key, "+", "-", n).Result()
-	if err != nil {
-		//没有数据
-		if errors.Is(err, redis.Nil) {
-			return nil, nil
-		}
-		return nil, err
-	}
+// HistoryRange 历史消息的查询范围，优先级：After > Since > Before > 最新
+type HistoryRange struct {
+	Limit  int64  //每页条数
+	Before string //返回该ID之前的消息（向前翻页）
+	After  string //返回该ID之后的消息（向后翻页）
+	Since  int64  //返回该时间点（unix秒）及之后的消息
+}
+
+// GetRoomHistory 按范围获取聊天室消息，按时间顺序返回，hasMore表示翻页方向上是否还有消息
+func GetRoomHistory(room string, r HistoryRange) ([]redis.XMessage, bool, error) {
+	return getHistory(roomStreamKey(room), r)
+}
+
+// GetPrivateHistory 按范围获取两人之间的私聊消息
+func GetPrivateHistory(userA, userB string, r HistoryRange) ([]redis.XMessage, bool, error) {
+	return getHistory(privateStreamKey(userA, userB), r)
+}
 
-	if len(msgs) == 0 {
-		return nil, nil
+func getHistory(key string, r HistoryRange) ([]redis.XMessage, bool, error) {
+	if r.Limit <= 0 {
+		return nil, false, fmt.Errorf("无效的条数")
 	}
-	//反转成时间顺序
-	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
-		msgs[i], msgs[j] = msgs[j], msgs[i]
+	for _, id := range []string{r.Before, r.After} {
+		if id != "" && !streamIDPattern.MatchString(id) {
+			return nil, false, fmt.Errorf("无效的消息ID:%s", id)
+		}
 	}
-	return msgs, nil
-}
 
-// GetPrivateLastNMessage 获取房间中最近n条的消息并按时间顺序输出
-func GetPrivateLastNMessage(userA, userB string, n int64) ([]redis.XMessage, error) {
-	users := []string{userA, userB}
-	sort.Strings(users)
-	key := fmt.Sprintf("stream:pm:%s:%s", users[0], users[1])
-	msgs, err := Rdb.XRevRangeN(Rctx, key, "+", "-", n).Result()
+	//多取一条用来判断是否还有更多
+	var msgs []redis.XMessage
+	var err error
+	forward := r.After != "" || r.Since > 0
+	switch {
+	case r.After != "":
+		msgs, err = Rdb.XRangeN(Rctx, key, "("+r.After, "+", r.Limit+1).Result()
+	case r.Since > 0:
+		msgs, err = Rdb.XRangeN(Rctx, key, fmt.Sprintf("%d-0", r.Since*1000), "+", r.Limit+1).Result()
+	case r.Before != "":
+		msgs, err = Rdb.XRevRangeN(Rctx, key, "("+r.Before, "-", r.Limit+1).Result()
+	default:
+		//反向遍历获取最近的消息
+		msgs, err = Rdb.XRevRangeN(Rctx, key, "+", "-", r.Limit+1).Result()
+	}
 	if err != nil {
+		//没有数据
 		if errors.Is(err, redis.Nil) {
-			return nil, nil
+			return nil, false, nil
 		}
-		return nil, err
+		return nil, false, err
 	}
 
-	if len(msgs) == 0 {
-		return nil, nil
+	hasMore := int64(len(msgs)) > r.Limit
+	if hasMore {
+		msgs = msgs[:r.Limit]
 	}
-	//反转成时间顺序
-	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
-		msgs[i], msgs[j] = msgs[j], msgs[i]
+	if !forward {
+		//反转成时间顺序
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
 	}
-	return msgs, nil
+	return msgs, hasMore, nil
 }
 
 // GetUnreadForUser 获取用户未读消息提示数
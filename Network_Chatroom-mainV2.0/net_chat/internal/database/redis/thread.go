@@ -0,0 +1,71 @@
+package redis
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//回复索引：thread:<范围>|<被回复的消息ID> 有序集合，成员为回复的消息ID，分值为消息时间（毫秒）
+//回复被保留策略删除后，读取时再从索引中清理
+
+func threadKey(scope, parent string) string {
+	return "thread:" + scope + "|" + parent
+}
+
+// 保存回复后加入被回复消息的回复索引
+func addToThread(streamKey, parent, id string) error {
+	if parent == "" {
+		return nil
+	}
+	scope := strings.TrimPrefix(streamKey, "stream:")
+	return Rdb.ZAdd(Rctx, threadKey(scope, parent), &redis.Z{Score: idMillis(id), Member: id}).Err()
+}
+
+// GetThreadReplies 按时间顺序返回最早的limit条回复的ID，hasMore表示还有更多回复
+func GetThreadReplies(scope, parent string, limit int64) ([]string, bool, error) {
+	ids, err := Rdb.ZRange(Rctx, threadKey(scope, parent), 0, limit).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, false, err
+	}
+	hasMore := int64(len(ids)) > limit
+	if hasMore {
+		ids = ids[:limit]
+	}
+	return ids, hasMore, nil
+}
+
+// RemoveThreadReplies 从回复索引中去掉已经不存在的回复
+func RemoveThreadReplies(scope, parent string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	return Rdb.ZRem(Rctx, threadKey(scope, parent), members...).Err()
+}
+
+// GetMessages 批量按ID读取stream中的消息，只返回还存在的消息
+func GetMessages(scope string, ids []string) (map[string]redis.XMessage, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	pipe := Rdb.Pipeline()
+	cmds := make([]*redis.XMessageSliceCmd, 0, len(ids))
+	for _, id := range ids {
+		cmds = append(cmds, pipe.XRangeN(Rctx, "stream:"+scope, id, id, 1))
+	}
+	if _, err := pipe.Exec(Rctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	found := make(map[string]redis.XMessage, len(ids))
+	for _, cmd := range cmds {
+		if msgs, err := cmd.Result(); err == nil && len(msgs) > 0 {
+			found[msgs[0].ID] = msgs[0]
+		}
+	}
+	return found, nil
+}
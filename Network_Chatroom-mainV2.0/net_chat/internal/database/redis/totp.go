@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// MarkTOTPStepUsed 记录某个时间窗口的验证码已被使用，同一个验证码只能登录一次
+// 返回false表示该验证码已经用过了
+func MarkTOTPStepUsed(username string, step int64, ttl time.Duration) (bool, error) {
+	key := fmt.Sprintf("totp:used:%s:%d", username, step)
+	ok, err := Rdb.SetNX(Rctx, key, "1", ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("记录两步验证码使用情况失败:%w", err)
+	}
+	return ok, nil
+}
+
+// PendingTOTP 已经生成、等待用户用验证码确认的两步验证密钥，确认前不影响正在使用的设置
+type PendingTOTP struct {
+	Secret   string   `json:"secret"`
+	Recovery []string `json:"recovery"` //恢复码的哈希
+}
+
+func pendingTOTPKey(username string) string {
+	return "totp:pending:" + username
+}
+
+// SetPendingTOTP 保存待确认的密钥，过期后需要重新生成
+func SetPendingTOTP(username string, p PendingTOTP, ttl time.Duration) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := Rdb.Set(Rctx, pendingTOTPKey(username), data, ttl).Err(); err != nil {
+		return fmt.Errorf("保存两步验证密钥失败:%w", err)
+	}
+	return nil
+}
+
+// GetPendingTOTP 读取待确认的密钥，没有或已过期时返回nil
+func GetPendingTOTP(username string) (*PendingTOTP, error) {
+	data, err := Rdb.Get(Rctx, pendingTOTPKey(username)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p PendingTOTP
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// DeletePendingTOTP 确认后删除待确认的密钥
+func DeletePendingTOTP(username string) error {
+	return Rdb.Del(Rctx, pendingTOTPKey(username)).Err()
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"fmt"
+)
+
+// 建表语句，服务启动时执行，表已存在则跳过
+var tableStatements = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		username VARCHAR(64) NOT NULL UNIQUE,
+		password_hash VARCHAR(255) NOT NULL
+	) DEFAULT CHARSET=utf8mb4`,
+	//账号安全相关操作的审计记录
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		actor VARCHAR(64) NOT NULL,
+		action VARCHAR(64) NOT NULL,
+		target VARCHAR(64) NOT NULL,
+		detail VARCHAR(255) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_target (target)
+	) DEFAULT CHARSET=utf8mb4`,
+	//邀请码，只邀请注册模式下注册时需要消耗一次
+	`CREATE TABLE IF NOT EXISTS invite_codes (
+		code VARCHAR(32) PRIMARY KEY,
+		created_by VARCHAR(64) NOT NULL,
+		max_uses INT NOT NULL DEFAULT 1,
+		uses INT NOT NULL DEFAULT 0,
+		expires_at DATETIME NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	) DEFAULT CHARSET=utf8mb4`,
+	//客户端证书（SAN或CN）和用户的对应关系，机器人和内部服务凭证书直接登录
+	`CREATE TABLE IF NOT EXISTS user_certificates (
+		subject VARCHAR(255) PRIMARY KEY,
+		username VARCHAR(64) NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_username (username)
+	) DEFAULT CHARSET=utf8mb4`,
+}
+
+// 老版本建的表缺少的列，启动时补上
+var columnStatements = []struct {
+	table, column, definition string
+}{
+	//挑战-应答登录的校验值，老用户在下一次明文登录成功后补上
+	{"users", "scram_verifier", "VARCHAR(255) NULL"},
+	//管理员重置密码后，用户必须先修改密码
+	{"users", "must_change_password", "TINYINT(1) NOT NULL DEFAULT 0"},
+	//两步验证：密钥、是否已开启、恢复码的哈希（json数组）
+	{"users", "totp_secret", "VARCHAR(64) NULL"},
+	{"users", "totp_enabled", "TINYINT(1) NOT NULL DEFAULT 0"},
+	{"users", "totp_recovery", "TEXT NULL"},
+	//普通用户还能生成的邀请码数量，由管理员分配
+	{"users", "invite_quota", "INT NOT NULL DEFAULT 0"},
+}
+
+// InitTables 创建/升级服务端需要的表
+func InitTables() error {
+	for _, stmt := range tableStatements {
+		if _, err := DB.Exec(stmt); err != nil {
+			return fmt.Errorf("建表失败:%w", err)
+		}
+	}
+	for _, col := range columnStatements {
+		if err := ensureColumn(col.table, col.column, col.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 列不存在时添加（MySQL不支持ADD COLUMN IF NOT EXISTS）
+func ensureColumn(table, column, definition string) error {
+	var count int
+	query := "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?"
+	if err := DB.QueryRow(query, table, column).Scan(&count); err != nil {
+		return fmt.Errorf("查询表%s的列%s失败:%w", table, column, err)
+	}
+	if count > 0 {
+		return nil
+	}
+	if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("为表%s添加列%s失败:%w", table, column, err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//两步验证的数据只保存在MySQL中，不放进redis的用户缓存
+
+// TOTPInfo 用户的两步验证设置
+type TOTPInfo struct {
+	Secret   string
+	Enabled  bool
+	Recovery []string //恢复码的sha256哈希
+}
+
+// HashRecoveryCode 恢复码只保存哈希值
+func HashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetTOTP 查询用户的两步验证设置，未设置时Secret为空
+func GetTOTP(username string) (*TOTPInfo, error) {
+	var secret, recovery sql.NullString
+	var info TOTPInfo
+	query := "SELECT totp_secret, totp_enabled, totp_recovery FROM users WHERE username = ?"
+	if err := DB.QueryRow(query, username).Scan(&secret, &info.Enabled, &recovery); err != nil {
+		return nil, fmt.Errorf("查询用户'%s'的两步验证设置失败:%w", username, err)
+	}
+	info.Secret = secret.String
+	if recovery.Valid && recovery.String != "" {
+		if err := json.Unmarshal([]byte(recovery.String), &info.Recovery); err != nil {
+			return nil, fmt.Errorf("解析恢复码失败:%w", err)
+		}
+	}
+	return &info, nil
+}
+
+// EnableTOTP 用户确认验证码后开启两步验证，替换原来的密钥和恢复码（恢复码为哈希值）
+func EnableTOTP(username, secret string, recoveryHashes []string) error {
+	recovery, _ := json.Marshal(recoveryHashes)
+	query := "UPDATE users SET totp_secret = ?, totp_enabled = 1, totp_recovery = ? WHERE username = ?"
+	if _, err := DB.Exec(query, secret, string(recovery), username); err != nil {
+		return fmt.Errorf("开启两步验证失败:%w", err)
+	}
+	return nil
+}
+
+// UseRecoveryCode 校验并消耗一个恢复码，每个恢复码只能使用一次
+func UseRecoveryCode(username, code string) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var recovery sql.NullString
+	//加行锁，防止同一个恢复码被并发使用两次
+	query := "SELECT totp_recovery FROM users WHERE username = ? FOR UPDATE"
+	if err := tx.QueryRow(query, username).Scan(&recovery); err != nil {
+		return false, fmt.Errorf("查询恢复码失败:%w", err)
+	}
+	var hashes []string
+	if recovery.Valid && recovery.String != "" {
+		if err := json.Unmarshal([]byte(recovery.String), &hashes); err != nil {
+			return false, fmt.Errorf("解析恢复码失败:%w", err)
+		}
+	}
+
+	target := HashRecoveryCode(code)
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(target)) != 1 {
+			continue
+		}
+		hashes = append(hashes[:i], hashes[i+1:]...)
+		remaining, _ := json.Marshal(hashes)
+		if _, err := tx.Exec("UPDATE users SET totp_recovery = ? WHERE username = ?", string(remaining), username); err != nil {
+			return false, fmt.Errorf("更新恢复码失败:%w", err)
+		}
+		return true, tx.Commit()
+	}
+	return false, nil
+}
@@ -1,10 +1,13 @@
 package database
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"golang.org/x/crypto/bcrypt"
+	"log"
 	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
 	"time"
 )
 
@@ -12,6 +15,9 @@ type User struct {
 	ID       int
 	Username string
 	Password string
+	Scram    string //挑战-应答登录的校验值，为空表示还没迁移
+	//使用管理员重置的一次性密码登录后必须先修改密码
+	MustChange bool
 }
 
 func GetUserFromRedis(username string) (*User, error) {
@@ -33,50 +39,83 @@ func GetUserFromRedis(username string) (*User, error) {
 	return &user, nil
 }
 
+// UserExists 检查用户是否已注册
+func UserExists(username string) (bool, error) {
+	_, err := GetUserFromRedis(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // 从数据库中获取数据
 func GetUserFromDB(username string) (*User, error) {
 	var user User
 	user.Username = username // 设置用户名
-	query := "SELECT id, username, password_hash FROM users WHERE username = ?"
-	err := DB.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Password)
+	query := "SELECT id, username, password_hash, COALESCE(scram_verifier, ''), must_change_password FROM users WHERE username = ?"
+	err := DB.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Password, &user.Scram, &user.MustChange)
 	if err != nil {
 		return nil, fmt.Errorf("用户'%s'不存在或查询失败:%w", username, err)
 	}
 
 	// 缓存到redis
-	userKey := fmt.Sprintf("user:%s", username)
-	userData, _ := json.Marshal(user)
-	redis.Rdb.Set(redis.Rctx, userKey, userData, time.Hour*24)
+	cacheUser(&user)
 
 	return &user, nil
 }
-func RegisterUser(username, password string) error {
+
+// 缓存用户信息到redis
+func cacheUser(user *User) {
+	userKey := fmt.Sprintf("user:%s", user.Username)
+	//转为json字符序列存入redis
+	userData, _ := json.Marshal(user)
+	redis.Rdb.Set(redis.Rctx, userKey, userData, time.Hour*24)
+}
+
+// RegisterUser 注册用户，inviteCode不为空时在同一个事务中校验并消耗邀请码
+func RegisterUser(username, password, inviteCode string) error {
 	//1.密码哈希
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := HashPassword(password)
 	if err != nil {
 		return fmt.Errorf("哈希密码失败,%w", err)
 	}
+	//挑战-应答登录的校验值
+	verifier, err := protocol.NewScramVerifier(password)
+	if err != nil {
+		return fmt.Errorf("生成校验值失败,%w", err)
+	}
 
-	//2.插入数据库
-	query := "INSERT INTO users(username,password_hash) VALUES(?,?)"
-	result, err := DB.Exec(query, username, string(hashedPassword))
+	//2.插入数据库，邀请码和用户要么一起生效，要么都不生效
+	tx, err := DB.Begin()
+	if err != nil {
+		return fmt.Errorf("开启事务失败,%w", err)
+	}
+	defer tx.Rollback()
+	if inviteCode != "" {
+		if err := consumeInvite(tx, inviteCode); err != nil {
+			return err
+		}
+	}
+	query := "INSERT INTO users(username,password_hash,scram_verifier) VALUES(?,?,?)"
+	result, err := tx.Exec(query, username, hashedPassword, verifier)
 	if err != nil {
 		return fmt.Errorf("注册失败，可能是用户名已经存在了,%w", err)
 	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("注册失败,%w", err)
+	}
 
 	// 获取插入的用户ID并缓存用户信息
 	userID, _ := result.LastInsertId()
 	user := User{
 		ID:       int(userID),
 		Username: username,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
+		Scram:    verifier,
 	}
 
 	// 缓存到 Redis
-	userKey := fmt.Sprintf("user:%s", username)
-	//转为json字符序列存入redis
-	userData, _ := json.Marshal(user)
-	redis.Rdb.Set(redis.Rctx, userKey, userData, time.Hour*24)
+	cacheUser(&user)
 
 	return nil
 }
@@ -89,14 +128,89 @@ func AuthenticateUser(username, password string) error {
 	}
 
 	// 比较密码
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	needsRehash, err := VerifyPassword(user.Password, password)
 	if err != nil {
 		return fmt.Errorf("密码不匹配,%w", err)
 	}
 
+	//旧的bcrypt哈希或参数偏弱的argon2id哈希，趁这次拿到明文密码按当前参数重新哈希
+	if needsRehash {
+		if err := rehashPassword(user, password); err != nil {
+			log.Printf("为用户%s重新哈希密码失败:%v", username, err)
+		}
+	}
+
+	//老用户还没有挑战-应答的校验值，趁这次拿到明文密码补上，下次登录就不用再发送密码了
+	if user.Scram == "" {
+		if err := setScramVerifier(user, password); err != nil {
+			log.Printf("为用户%s生成挑战-应答校验值失败:%v", username, err)
+		}
+	}
+
 	// 缓存到 Redis
-	userKey := fmt.Sprintf("user:%s", username)
-	userData, _ := json.Marshal(user)
-	redis.Rdb.Set(redis.Rctx, userKey, userData, time.Hour*24)
+	cacheUser(user)
+	return nil
+}
+
+// 用当前的算法和参数重新哈希密码，下面会用更新后的user刷新redis缓存
+func rehashPassword(user *User, password string) error {
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if _, err := DB.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hashedPassword, user.ID); err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	return nil
+}
+
+// 生成并保存用户的挑战-应答校验值
+func setScramVerifier(user *User, password string) error {
+	verifier, err := protocol.NewScramVerifier(password)
+	if err != nil {
+		return err
+	}
+	if _, err := DB.Exec("UPDATE users SET scram_verifier = ? WHERE id = ?", verifier, user.ID); err != nil {
+		return err
+	}
+	user.Scram = verifier
+	return nil
+}
+
+// GetScramVerifier 获取用户的挑战-应答校验值，老用户还没有迁移时返回空字符串
+func GetScramVerifier(username string) (string, error) {
+	user, err := GetUserFromRedis(username)
+	if err != nil {
+		return "", err
+	}
+	return user.Scram, nil
+}
+
+// ChangePassword 修改密码，同时更新挑战-应答校验值并清除redis中的用户缓存
+// mustChange为true时（管理员重置），用户下次登录后必须先修改密码
+func ChangePassword(username, password string, mustChange bool) error {
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("哈希密码失败,%w", err)
+	}
+	verifier, err := protocol.NewScramVerifier(password)
+	if err != nil {
+		return fmt.Errorf("生成校验值失败,%w", err)
+	}
+
+	query := "UPDATE users SET password_hash = ?, scram_verifier = ?, must_change_password = ? WHERE username = ?"
+	result, err := DB.Exec(query, hashedPassword, verifier, mustChange, username)
+	if err != nil {
+		return fmt.Errorf("修改密码失败:%w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("用户'%s'不存在", username)
+	}
+
+	//缓存里还是旧的哈希，直接删掉，下次查询时从数据库重新加载
+	if err := redis.Rdb.Del(redis.Rctx, fmt.Sprintf("user:%s", username)).Err(); err != nil {
+		return fmt.Errorf("清除用户缓存失败:%w", err)
+	}
 	return nil
 }
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"math/bits"
+	"strconv"
+)
+
+//注册前的工作量证明（类似hashcash）：
+//找到一个计数器counter，使 sha256(nonce + ":" + counter) 的前difficulty位都是0
+//客户端平均需要计算 2^difficulty 次哈希，服务端只需计算一次即可验证
+
+// PowChallenge 服务端下发的工作量证明挑战
+type PowChallenge struct {
+	Nonce      string `json:"nonce"`
+	Difficulty int    `json:"difficulty"` //要求的前导0位数
+}
+
+// 计算哈希的前导0位数
+func powLeadingZeros(nonce string, counter uint64) int {
+	sum := sha256.Sum256([]byte(nonce + ":" + strconv.FormatUint(counter, 10)))
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			return zeros + bits.LeadingZeros8(b)
+		}
+		zeros += 8
+	}
+	return zeros
+}
+
+// CheckPow 验证计数器是否满足挑战
+func CheckPow(ch *PowChallenge, counter uint64) bool {
+	return powLeadingZeros(ch.Nonce, counter) >= ch.Difficulty
+}
+
+// SolvePow 暴力搜索满足挑战的计数器
+func SolvePow(ch *PowChallenge) uint64 {
+	var counter uint64
+	for !CheckPow(ch, counter) {
+		counter++
+	}
+	return counter
+}
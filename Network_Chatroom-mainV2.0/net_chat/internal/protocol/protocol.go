@@ -11,10 +11,14 @@ import (
 
 // Message 1.定义消息结构体（Message）
 type Message struct {
-	Type    string      `json:"type"`    //消息类型：login（登录），message（聊天），list（查询用户列表），exit（退出）等等
-	Content interface{} `json:"content"` //消息内容
-	From    string      `json:"from"`    //谁发的消息
-	To      string      `json:"to"`      //发给谁（私聊时使用，其他时候为空）
+	Type    string      `json:"type"`         //消息类型：login（登录），message（聊天），list（查询用户列表），exit（退出）等等
+	Content interface{} `json:"content"`      //消息内容
+	From    string      `json:"from"`         //谁发的消息
+	To      string      `json:"to"`           //发给谁（私聊时使用，其他时候为空）
+	ID      string      `json:"id,omitempty"` //消息在Redis stream中的ID，实时消息与历史记录中的是同一个
+	Ts      int64       `json:"ts,omitempty"` //服务端保存消息的时间（unix秒）
+	//chat中引用的上一条消息，发送时只需填ID，服务端转发时补上被回复的发送者和摘要
+	ReplyTo *ReplyContext `json:"reply_to,omitempty"`
 }
 
 // SendMsg 2.定义统一的发送消息的方法
@@ -72,4 +76,197 @@ func ReadMsg(r *bufio.Reader) (*Message, error) {
 	return &msg, nil
 }
 
-//
+// LoginResult 登录或恢复会话成功时返回的内容
+type LoginResult struct {
+	Username  string `json:"username"`   //登录的用户名（访客登录时由服务端分配）
+	Welcome   string `json:"welcome"`    //欢迎信息
+	Token     string `json:"token"`      //会话令牌，断线后用resume消息携带它重新连接
+	ExpiresAt int64  `json:"expires_at"` //令牌过期时间（unix秒）
+	//挑战-应答登录时的服务端签名，客户端用它确认服务端确实持有该用户的校验值
+	ServerSignature string `json:"server_signature,omitempty"`
+}
+
+// TOTPEnrollment 开启两步验证时返回给用户的信息
+type TOTPEnrollment struct {
+	URI           string   `json:"uri"`            //otpauth地址，可生成二维码供验证器扫描
+	Secret        string   `json:"secret"`         //手动输入验证器时使用
+	RecoveryCodes []string `json:"recovery_codes"` //手机丢失时代替验证码登录，每个只能用一次
+}
+
+// DecodeContent 将Content解析到指定的结构体中
+// 经过json反序列化后Content会变成map[string]interface{}，这里重新编码一次再解析
+func DecodeContent(content interface{}, v interface{}) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ChatMessage 一条已保存的聊天消息
+type ChatMessage struct {
+	ID           string        `json:"id"` //Redis stream ID
+	Sender       string        `json:"sender"`
+	Content      string        `json:"content"`
+	Ts           int64         `json:"ts"`                     //发送时间（unix秒）
+	Room         string        `json:"room,omitempty"`         //聊天室消息所在的聊天室
+	Conversation string        `json:"conversation,omitempty"` //私聊消息所在的会话，格式为按字典序排列的"用户A:用户B"
+	Edited       int64         `json:"edited,omitempty"`       //最后修改时间（unix秒），没有修改过为0
+	Deleted      bool          `json:"deleted,omitempty"`      //已撤回，Content为空
+	ReplyTo      *ReplyContext `json:"reply_to,omitempty"`     //回复的消息
+	Reactions    []Reaction    `json:"reactions,omitempty"`    //表情回应，按人数从多到少排列
+}
+
+// Reaction 一种表情的回应情况
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Count int64    `json:"count"`
+	Users []string `json:"users"` //回应了该表情的用户
+}
+
+// ReplyContext 被回复的消息
+type ReplyContext struct {
+	ID      string `json:"id"`
+	Sender  string `json:"sender,omitempty"`
+	Snippet string `json:"snippet,omitempty"` //被回复消息的开头部分
+	Deleted bool   `json:"deleted,omitempty"` //被回复的消息已撤回或已删除
+}
+
+// OfflineMessages 登录时投递的、某个用户在离线期间发来的私聊
+type OfflineMessages struct {
+	From     string        `json:"from"`
+	Messages []ChatMessage `json:"messages"`
+}
+
+// RoomUnread 某个聊天室的未读情况
+type RoomUnread struct {
+	Room     string `json:"room"`
+	Unread   int64  `json:"unread"`    //他人发送的未读消息数，最多统计到999
+	LastRead string `json:"last_read"` //已读到的消息ID，从未阅读过时为空
+}
+
+// HistoryQuery 历史消息查询，放在room_messages、privatebegin、private_messages的Content中，都为空时返回最新一页
+type HistoryQuery struct {
+	Limit  int64  `json:"limit,omitempty"`  //每页条数，默认20，最多100
+	Before string `json:"before,omitempty"` //返回该消息ID之前的消息（向前翻页）
+	After  string `json:"after,omitempty"`  //返回该消息ID之后的消息（向后翻页）
+	Time   int64  `json:"time,omitempty"`   //跳转到该时间点（unix秒）之后的消息
+}
+
+// HistoryPage 一页历史消息
+type HistoryPage struct {
+	Room     string        `json:"room,omitempty"` //聊天室历史
+	Peer     string        `json:"peer,omitempty"` //与该用户的私聊历史
+	Messages []ChatMessage `json:"messages"`       //按时间顺序排列的消息
+	Before   string        `json:"before"`         //本页第一条消息ID，作为before继续向前翻页
+	After    string        `json:"after"`          //本页最后一条消息ID，作为after继续向后翻页
+	HasMore  bool          `json:"has_more"`       //翻页方向上是否还有消息
+}
+
+// SearchQuery 搜索聊天记录，关键词和发送者至少填一个
+type SearchQuery struct {
+	Keyword string `json:"keyword,omitempty"` //多个词用空格分开，消息需包含全部的词
+	Sender  string `json:"sender,omitempty"`
+	From    int64  `json:"from,omitempty"`  //开始时间（unix秒）
+	To      int64  `json:"to,omitempty"`    //结束时间（unix秒）
+	Limit   int    `json:"limit,omitempty"` //默认20，最多50
+}
+
+// SearchResult 搜索结果，按时间倒序
+type SearchResult struct {
+	Messages []ChatMessage `json:"messages"`
+	HasMore  bool          `json:"has_more"`
+}
+
+// ExportRequest 导出聊天记录，Room和Peer填一个
+type ExportRequest struct {
+	Room     string `json:"room,omitempty"` //导出该聊天室的记录
+	Peer     string `json:"peer,omitempty"` //导出与该用户的私聊记录
+	Format   string `json:"format"`         //json、csv、md或html
+	From     int64  `json:"from,omitempty"` //开始时间（unix秒），为空时从最早的消息开始
+	To       int64  `json:"to,omitempty"`   //结束时间（unix秒），为空时到最新的消息
+	TzOffset int    `json:"tz_offset"`      //客户端时区相对UTC的秒数，导出的时间按该时区显示
+}
+
+// ExportChunk 导出内容的一段，按Seq顺序拼接即为完整文件
+type ExportChunk struct {
+	ID       string `json:"id"`
+	Seq      int    `json:"seq"`
+	Filename string `json:"filename,omitempty"` //第一段带上建议的文件名
+	Data     string `json:"data"`
+	Done     bool   `json:"done"`            //最后一段
+	Count    int    `json:"count,omitempty"` //最后一段带上导出的消息数
+}
+
+// EditRequest 修改或撤回一条消息，放在edit_message、delete_message的Content中
+// Room和Peer都为空时为默认聊天室
+type EditRequest struct {
+	ID      string `json:"id"`
+	Room    string `json:"room,omitempty"`
+	Peer    string `json:"peer,omitempty"`    //与该用户的私聊中的消息
+	Content string `json:"content,omitempty"` //修改后的内容，撤回时不需要
+}
+
+// MessageUpdate 消息被修改（message_edited）或撤回（message_deleted）的通知
+type MessageUpdate struct {
+	ID           string `json:"id"`
+	Room         string `json:"room,omitempty"`
+	Conversation string `json:"conversation,omitempty"`
+	Sender       string `json:"sender"`            //消息的发送者
+	Content      string `json:"content,omitempty"` //修改后的内容
+	Deleted      bool   `json:"deleted,omitempty"`
+	EditedBy     string `json:"edited_by"` //操作者，管理员处理他人消息时与发送者不同
+	EditedAt     int64  `json:"edited_at"`
+}
+
+// ThreadQuery 查询某条消息的所有回复，Room和Peer都为空时为默认聊天室
+type ThreadQuery struct {
+	ID   string `json:"id"`
+	Room string `json:"room,omitempty"`
+	Peer string `json:"peer,omitempty"`
+}
+
+// ThreadPage 一条消息和它的回复
+type ThreadPage struct {
+	Parent  ChatMessage   `json:"parent"`
+	Replies []ChatMessage `json:"replies"`  //按时间顺序排列
+	HasMore bool          `json:"has_more"` //回复太多，只返回了最早的部分
+}
+
+// ReactionRequest 对消息添加（react）或取消（unreact）表情，Room和Peer都为空时为默认聊天室
+type ReactionRequest struct {
+	ID    string `json:"id"`
+	Room  string `json:"room,omitempty"`
+	Peer  string `json:"peer,omitempty"`
+	Emoji string `json:"emoji"`
+}
+
+// ReactionUpdate 消息的表情回应变化（reaction_update）
+type ReactionUpdate struct {
+	ID           string     `json:"id"`
+	Room         string     `json:"room,omitempty"`
+	Conversation string     `json:"conversation,omitempty"`
+	User         string     `json:"user"` //本次操作的用户
+	Emoji        string     `json:"emoji"`
+	Added        bool       `json:"added"`     //添加还是取消
+	Reactions    []Reaction `json:"reactions"` //变化后该消息的全部表情回应
+}
+
+// Mention 提到当前用户的一条消息（mention），Message.Room为消息所在的聊天室，Message.ID即消息链接
+type Mention struct {
+	Message ChatMessage `json:"message"`
+	Unseen  bool        `json:"unseen,omitempty"` //还没有看过
+}
+
+// MentionQuery 查询提到自己的消息，Before为空时从最新的开始
+type MentionQuery struct {
+	Before string `json:"before,omitempty"` //只返回比该消息ID更早的，用于翻页
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// MentionList 提到自己的消息（mentions），按时间倒序
+type MentionList struct {
+	Mentions []Mention `json:"mentions"`
+	Unseen   int64     `json:"unseen"` //查询前未看过的数量
+	HasMore  bool      `json:"has_more"`
+}
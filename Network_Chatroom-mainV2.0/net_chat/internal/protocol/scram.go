@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//挑战-应答登录（参考SCRAM-SHA-256，RFC 5802/7677）
+//服务端只保存StoredKey和ServerKey，客户端只发送证明，密码本身不会出现在网络上
+//流程：
+//1. 客户端 login_start  {username, client_nonce}
+//2. 服务端 login_challenge {salt, iterations, nonce}（nonce = client_nonce + 服务端随机数）
+//   用户不存在或还没有校验值（老的bcrypt用户）时返回假的挑战，无法从回复中区分
+//3. 客户端 login_proof {proof}
+//   老用户需要在客户端明确选择用明文登录一次，成功后服务端补上校验值；已有校验值的账号不接受明文登录
+//4. 服务端校验通过后回复 login_success，并在结果中带上服务端签名，客户端据此确认服务端也持有校验值
+
+// ScramIterations 生成校验值时PBKDF2的迭代次数
+const ScramIterations = 4096
+
+// scram校验值的前缀，格式与PostgreSQL一致：SCRAM-SHA-256$<iter>:<salt>$<StoredKey>:<ServerKey>
+const scramPrefix = "SCRAM-SHA-256"
+
+// ScramStart 客户端发起挑战-应答登录
+type ScramStart struct {
+	Username    string `json:"username"`
+	ClientNonce string `json:"client_nonce"`
+}
+
+// ScramChallenge 服务端下发的挑战
+type ScramChallenge struct {
+	Salt       string `json:"salt"` //base64
+	Iterations int    `json:"iterations"`
+	Nonce      string `json:"nonce"` //客户端随机数+服务端随机数
+}
+
+// ScramProof 客户端对挑战的应答
+type ScramProof struct {
+	Proof string `json:"proof"` //base64
+}
+
+// ScramVerifier 服务端保存的校验值
+type ScramVerifier struct {
+	Iterations int
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewNonce 生成随机数
+func NewNonce() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// 由密码推导ClientKey和ServerKey
+func scramKeys(password string, salt []byte, iterations int) (clientKey, serverKey []byte, err error) {
+	salted, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hmacSHA256(salted, "Client Key"), hmacSHA256(salted, "Server Key"), nil
+}
+
+// NewScramVerifier 根据明文密码生成新的校验值（注册、改密码、老用户迁移时调用）
+func NewScramVerifier(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	clientKey, serverKey, err := scramKeys(password, salt, ScramIterations)
+	if err != nil {
+		return "", err
+	}
+	storedKey := sha256.Sum256(clientKey)
+	enc := base64.StdEncoding
+	return fmt.Sprintf("%s$%d:%s$%s:%s", scramPrefix, ScramIterations, enc.EncodeToString(salt),
+		enc.EncodeToString(storedKey[:]), enc.EncodeToString(serverKey)), nil
+}
+
+// ParseScramVerifier 解析数据库中保存的校验值
+func ParseScramVerifier(s string) (*ScramVerifier, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 3 || parts[0] != scramPrefix {
+		return nil, fmt.Errorf("无效的校验值格式")
+	}
+	iterSalt := strings.SplitN(parts[1], ":", 2)
+	keys := strings.SplitN(parts[2], ":", 2)
+	if len(iterSalt) != 2 || len(keys) != 2 {
+		return nil, fmt.Errorf("无效的校验值格式")
+	}
+	iterations, err := strconv.Atoi(iterSalt[0])
+	if err != nil {
+		return nil, fmt.Errorf("无效的迭代次数:%w", err)
+	}
+	enc := base64.StdEncoding
+	v := &ScramVerifier{Iterations: iterations}
+	if v.Salt, err = enc.DecodeString(iterSalt[1]); err != nil {
+		return nil, fmt.Errorf("无效的盐值:%w", err)
+	}
+	if v.StoredKey, err = enc.DecodeString(keys[0]); err != nil {
+		return nil, fmt.Errorf("无效的StoredKey:%w", err)
+	}
+	if v.ServerKey, err = enc.DecodeString(keys[1]); err != nil {
+		return nil, fmt.Errorf("无效的ServerKey:%w", err)
+	}
+	return v, nil
+}
+
+// ScramAuthMessage 双方都要签名的认证消息，把本次登录的所有参数绑定在一起
+func ScramAuthMessage(username, clientNonce string, ch *ScramChallenge) string {
+	return fmt.Sprintf("n=%s,r=%s,r=%s,s=%s,i=%d", username, clientNonce, ch.Nonce, ch.Salt, ch.Iterations)
+}
+
+// ScramClientProof 客户端计算证明，同时返回期望的服务端签名
+func ScramClientProof(username, password, clientNonce string, ch *ScramChallenge) (proof string, serverSig string, err error) {
+	salt, err := base64.StdEncoding.DecodeString(ch.Salt)
+	if err != nil {
+		return "", "", fmt.Errorf("无效的盐值:%w", err)
+	}
+	clientKey, serverKey, err := scramKeys(password, salt, ch.Iterations)
+	if err != nil {
+		return "", "", err
+	}
+	storedKey := sha256.Sum256(clientKey)
+	authMsg := ScramAuthMessage(username, clientNonce, ch)
+	clientSig := hmacSHA256(storedKey[:], authMsg)
+	p := make([]byte, len(clientKey))
+	for i := range clientKey {
+		p[i] = clientKey[i] ^ clientSig[i]
+	}
+	enc := base64.StdEncoding
+	return enc.EncodeToString(p), enc.EncodeToString(hmacSHA256(serverKey, authMsg)), nil
+}
+
+// Verify 服务端校验客户端证明，通过时返回服务端签名
+func (v *ScramVerifier) Verify(authMsg, proof string) (string, bool) {
+	p, err := base64.StdEncoding.DecodeString(proof)
+	if err != nil || len(p) != sha256.Size {
+		return "", false
+	}
+	//ClientKey = proof XOR HMAC(StoredKey, AuthMessage)，再比较其哈希是否等于StoredKey
+	clientSig := hmacSHA256(v.StoredKey, authMsg)
+	clientKey := make([]byte, len(p))
+	for i := range p {
+		clientKey[i] = p[i] ^ clientSig[i]
+	}
+	sum := sha256.Sum256(clientKey)
+	if subtle.ConstantTimeCompare(sum[:], v.StoredKey) != 1 {
+		return "", false
+	}
+	return base64.StdEncoding.EncodeToString(hmacSHA256(v.ServerKey, authMsg)), true
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestScramProof(t *testing.T) {
+	stored, err := NewScramVerifier("pencil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ParseScramVerifier(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := &ScramChallenge{
+		Salt:       base64.StdEncoding.EncodeToString(v.Salt),
+		Iterations: v.Iterations,
+		Nonce:      "client-nonce" + "server-nonce",
+	}
+	authMsg := ScramAuthMessage("user", "client-nonce", ch)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		nonce    string
+		ok       bool
+	}{
+		{"correct password", "user", "pencil", "client-nonce", true},
+		{"wrong password", "user", "pen", "client-nonce", false},
+		{"other username", "other", "pencil", "client-nonce", false},
+		{"other client nonce", "user", "pencil", "client-nonce2", false},
+	}
+	for _, tt := range tests {
+		proof, serverSig, err := ScramClientProof(tt.username, tt.password, tt.nonce, ch)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		sig, ok := v.Verify(authMsg, proof)
+		if ok != tt.ok {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && sig != serverSig {
+			t.Errorf("%s: server signature mismatch", tt.name)
+		}
+	}
+}
+
+func TestParseScramVerifier(t *testing.T) {
+	valid, err := NewScramVerifier("pencil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{valid, true},
+		{"", false},
+		{"SCRAM-SHA-1$4096:c2FsdA==$YQ==:Yg==", false},
+		{"SCRAM-SHA-256$4096:c2FsdA==$YQ==", false},
+		{"SCRAM-SHA-256$many:c2FsdA==$YQ==:Yg==", false},
+		{"SCRAM-SHA-256$4096:!!$YQ==:Yg==", false},
+		{"SCRAM-SHA-256$4096:c2FsdA==$YQ==:Yg==", true},
+	}
+	for _, tt := range tests {
+		v, err := ParseScramVerifier(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseScramVerifier(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+		}
+		if err == nil && v.Iterations != ScramIterations {
+			t.Errorf("ParseScramVerifier(%q) iterations = %d", tt.in, v.Iterations)
+		}
+	}
+}
+
+func TestVerifyRejectsMalformedProof(t *testing.T) {
+	stored, _ := NewScramVerifier("pencil")
+	v, _ := ParseScramVerifier(stored)
+	for _, proof := range []string{"", "not base64", "c2hvcnQ="} {
+		if _, ok := v.Verify("n=user", proof); ok {
+			t.Errorf("Verify accepted %q", proof)
+		}
+	}
+}
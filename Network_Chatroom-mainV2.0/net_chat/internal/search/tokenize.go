@@ -0,0 +1,114 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+//全文搜索的分词
+//拉丁字母和数字按单词切分并转为小写（至少2个字符）；
+//中日韩文字没有空格分隔，建索引时同时记录单字和相邻两字，查询时有两个字以上就只用相邻两字，减少误中
+
+// 拉丁单词的最短长度，太短的词几乎每条消息都有
+const minWordLen = 2
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// 把文本切分为拉丁单词和中日韩文字串
+func split(text string) (words []string, cjkRuns [][]rune) {
+	var word, run []rune
+	flushWord := func() {
+		if len(word) >= minWordLen {
+			words = append(words, strings.ToLower(string(word)))
+		}
+		word = nil
+	}
+	flushRun := func() {
+		if len(run) > 0 {
+			cjkRuns = append(cjkRuns, run)
+		}
+		run = nil
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			run = append(run, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushRun()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushRun()
+		}
+	}
+	flushWord()
+	flushRun()
+	return words, cjkRuns
+}
+
+// IndexTokens 返回建索引时使用的词，已去重
+func IndexTokens(text string) []string {
+	words, runs := split(text)
+	tokens := newTokenSet()
+	for _, w := range words {
+		tokens.add(w)
+	}
+	for _, run := range runs {
+		for i := range run {
+			tokens.add(string(run[i]))
+			if i+1 < len(run) {
+				tokens.add(string(run[i : i+2]))
+			}
+		}
+	}
+	return tokens.list
+}
+
+// QueryTokens 返回查询时使用的词，已去重
+func QueryTokens(keyword string) []string {
+	words, runs := split(keyword)
+	tokens := newTokenSet()
+	for _, w := range words {
+		tokens.add(w)
+	}
+	for _, run := range runs {
+		if len(run) == 1 {
+			tokens.add(string(run))
+			continue
+		}
+		for i := 0; i+1 < len(run); i++ {
+			tokens.add(string(run[i : i+2]))
+		}
+	}
+	return tokens.list
+}
+
+// Match 检查内容是否包含关键词中的每一个词（不区分大小写），用来排除索引的误中
+func Match(content, keyword string) bool {
+	content = strings.ToLower(content)
+	for _, term := range strings.Fields(strings.ToLower(keyword)) {
+		if !strings.Contains(content, term) {
+			return false
+		}
+	}
+	return true
+}
+
+type tokenSet struct {
+	seen map[string]bool
+	list []string
+}
+
+func newTokenSet() *tokenSet {
+	return &tokenSet{seen: make(map[string]bool)}
+}
+
+func (t *tokenSet) add(token string) {
+	if !t.seen[token] {
+		t.seen[token] = true
+		t.list = append(t.list, token)
+	}
+}
@@ -0,0 +1,81 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndexTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"a b c", nil},
+		{"Hello, World! hello", []string{"hello", "world"}},
+		{"go1.24 ok", []string{"go1", "24", "ok"}},
+		{"你好", []string{"你", "你好", "好"}},
+		{"今天天气", []string{"今", "今天", "天", "天天", "天气", "气"}},
+		{"用Go写", []string{"go", "用", "写"}},
+		{"こんにちは", []string{"こ", "こん", "ん", "んに", "に", "にち", "ち", "ちは", "は"}},
+	}
+	for _, tt := range tests {
+		if got := IndexTokens(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("IndexTokens(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestQueryTokens(t *testing.T) {
+	tests := []struct {
+		keyword string
+		want    []string
+	}{
+		{"x", nil},
+		{"Redis STREAM", []string{"redis", "stream"}},
+		{"你", []string{"你"}},
+		{"你好", []string{"你好"}},
+		{"天气预报", []string{"天气", "气预", "预报"}},
+		{"go 语言 go", []string{"go", "语言"}},
+	}
+	for _, tt := range tests {
+		if got := QueryTokens(tt.keyword); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("QueryTokens(%q) = %q, want %q", tt.keyword, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		content, keyword string
+		want             bool
+	}{
+		{"Hello World", "hello", true},
+		{"Hello World", "WORLD hello", true},
+		{"Hello World", "hello there", false},
+		{"今天天气很好", "天气", true},
+		{"今天天气很好", "天晴", false},
+		{"anything", "", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.content, tt.keyword); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.content, tt.keyword, got, tt.want)
+		}
+	}
+}
+
+// 查询用的词都应该出现在内容的索引中，否则索引查不到这条消息
+func TestQueryTokensAreIndexed(t *testing.T) {
+	content := "明天下午三点开会 meeting at 3pm"
+	indexed := make(map[string]bool)
+	for _, token := range IndexTokens(content) {
+		indexed[token] = true
+	}
+	for _, keyword := range []string{"明天", "下午三点", "会", "Meeting", "3pm"} {
+		for _, token := range QueryTokens(keyword) {
+			if !indexed[token] {
+				t.Errorf("query %q token %q not indexed", keyword, token)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"log"
+	"net_chat/internal/database"
+)
+
+// Authenticator 身份验证后端
+// 默认使用 redis缓存→MySQL→bcrypt 的账号体系，也可以换成htpasswd文件或机器人静态令牌
+type Authenticator interface {
+	// Authenticate 校验用户名和密码（静态令牌后端中密码即令牌），失败时返回错误
+	Authenticate(username, password string) error
+	// UserExists 用户是否存在，@提及等需要确认用户名时使用
+	UserExists(username string) (bool, error)
+}
+
+// AccountStore 带完整账号体系的后端
+// 注册、挑战-应答登录、修改/重置密码都依赖它，其他后端不支持这些功能
+type AccountStore interface {
+	Authenticator
+	// Register 注册新用户，inviteCode不为空时校验并消耗邀请码
+	Register(username, password, inviteCode string) error
+	// ScramVerifier 返回挑战-应答登录的校验值，还没迁移的老用户返回空字符串
+	ScramVerifier(username string) (string, error)
+	// ChangePassword 修改密码，mustChange表示用户下次登录后必须先修改密码
+	ChangePassword(username, password string, mustChange bool) error
+	// MustChangePassword 用户是否正在使用一次性密码
+	MustChangePassword(username string) bool
+}
+
+// TOTPStore 保存两步验证设置的后端，目前只有MySQL账号体系支持
+type TOTPStore interface {
+	// GetTOTP 查询用户的两步验证设置
+	GetTOTP(username string) (*database.TOTPInfo, error)
+	// EnableTOTP 开启两步验证，替换原来的密钥和恢复码哈希
+	EnableTOTP(username, secret string, recoveryHashes []string) error
+	// UseRecoveryCode 校验并消耗一个恢复码
+	UseRecoveryCode(username, code string) (bool, error)
+}
+
+// AuditLog 保存审计日志的后端，目前只有MySQL账号体系支持
+type AuditLog interface {
+	// AddAudit 记录一条管理操作
+	AddAudit(actor, action, target string) error
+}
+
+// DatabaseAuthenticator 默认后端，账号保存在MySQL中并缓存到redis
+type DatabaseAuthenticator struct{}
+
+func (DatabaseAuthenticator) Authenticate(username, password string) error {
+	return database.AuthenticateUser(username, password)
+}
+
+func (DatabaseAuthenticator) UserExists(username string) (bool, error) {
+	return database.UserExists(username)
+}
+
+func (DatabaseAuthenticator) Register(username, password, inviteCode string) error {
+	return database.RegisterUser(username, password, inviteCode)
+}
+
+func (DatabaseAuthenticator) ScramVerifier(username string) (string, error) {
+	return database.GetScramVerifier(username)
+}
+
+func (DatabaseAuthenticator) ChangePassword(username, password string, mustChange bool) error {
+	return database.ChangePassword(username, password, mustChange)
+}
+
+func (DatabaseAuthenticator) MustChangePassword(username string) bool {
+	user, err := database.GetUserFromRedis(username)
+	return err == nil && user.MustChange
+}
+
+func (DatabaseAuthenticator) GetTOTP(username string) (*database.TOTPInfo, error) {
+	return database.GetTOTP(username)
+}
+
+func (DatabaseAuthenticator) EnableTOTP(username, secret string, recoveryHashes []string) error {
+	return database.EnableTOTP(username, secret, recoveryHashes)
+}
+
+func (DatabaseAuthenticator) UseRecoveryCode(username, code string) (bool, error) {
+	return database.UseRecoveryCode(username, code)
+}
+
+func (DatabaseAuthenticator) AddAudit(actor, action, target string) error {
+	return database.AddAudit(actor, action, target, "")
+}
+
+// SetAuthenticator 设置身份验证后端，需要在Start之前调用
+func (s *Server) SetAuthenticator(auth Authenticator) {
+	s.auth = auth
+}
+
+// 当前后端支持完整账号体系时返回它
+func (s *Server) accounts() (AccountStore, bool) {
+	store, ok := s.auth.(AccountStore)
+	return store, ok
+}
+
+// 当前后端支持两步验证时返回它
+func (s *Server) totpStore() (TOTPStore, bool) {
+	store, ok := s.auth.(TOTPStore)
+	return store, ok
+}
+
+// 记录审计日志，不支持审计日志的后端只写到服务端日志里
+func (s *Server) audit(actor, action, target string) {
+	store, ok := s.auth.(AuditLog)
+	if !ok {
+		log.Printf("[审计] %s %s %s", actor, action, target)
+		return
+	}
+	if err := store.AddAudit(actor, action, target); err != nil {
+		log.Println(err)
+	}
+}
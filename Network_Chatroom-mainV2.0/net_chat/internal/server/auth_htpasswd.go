@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net_chat/internal/database"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HtpasswdAuthenticator 从htpasswd文件中读取账号，适合不部署MySQL的小型场景
+// 支持bcrypt哈希（htpasswd -B 生成的 $2y$ 格式）和argon2id哈希，文件修改后自动重新加载
+type HtpasswdAuthenticator struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	users   map[string]string //用户名->密码哈希
+}
+
+// NewHtpasswdAuthenticator 构造函数，会立即加载一次文件
+func NewHtpasswdAuthenticator(path string) (*HtpasswdAuthenticator, error) {
+	a := &HtpasswdAuthenticator{path: path}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *HtpasswdAuthenticator) Authenticate(username, password string) error {
+	a.mu.Lock()
+	if err := a.reload(); err != nil {
+		//文件暂时读不到时继续使用上一次加载的内容
+		log.Printf("重新加载htpasswd文件失败:%v", err)
+	}
+	hash, ok := a.users[username]
+	a.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("用户'%s'不存在", username)
+	}
+	//文件由管理员维护，这里不做重新哈希
+	if _, err := database.VerifyPassword(hash, password); err != nil {
+		return fmt.Errorf("密码不匹配,%w", err)
+	}
+	return nil
+}
+
+func (a *HtpasswdAuthenticator) UserExists(username string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.reload(); err != nil {
+		log.Printf("重新加载htpasswd文件失败:%v", err)
+	}
+	_, ok := a.users[username]
+	return ok, nil
+}
+
+// 文件修改时间变化时重新加载
+func (a *HtpasswdAuthenticator) reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return fmt.Errorf("读取htpasswd文件失败:%w", err)
+	}
+	if a.users != nil && info.ModTime().Equal(a.modTime) {
+		return nil
+	}
+
+	f, err := os.Open(a.path)
+	if err != nil {
+		return fmt.Errorf("打开htpasswd文件失败:%w", err)
+	}
+	defer f.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return fmt.Errorf("htpasswd文件第%d行格式错误", lineNo)
+		}
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "$argon2id$") {
+			return fmt.Errorf("htpasswd文件第%d行不是bcrypt或argon2id哈希，请使用 htpasswd -B 生成", lineNo)
+		}
+		users[name] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取htpasswd文件失败:%w", err)
+	}
+	a.users = users
+	a.modTime = info.ModTime()
+	return nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+// TokenAuthenticator 机器人使用的静态令牌后端，登录时以令牌代替密码
+type TokenAuthenticator struct {
+	tokens map[string][sha256.Size]byte //用户名->令牌的哈希
+}
+
+// NewTokenAuthenticator 构造函数，spec格式为 名字:令牌,名字:令牌
+func NewTokenAuthenticator(spec string) (*TokenAuthenticator, error) {
+	a := &TokenAuthenticator{tokens: make(map[string][sha256.Size]byte)}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(item, ":")
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("机器人令牌配置格式错误:%s", name)
+		}
+		a.tokens[name] = sha256.Sum256([]byte(token))
+	}
+	if len(a.tokens) == 0 {
+		return nil, fmt.Errorf("没有配置任何机器人令牌")
+	}
+	return a, nil
+}
+
+func (a *TokenAuthenticator) Authenticate(username, password string) error {
+	expected, ok := a.tokens[username]
+	//比较哈希值，避免令牌长度不同时提前返回
+	given := sha256.Sum256([]byte(password))
+	if !ok || subtle.ConstantTimeCompare(expected[:], given[:]) != 1 {
+		return fmt.Errorf("用户名或令牌错误")
+	}
+	return nil
+}
+
+func (a *TokenAuthenticator) UserExists(username string) (bool, error) {
+	_, ok := a.tokens[username]
+	return ok, nil
+}
@@ -4,17 +4,32 @@ package server
 //主要功能:循环从客户端读和写
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"net_chat/internal/protocol"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ClientConn struct {
 	Conn     net.Conn               //维护的连接
 	Name     string                 //用户的姓名
+	Token    string                 //本次登录签发的会话令牌
+	Guest    bool                   //是否为访客
 	Outgoing chan *protocol.Message //只用于服务器发给客户端的消息队列
 	quit     chan struct{}          //用于通知对应协程退出
+	scram    *scramState            //进行中的挑战-应答登录
+	//密码已验证、等待两步验证码的登录
+	pendingLogin *pendingLogin
+	pow          *powState //注册前的工作量证明
+	//使用一次性密码登录后，修改密码之前只能修改密码或登出
+	mustChange bool
+	closeOnce  sync.Once   //管理员踢人和readLoop都可能关闭连接，保证只关闭一次
+	onClose    func()      //连接关闭时调用，释放连接数名额
+	exporting  atomic.Bool //正在导出聊天记录
 }
 
 // NewClientConn 构造函数，每次有新用户都直接使用构造函数来创建新连接
@@ -34,6 +49,14 @@ func (c *ClientConn) Start(s *Server) {
 
 // 从客户端读取消息并交由server.Dispatch处理
 func (c *ClientConn) readLoop(s *Server) {
+	//TLS连接先完成握手，提供了已登记证书的客户端直接登录
+	if tc, ok := c.Conn.(*tls.Conn); ok {
+		if err := s.tlsHandshake(c, tc); err != nil {
+			fmt.Println("TLS握手失败:", err)
+			_ = c.Close()
+			return
+		}
+	}
 	r := bufio.NewReader(c.Conn)
 
 	for {
@@ -82,6 +105,13 @@ func (c *ClientConn) writeLoop() {
 				fmt.Println("无法从消息队列获取消息")
 				return
 			}
+			//之前的消息都已写出，关闭连接
+			if msg == closeMarker {
+				if err := c.Close(); err != nil {
+					log.Printf("关闭与%s的连接失败:%v", c.Name, err)
+				}
+				return
+			}
 			// 将消息编码并写入底层 conn（可能阻塞直到写完或出错）
 			if err := protocol.SendMsg(c.Conn, msg); err != nil {
 				fmt.Println("发送消息失败:", err)
@@ -94,8 +124,42 @@ func (c *ClientConn) writeLoop() {
 	}
 }
 
+// 从后台协程向客户端发送消息，连接已关闭时直接丢弃，避免协程一直阻塞
+func (c *ClientConn) send(msg *protocol.Message) {
+	select {
+	case c.Outgoing <- msg:
+	case <-c.quit:
+	}
+}
+
+// 写出之后关闭连接的标记，只在closeAfterFlush中使用
+var closeMarker = &protocol.Message{}
+
+// 客户端迟迟不读取时，closeAfterFlush最多等待的时间
+const flushTimeout = 5 * time.Second
+
+// 把队列中已有的消息和msg写出后再关闭连接，超时后直接关闭，不阻塞调用者
+func (c *ClientConn) closeAfterFlush(msg *protocol.Message) {
+	timer := time.AfterFunc(flushTimeout, func() {
+		_ = c.Close()
+	})
+	go func() {
+		c.send(msg)
+		c.send(closeMarker)
+		<-c.quit
+		timer.Stop()
+	}()
+}
+
 // Close 关闭连接，结束协程
 func (c *ClientConn) Close() error {
-	close(c.quit) // 通知 writeLoop 退出
-	return c.Conn.Close()
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.quit) // 通知 writeLoop 退出
+		err = c.Conn.Close()
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return err
 }
@@ -6,6 +6,9 @@ import (
 	"net_chat/internal/database/redis"
 	"net_chat/internal/server"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -16,13 +19,43 @@ func main() {
 		addr = ":8080" //等同于"0.0.0.0:8080"
 	}
 
-	//2.初始化数据库
-	if err := database.InitMySQL(); err != nil {
-		log.Fatalf("初始化数据库失败:%v", err)
-	} else {
-		log.Printf("初始化MySQL数据库连接成功！")
+	//2.选择身份验证后端：mysql（默认）、htpasswd、token（机器人静态令牌）
+	backend := os.Getenv("CHAT_AUTH_BACKEND")
+	var auth server.Authenticator
+	switch backend {
+	case "", "mysql":
+		//初始化数据库
+		if err := database.InitMySQL(); err != nil {
+			log.Fatalf("初始化数据库失败:%v", err)
+		} else {
+			log.Printf("初始化MySQL数据库连接成功！")
+		}
+		defer database.CloseDB()
+		if err := database.InitTables(); err != nil {
+			log.Fatalf("初始化数据表失败:%v", err)
+		}
+		auth = server.DatabaseAuthenticator{}
+	case "htpasswd":
+		a, err := server.NewHtpasswdAuthenticator(os.Getenv("CHAT_HTPASSWD_FILE"))
+		if err != nil {
+			log.Fatalf("初始化htpasswd后端失败:%v", err)
+		}
+		auth = a
+	case "token":
+		//格式为 名字:令牌,名字:令牌
+		a, err := server.NewTokenAuthenticator(os.Getenv("CHAT_BOT_TOKENS"))
+		if err != nil {
+			log.Fatalf("初始化机器人令牌后端失败:%v", err)
+		}
+		auth = a
+	default:
+		log.Fatalf("未知的身份验证后端:%s", backend)
+	}
+	log.Printf("使用身份验证后端:%T", auth)
+	//密码哈希参数
+	if err := database.InitPasswordHashing(); err != nil {
+		log.Fatalf("读取密码哈希参数失败:%v", err)
 	}
-	defer database.CloseDB()
 
 	//3.初始化redis端
 	if err := redis.InitRedis("localhost:6379", "", 0); err != nil {
@@ -33,6 +66,95 @@ func main() {
 
 	// 4. 创建服务器实例
 	s := server.NewServer(addr)
+	s.SetAuthenticator(auth)
+	//管理员名单，多个用户名用逗号隔开
+	if admins := os.Getenv("CHAT_ADMINS"); admins != "" {
+		s.SetAdmins(strings.Split(admins, ","))
+	}
+	//版主名单，版主和管理员可以随时修改、撤回聊天室中的消息
+	s.SetModerators(splitEnv("CHAT_MODERATORS"))
+	//发送者修改、撤回自己消息的时限，默认2分钟，0s表示不允许
+	if os.Getenv("CHAT_EDIT_WINDOW") != "" {
+		s.SetEditWindow(durationEnv("CHAT_EDIT_WINDOW"))
+	}
+	//只邀请注册模式
+	s.SetInviteOnly(os.Getenv("CHAT_INVITE_ONLY") == "true")
+	//注册时工作量证明的基础难度（前导0位数），0表示关闭
+	if v := os.Getenv("CHAT_REGISTER_POW_BITS"); v != "" {
+		bits, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("CHAT_REGISTER_POW_BITS的值无效:%s", v)
+		}
+		s.SetRegisterPow(bits)
+	}
+	//允许访客阅读和发言的聊天室，多个用逗号隔开，默认为main_room
+	if rooms, ok := os.LookupEnv("CHAT_GUEST_ROOMS"); ok {
+		s.SetGuestRooms(strings.Split(rooms, ","))
+	}
+
+	//TLS与客户端证书登录
+	if certFile := os.Getenv("CHAT_TLS_CERT"); certFile != "" {
+		cfg, err := server.LoadTLSConfig(certFile, os.Getenv("CHAT_TLS_KEY"),
+			os.Getenv("CHAT_TLS_CLIENT_CA"), os.Getenv("CHAT_TLS_CLIENT_AUTH"))
+		if err != nil {
+			log.Fatalf("加载TLS配置失败:%v", err)
+		}
+		s.SetTLS(cfg)
+	}
+
+	//IP黑白名单与连接数限制
+	limits := server.ConnLimits{
+		Allow:       splitEnv("CHAT_ALLOW_CIDRS"),
+		Deny:        splitEnv("CHAT_DENY_CIDRS"),
+		MaxConns:    intEnv("CHAT_MAX_CONNS"),
+		MaxPerIP:    intEnv("CHAT_MAX_CONNS_PER_IP"),
+		AcceptRate:  float64(intEnv("CHAT_ACCEPT_RATE")),
+		AcceptBurst: intEnv("CHAT_ACCEPT_BURST"),
+	}
+	if err := s.SetConnLimits(limits); err != nil {
+		log.Fatalf("连接限制配置错误:%v", err)
+	}
+
+	//消息保留策略：条数上限和/或保留时长，0或不设置表示不限制
+	policy := redis.RetentionPolicy{
+		Room:    redis.Retention{MaxLen: int64(intEnv("CHAT_RETENTION_ROOM_MAXLEN")), MaxAge: durationEnv("CHAT_RETENTION_ROOM_MAXAGE")},
+		Private: redis.Retention{MaxLen: int64(intEnv("CHAT_RETENTION_PM_MAXLEN")), MaxAge: durationEnv("CHAT_RETENTION_PM_MAXAGE")},
+		Rooms:   roomRetentionEnv("CHAT_RETENTION_ROOMS"),
+	}
+	if dir := os.Getenv("CHAT_RETENTION_ARCHIVE_DIR"); dir != "" {
+		archive, err := server.NewFileArchiver(dir)
+		if err != nil {
+			log.Fatalf("初始化消息归档失败:%v", err)
+		}
+		policy.Archive = archive
+	}
+	interval := durationEnv("CHAT_RETENTION_INTERVAL")
+	if interval == 0 {
+		interval = 10 * time.Minute
+	}
+	s.SetRetention(policy, interval)
+
+	//把消息归档到MySQL，查询历史时Redis中已删除的消息从MySQL读取
+	if os.Getenv("CHAT_ARCHIVE_MYSQL") == "true" {
+		if backend != "" && backend != "mysql" {
+			if err := database.InitMySQL(); err != nil {
+				log.Fatalf("初始化数据库失败:%v", err)
+			}
+			defer database.CloseDB()
+		}
+		if err := database.InitMessageArchive(time.Now()); err != nil {
+			log.Fatalf("初始化消息归档失败:%v", err)
+		}
+		consumer := os.Getenv("CHAT_ARCHIVE_CONSUMER")
+		if consumer == "" {
+			consumer, _ = os.Hostname()
+		}
+		archiveInterval := durationEnv("CHAT_ARCHIVE_INTERVAL")
+		if archiveInterval == 0 {
+			archiveInterval = 5 * time.Second
+		}
+		s.SetArchive(consumer, archiveInterval)
+	}
 
 	// 5. 启动服务器
 	if err := s.Start(); err != nil {
@@ -40,3 +162,67 @@ func main() {
 	}
 
 }
+
+// 读取逗号分隔的环境变量
+func splitEnv(name string) []string {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// 读取整数环境变量，未设置时为0
+func intEnv(name string) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Fatalf("环境变量%s的值无效:%s", name, v)
+	}
+	return n
+}
+
+// 读取时长环境变量（如72h、30m），未设置时为0
+func durationEnv(name string) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatalf("环境变量%s的值无效:%s", name, v)
+	}
+	return d
+}
+
+// 读取单独设置的聊天室保留策略，格式为 聊天室=条数/时长,聊天室=条数，条数或时长可以省略
+func roomRetentionEnv(name string) map[string]redis.Retention {
+	rooms := make(map[string]redis.Retention)
+	for _, item := range splitEnv(name) {
+		room, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || room == "" {
+			log.Fatalf("环境变量%s的值无效:%s", name, item)
+		}
+		maxLen, maxAge, _ := strings.Cut(value, "/")
+		var r redis.Retention
+		if maxLen != "" {
+			n, err := strconv.ParseInt(maxLen, 10, 64)
+			if err != nil || n < 0 {
+				log.Fatalf("环境变量%s中%s的条数无效:%s", name, room, maxLen)
+			}
+			r.MaxLen = n
+		}
+		if maxAge != "" {
+			d, err := time.ParseDuration(maxAge)
+			if err != nil || d < 0 {
+				log.Fatalf("环境变量%s中%s的时长无效:%s", name, room, maxAge)
+			}
+			r.MaxAge = d
+		}
+		rooms[room] = r
+	}
+	return rooms
+}
@@ -1,8 +1,11 @@
 package server
 
 import (
+	"crypto/rand"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,16 +15,58 @@ type Server struct {
 	mu    sync.RWMutex           //保护用户列表map的锁
 	users map[string]*ClientConn //用户列表
 	now   time.Time
+	//生成不存在用户的假盐值时使用的密钥
+	scramSecret []byte
+	admins      map[string]bool  //管理员用户名
+	clock       func() time.Time //当前时间，消息时间戳、两步验证等使用，测试时可以换成固定时钟
+	auth        Authenticator    //身份验证后端
+	guestRooms  map[string]bool  //允许访客阅读和发言的聊天室
+	inviteOnly  bool             //只邀请注册模式，注册时必须提供邀请码
+	powBits     int              //注册时工作量证明的基础难度，0表示关闭
+	tlsConfig   *tls.Config      //不为nil时使用TLS监听
+	limiter     *connLimiter     //IP黑白名单和连接数限制
+	//定期按保留策略清理消息的间隔，0表示只在写入时清理
+	retentionInterval time.Duration
+	//MySQL归档的消费者名和间隔，间隔为0表示不归档
+	archiveConsumer string
+	archiveInterval time.Duration
+	moderators      map[string]bool //可以随时修改、撤回聊天室消息的用户，管理员也有该权限
+	editWindow      time.Duration   //发送者可以修改、撤回自己消息的时限，0表示不允许
 }
 
 // NewServer 构造函数
 func NewServer(addr string) *Server {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
 	return &Server{
-		Addr:  addr,                         //监听地址
-		users: make(map[string]*ClientConn), //用户列表map
+		Addr:        addr,                         //监听地址
+		users:       make(map[string]*ClientConn), //用户列表map
+		scramSecret: secret,
+		admins:      make(map[string]bool),
+		clock:       time.Now,
+		auth:        DatabaseAuthenticator{},
+		guestRooms:  map[string]bool{mainRoom: true},
+		powBits:     defaultPowBits,
+		limiter:     &connLimiter{perIP: make(map[string]int)},
+		moderators:  make(map[string]bool),
+		editWindow:  defaultEditWindow,
 	}
 }
 
+// SetAdmins 设置管理员名单，需要在Start之前调用
+func (s *Server) SetAdmins(names []string) {
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			s.admins[name] = true
+		}
+	}
+}
+
+// 判断用户是否为管理员
+func (s *Server) isAdmin(name string) bool {
+	return name != "" && s.admins[name]
+}
+
 // Start Start启动监听并接收连接
 func (s *Server) Start() error {
 	//监听端口
@@ -31,7 +76,16 @@ func (s *Server) Start() error {
 		fmt.Printf("服务端监听%s失败", s.Addr)
 		return err
 	}
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
 	fmt.Println("服务端正在监听端口", s.Addr)
+	if s.retentionInterval > 0 {
+		go s.retentionJanitor()
+	}
+	if s.archiveEnabled() {
+		go s.archiver()
+	}
 
 	//不断接收连接
 	for {
@@ -41,8 +95,15 @@ func (s *Server) Start() error {
 			//一个链接失败不影响链接其他的客户
 			continue
 		}
+		//黑白名单、连接数和接入速率检查
+		release, reason := s.limiter.admit(conn.RemoteAddr())
+		if reason != "" {
+			rejectConn(conn, reason)
+			continue
+		}
 		//为新的连接建立ClientConn并启动循环读写协程
 		cc := NewClientConn(conn)
+		cc.onClose = release
 		cc.Start(s)
 	}
 }
@@ -47,6 +47,10 @@ func (s *Server) Handleactivitytotal(c *ClientConn) {
 
 // OnUserLogin 当用户登录时调用,活跃度+1,更新活跃度排行榜
 func OnUserLogin(username string) {
+	//访客不参与排行
+	if isGuest(username) {
+		return
+	}
 	var login string
 	err := redis.TryIncrementWithCooldown(username, login, 1, time.Now())
 	if err != nil {
@@ -56,6 +60,9 @@ func OnUserLogin(username string) {
 
 // OnUserPost 当用户发帖时调用,活跃度+2,更新活跃度排行榜
 func OnUserPost(username string) {
+	if isGuest(username) {
+		return
+	}
 	var login string
 	err := redis.TryIncrementWithCooldown(username, login, 2, time.Now())
 	if err != nil {
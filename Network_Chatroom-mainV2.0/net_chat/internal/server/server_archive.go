@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"log"
+	"net_chat/internal/database"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"strings"
+	"time"
+)
+
+//把Redis中的消息归档到MySQL，查询历史时Redis中已经删除的更早消息从MySQL补齐
+
+// SetArchive 开启MySQL归档，consumer为本实例在消费组中的名字，多个实例需要各不相同
+// 需要在Start之前、MySQL初始化之后调用
+func (s *Server) SetArchive(consumer string, interval time.Duration) {
+	s.archiveConsumer = consumer
+	s.archiveInterval = interval
+	redis.EnableArchiveWatermark()
+}
+
+// 是否开启了MySQL归档
+func (s *Server) archiveEnabled() bool {
+	return s.archiveInterval > 0
+}
+
+// 定期把新消息归档到MySQL，并在月份变化后建立新的分区
+func (s *Server) archiver() {
+	ticker := time.NewTicker(s.archiveInterval)
+	defer ticker.Stop()
+	lastMonth := s.clock().Month()
+	for range ticker.C {
+		if now := s.clock(); now.Month() != lastMonth {
+			if err := database.EnsureMessagePartitions(now, 2); err != nil {
+				log.Printf("建立消息分区失败:%v", err)
+			} else {
+				lastMonth = now.Month()
+			}
+		}
+		n, err := redis.ConsumeArchive(s.archiveConsumer, archiveToMySQL)
+		if err != nil {
+			log.Printf("归档消息失败:%v", err)
+		}
+		if n > 0 {
+			log.Printf("归档了%d条消息", n)
+		}
+	}
+}
+
+// 写入一批消息到MySQL
+func archiveToMySQL(scope string, msgs []goredis.XMessage) error {
+	archived := make([]database.ArchivedMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		cm := chatMessageFromStream(msg)
+		archived = append(archived, database.ArchivedMessage{
+			Scope:   scope,
+			ID:      cm.ID,
+			Sender:  cm.Sender,
+			Content: cm.Content,
+			Ts:      cm.Ts,
+			ReplyTo: replyID(cm.ReplyTo),
+		})
+	}
+	return database.ArchiveMessages(archived)
+}
+
+// 归档消息转换为聊天消息
+func chatMessageFromArchive(m database.ArchivedMessage) protocol.ChatMessage {
+	cm := protocol.ChatMessage{ID: m.ID, Sender: m.Sender, Content: m.Content, Ts: m.Ts}
+	if m.ReplyTo != "" {
+		cm.ReplyTo = &protocol.ReplyContext{ID: m.ReplyTo}
+	}
+	if room, ok := strings.CutPrefix(m.Scope, "room:"); ok {
+		cm.Room = room
+	} else {
+		cm.Conversation = strings.TrimPrefix(m.Scope, "pm:")
+	}
+	return cm
+}
+
+// 用MySQL归档补齐Redis中已经删除的更早消息，msgs和hasMore为从Redis查询的结果
+func (s *Server) fillFromArchive(scope string, r redis.HistoryRange, msgs []protocol.ChatMessage, hasMore bool) ([]protocol.ChatMessage, bool, error) {
+	if !s.archiveEnabled() {
+		return msgs, hasMore, nil
+	}
+	oldest, err := redis.OldestID(scope)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if r.After != "" || r.Since > 0 {
+		//向后翻页：起点在Redis保存的范围内时不需要归档
+		start := r.After
+		if start == "" {
+			start = fmt.Sprintf("%d-0", r.Since*1000)
+		}
+		if oldest != "" && redis.CompareID(start, oldest) >= 0 {
+			return msgs, hasMore, nil
+		}
+		archived, more, err := database.GetArchivedMessages(scope, database.ArchiveRange{
+			After:  r.After,
+			Since:  r.Since * 1000,
+			Before: oldest,
+			Limit:  r.Limit,
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		page := make([]protocol.ChatMessage, 0, r.Limit)
+		for _, m := range archived {
+			page = append(page, chatMessageFromArchive(m))
+		}
+		if more {
+			return page, true, nil
+		}
+		//归档的不够一页，后面接上Redis中的
+		if remain := int(r.Limit) - len(page); len(msgs) > remain {
+			msgs, hasMore = msgs[:remain], true
+		}
+		return append(page, msgs...), hasMore, nil
+	}
+
+	//向前翻页：Redis中已经没有更早的消息时才查询归档
+	if hasMore || int64(len(msgs)) >= r.Limit {
+		return msgs, hasMore, nil
+	}
+	before := r.Before
+	if len(msgs) > 0 {
+		before = msgs[0].ID
+	} else if oldest != "" && (before == "" || redis.CompareID(oldest, before) < 0) {
+		before = oldest
+	}
+	archived, more, err := database.GetArchivedMessages(scope, database.ArchiveRange{
+		Before: before,
+		Limit:  r.Limit - int64(len(msgs)),
+		Latest: true,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	page := make([]protocol.ChatMessage, 0, len(archived)+len(msgs))
+	for _, m := range archived {
+		page = append(page, chatMessageFromArchive(m))
+	}
+	return append(page, msgs...), more, nil
+}
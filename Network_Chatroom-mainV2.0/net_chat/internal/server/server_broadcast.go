@@ -24,3 +24,19 @@ func (s *Server) Broadcast(msg *protocol.Message) {
 
 	}
 }
+
+// BroadcastRoom 只发给能访问该聊天室的在线用户，聊天室的消息、修改和表情回应都用它发送
+func (s *Server) BroadcastRoom(room string, msg *protocol.Message) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, c := range s.users {
+		if !s.canAccessRoom(c, room) {
+			continue
+		}
+		select {
+		case c.Outgoing <- msg:
+		default:
+			fmt.Printf("无法发送消息给 %s\n", c.Name)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net_chat/internal/protocol"
+	"testing"
+)
+
+func TestRoomTrafficSkipsGuestsWithoutAccess(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	s.SetGuestRooms([]string{"lobby"})
+	alice := newTestConn(t, "alice")
+	bob := newTestConn(t, "bob")
+	guest := newTestConn(t, guestPrefix+"0001")
+	guest.Guest = true
+	for _, c := range []*ClientConn{alice, bob, guest} {
+		s.AddUser(c.Name, c)
+	}
+
+	s.HandleChat(&protocol.Message{Type: "chat", Content: "hi"}, alice)
+	chat := expectMsg(t, bob, "chat")
+	s.HandleReaction(&protocol.Message{Type: "react", Content: protocol.ReactionRequest{ID: chat.ID, Emoji: "👍"}}, bob, true)
+	expectMsg(t, alice, "reaction_update")
+	s.HandleEditMessage(&protocol.Message{Type: "edit_message", Content: protocol.EditRequest{ID: chat.ID, Content: "hello"}}, alice, false)
+	expectMsg(t, bob, "message_edited")
+
+	if msgs := drainMsgs(guest); len(msgs) != 0 {
+		t.Fatalf("guest without access to %s received %d messages, first %q", mainRoom, len(msgs), msgs[0].Type)
+	}
+
+	//开放给访客的聊天室照常收到
+	s.BroadcastRoom("lobby", &protocol.Message{Type: "chat", Content: "welcome"})
+	expectMsg(t, guest, "chat")
+}
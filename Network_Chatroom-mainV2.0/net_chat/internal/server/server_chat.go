@@ -7,61 +7,138 @@ import (
 	"net_chat/internal/protocol"
 )
 
+// 默认聊天室
+const mainRoom = "main_room"
+
 func (s *Server) HandleChat(msg *protocol.Message, c *ClientConn) {
 	if c.Name != "" {
+		//发送者以服务端记录的为准，防止冒充他人
+		msg.From = c.Name
 		if msg.To != "" {
-			//私聊
+			//访客不能私聊，也不能被私聊
+			if c.Guest || isGuest(msg.To) {
+				c.Outgoing <- &protocol.Message{
+					Type:    "error",
+					Content: "访客无法使用私聊",
+					From:    "system",
+				}
+				return
+			}
+			//私聊：先保存再发送，投递位置以stream ID为准
+			content, _ := msg.Content.(string)
+			reply, ok := s.replyFor(msg, c, redis.PrivateScope(msg.From, msg.To))
+			if !ok {
+				return
+			}
 			targetUser := s.GetUser(msg.To)
+			ts := s.clock().Unix()
+			id, err := redis.AddPrivateMessage(msg.From, msg.To, content, replyID(reply), ts, targetUser != nil)
+			if err != nil {
+				log.Printf("在存储用户私聊消息时发生错误%s:", err)
+				if id == "" && targetUser == nil {
+					c.Outgoing <- &protocol.Message{
+						Type:    "error",
+						Content: fmt.Sprintf("发送失败，%s不在线且消息保存失败", msg.To),
+						From:    "system",
+					}
+					return
+				}
+			}
 			if targetUser != nil {
 				targetUser.Outgoing <- &protocol.Message{
 					Type:    "private_chat",
-					Content: msg.Content,
+					Content: content,
 					From:    msg.From,
 					To:      msg.To,
+					ID:      id,
+					Ts:      ts,
+					ReplyTo: reply,
 				}
+				//投递位置等对方客户端回复pm_delivered后再前移，没有确认的消息下次登录时重发
 
 				//发送回执给自己
 				c.Outgoing <- &protocol.Message{
 					Type:    "private_chat_sent",
 					Content: "发送成功",
 					From:    "system",
+					To:      msg.To,
+					ID:      id,
+					Ts:      ts,
 				}
-				_, err := redis.AddPrivateMessage(msg.From, msg.To, msg.Content.(string), true)
-				if err != nil {
-					log.Printf("在存储用户私聊消息时发生错误%s:", err)
-				}
-				//fmt.Println("存储私聊消息成功")
 			} else {
-				_, err := redis.AddPrivateMessage(msg.From, msg.To, msg.Content.(string), false)
-				if err != nil {
-					log.Printf("在存储用户私聊消息时发生错误%s:", err)
-				}
-				//fmt.Println("存储私聊消息成果")
-				//目标不存在
+				//对方不在线，消息会在其下次登录时投递
 				c.Outgoing <- &protocol.Message{
-					Type:    "error",
-					Content: fmt.Sprintf("发送失败，%s不在线", msg.To),
+					Type:    "private_chat_sent",
+					Content: fmt.Sprintf("%s不在线，消息将在其上线后送达", msg.To),
 					From:    "system",
+					To:      msg.To,
+					ID:      id,
+					Ts:      ts,
 				}
 			}
 
 		} else {
-			//群聊消息
-			s.Broadcast(&protocol.Message{
-				Type:    "chat",
-				Content: msg.Content,
-				From:    msg.From,
-			})
-			//存储聊天室消息
-			_, err := redis.AddRoomMessage("main_room", msg.From, msg.Content.(string))
+			if !s.canAccessRoom(c, mainRoom) {
+				c.Outgoing <- &protocol.Message{
+					Type:    "error",
+					Content: "访客无法在该聊天室发言",
+					From:    "system",
+				}
+				return
+			}
+			//先保存再广播，广播的消息带上stream ID和服务端时间，与历史记录一致
+			content, _ := msg.Content.(string)
+			reply, ok := s.replyFor(msg, c, redis.RoomScope(mainRoom))
+			if !ok {
+				return
+			}
+			ts := s.clock().Unix()
+			id, err := redis.AddRoomMessage(mainRoom, msg.From, content, replyID(reply), ts)
 			if err != nil {
+				log.Printf("在存储聊天室消息时发生错误:%v", err)
+			}
+			if id == "" {
+				c.Outgoing <- &protocol.Message{
+					Type:    "error",
+					Content: "发送失败，请稍后重试",
+					From:    "system",
+				}
 				return
 			}
+			s.BroadcastRoom(mainRoom, &protocol.Message{
+				Type:    "chat",
+				Content: content,
+				From:    msg.From,
+				ID:      id,
+				Ts:      ts,
+				ReplyTo: reply,
+			})
+			s.notifyMentions(mainRoom, protocol.ChatMessage{
+				ID:      id,
+				Sender:  msg.From,
+				Content: content,
+				Ts:      ts,
+				Room:    mainRoom,
+				ReplyTo: reply,
+			})
 			OnUserPost(msg.From)
 		}
 	}
 }
 
+// 消息是回复时检查被回复的消息，失败时通知发送者并返回false
+func (s *Server) replyFor(msg *protocol.Message, c *ClientConn, scope string) (*protocol.ReplyContext, bool) {
+	if msg.ReplyTo == nil || msg.ReplyTo.ID == "" {
+		return nil, true
+	}
+	reply, err := s.checkReplyTo(scope, msg.ReplyTo.ID)
+	if err != nil {
+		sendError(c, err.Error())
+		return nil, false
+	}
+	return reply, true
+}
+
 // GetUser 返回指定用户对应的连接
 func (s *Server) GetUser(name string) *ClientConn {
 	//加锁保证查询用户无误
@@ -5,16 +5,91 @@ import (
 	"net_chat/internal/protocol"
 )
 
+// 必须修改密码时仍然允许的消息
+var mustChangeAllowed = map[string]bool{
+	"change_password": true,
+	"logout":          true,
+	"pm_delivered":    true,
+	"pm_read":         true,
+}
+
+// 登录之前允许的消息，其余的都要先登录
+var loginNotRequired = map[string]bool{
+	"register":           true,
+	"register_challenge": true,
+	"register_pow":       true,
+	"login":              true,
+	"login_start":        true,
+	"login_proof":        true,
+	"guest_login":        true,
+	"login_totp":         true,
+	"resume":             true,
+	"logout":             true,
+}
+
 func (s *Server) Dispatch(msg *protocol.Message, c *ClientConn) {
+	//还没登录的连接只能注册、登录
+	if c.Name == "" && !loginNotRequired[msg.Type] {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "请先登录",
+			From:    "system",
+		}
+		return
+	}
+	//使用一次性密码登录后只允许修改密码或登出（客户端自动发送的回执除外）
+	if c.mustChange && !mustChangeAllowed[msg.Type] {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "请先修改密码",
+			From:    "system",
+		}
+		return
+	}
+	//访客只能使用部分功能
+	if c.Guest && !guestAllowed[msg.Type] {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "访客无法使用该功能，请注册后登录",
+			From:    "system",
+		}
+		return
+	}
 	switch msg.Type {
 	//处理注册请求
 	case "register":
 		s.HandleRegister(msg, c)
+	//注册前的工作量证明
+	case "register_challenge":
+		s.HandleRegisterChallenge(c)
+	case "register_pow":
+		s.HandleRegisterPow(msg, c)
 	//处理登录请求
 	case "login":
 		s.HandleLogin(msg, c)
+	//挑战-应答登录，密码不经过网络
+	case "login_start":
+		s.HandleLoginStart(msg, c)
+	case "login_proof":
+		s.HandleLoginProof(msg, c)
+	//访客登录
+	case "guest_login":
+		s.HandleGuestLogin(c)
+	//两步验证码
+	case "login_totp":
+		s.HandleLoginTOTP(msg, c)
+	//断线重连后凭令牌恢复会话
+	case "resume":
+		s.HandleResume(msg, c)
 	case "privatebegin":
-		s.sendRecentPrivateMessages(c, msg.To)
+		s.sendRecentPrivateMessages(c, msg.To, msg.Content)
+		s.syncPrivateReceipts(c, msg.To)
+	//私聊历史翻页
+	case "private_messages":
+		s.sendRecentPrivateMessages(c, msg.To, msg.Content)
+	//私聊送达/已读回执
+	case "pm_delivered", "pm_read":
+		s.HandleReceipt(msg, c)
 	//发送消息请求
 	case "chat":
 		s.HandleChat(msg, c)
@@ -29,7 +104,55 @@ func (s *Server) Dispatch(msg *protocol.Message, c *ClientConn) {
 	case "activityTotal":
 		s.Handleactivitytotal(c)
 	case "room_messages":
-		s.sendRecentRoomMessages(c)
+		s.sendRecentRoomMessages(c, msg.Content)
+	//聊天室已读位置和未读数
+	case "mark_read":
+		s.HandleMarkRead(msg, c)
+	case "room_unread":
+		s.HandleRoomUnread(c)
+	//搜索聊天记录
+	case "search":
+		s.HandleSearch(msg, c)
+	case "search_reindex":
+		s.HandleSearchReindex(c)
+	//修改、撤回消息
+	case "edit_message":
+		s.HandleEditMessage(msg, c, false)
+	case "delete_message":
+		s.HandleEditMessage(msg, c, true)
+	//某条消息的所有回复
+	case "thread":
+		s.HandleThread(msg, c)
+	//表情回应
+	case "react":
+		s.HandleReaction(msg, c, true)
+	case "unreact":
+		s.HandleReaction(msg, c, false)
+	//提到自己的消息
+	case "mentions":
+		s.HandleMentions(msg, c)
+	//导出聊天记录
+	case "export":
+		s.HandleExport(msg, c)
+	//修改密码
+	case "change_password":
+		s.HandleChangePassword(msg, c)
+	//管理员重置用户密码
+	case "reset_password":
+		s.HandleResetPassword(msg, c)
+	//开启两步验证
+	case "totp_enroll":
+		s.HandleTOTPEnroll(c)
+	case "totp_confirm":
+		s.HandleTOTPConfirm(msg, c)
+	//邀请码
+	case "invite_create":
+		s.HandleCreateInvite(msg, c)
+	case "invite_quota":
+		s.HandleInviteQuota(msg, c)
+	//管理员查看连接统计
+	case "stats":
+		s.HandleStats(c)
 	//用户登出请求
 	case "logout":
 		s.HandleLogout(c)
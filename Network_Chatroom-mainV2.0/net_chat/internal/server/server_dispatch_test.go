@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"testing"
+)
+
+func TestDispatchRequiresLogin(t *testing.T) {
+	s, mr, _ := newTestServer(t)
+	id, err := redis.AddPrivateMessage("alice", "bob", "hi", "", testNow.Unix(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := redis.AddRoomMessage(mainRoom, "alice", "secret plans", "", testNow.Unix()); err != nil {
+		t.Fatal(err)
+	}
+	keys := len(mr.Keys())
+
+	tests := []*protocol.Message{
+		{Type: "privatebegin", To: "alice"},
+		{Type: "private_messages", To: "alice"},
+		{Type: "pm_delivered", To: "alice", ID: id},
+		{Type: "pm_read", To: "alice", ID: id},
+		{Type: "chat", Content: "hello"},
+		{Type: "list"},
+		{Type: "room_messages"},
+		{Type: "mark_read", ID: id},
+		{Type: "room_unread"},
+		{Type: "search", Content: protocol.SearchQuery{Keyword: "plans"}},
+		{Type: "edit_message", ID: id, Content: "edited"},
+		{Type: "delete_message", ID: id},
+		{Type: "thread", ID: id},
+		{Type: "react", ID: id, Content: "+1"},
+		{Type: "unreact", ID: id, Content: "+1"},
+		{Type: "mentions"},
+		{Type: "export"},
+		{Type: "change_password", Content: "new"},
+		{Type: "totp_enroll"},
+		{Type: "invite_create"},
+		{Type: "stats"},
+	}
+	anon := newTestConn(t, "")
+	for _, msg := range tests {
+		s.Dispatch(msg, anon)
+		msgs := drainMsgs(anon)
+		if len(msgs) != 1 || msgs[0].Type != "error" {
+			t.Errorf("%s: unauthenticated connection got %v", msg.Type, msgs)
+		}
+	}
+	if got := mr.Keys(); len(got) != keys {
+		t.Fatalf("state written for anonymous connection: %v", got)
+	}
+
+	bob := newTestConn(t, "bob")
+	s.Dispatch(&protocol.Message{Type: "search", Content: protocol.SearchQuery{Keyword: "plans"}}, bob)
+	var result protocol.SearchResult
+	if err := protocol.DecodeContent(expectMsg(t, bob, "search_result").Content, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Messages) != 1 || result.Messages[0].Content != "secret plans" {
+		t.Fatalf("result = %+v", result)
+	}
+}
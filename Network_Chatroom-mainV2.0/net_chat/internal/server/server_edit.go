@@ -0,0 +1,240 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net_chat/internal/database"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"strings"
+	"time"
+)
+
+// 发送者默认可以在发送后2分钟内修改、撤回消息
+const defaultEditWindow = 2 * time.Minute
+
+// SetModerators 设置版主名单，需要在Start之前调用
+func (s *Server) SetModerators(names []string) {
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			s.moderators[name] = true
+		}
+	}
+}
+
+// SetEditWindow 设置发送者修改、撤回消息的时限，0表示只有版主和管理员可以操作
+func (s *Server) SetEditWindow(d time.Duration) {
+	s.editWindow = d
+}
+
+// 版主和管理员可以随时修改、撤回聊天室中任何人的消息
+func (s *Server) isModerator(name string) bool {
+	return name != "" && (s.moderators[name] || s.isAdmin(name))
+}
+
+// HandleEditMessage 修改（deleted为false）或撤回一条消息，成功后通知能看到该消息的在线用户
+func (s *Server) HandleEditMessage(msg *protocol.Message, c *ClientConn, deleted bool) {
+	var req protocol.EditRequest
+	if err := protocol.DecodeContent(msg.Content, &req); err != nil || strings.TrimSpace(req.ID) == "" {
+		sendError(c, "无效的请求，需要指定消息ID")
+		return
+	}
+	req.ID = strings.TrimSpace(req.ID)
+	if !deleted && strings.TrimSpace(req.Content) == "" {
+		sendError(c, "修改后的内容不能为空，撤回消息请使用撤回功能")
+		return
+	}
+
+	update := protocol.MessageUpdate{ID: req.ID}
+	scope, err := s.resolveScope(c, req.Room, req.Peer)
+	if err != nil {
+		sendError(c, err.Error())
+		return
+	}
+	update.Room, update.Conversation = scopeFields(scope)
+
+	original, err := s.findMessage(scope, req.ID)
+	if err != nil {
+		log.Printf("查找消息%s|%s失败:%v", scope, req.ID, err)
+		sendError(c, "查找消息失败")
+		return
+	}
+	if original == nil {
+		sendError(c, "消息不存在")
+		return
+	}
+
+	//发送者在时限内可以操作自己的消息，版主和管理员可以随时操作聊天室中的消息，私聊不受版主管理
+	own := original.Sender == c.Name
+	inWindow := s.editWindow > 0 && s.clock().Sub(time.Unix(original.Ts, 0)) <= s.editWindow
+	moderator := update.Room != "" && s.isModerator(c.Name)
+	if !(own && inWindow) && !moderator {
+		if own {
+			sendError(c, "已超过可修改、撤回的时间")
+		} else {
+			sendError(c, "只能修改、撤回自己发送的消息")
+		}
+		return
+	}
+
+	edit := redis.MessageEdit{
+		Deleted:  deleted,
+		EditedBy: c.Name,
+		EditedAt: s.clock().Unix(),
+	}
+	if !deleted {
+		edit.Content = req.Content
+	}
+	//已撤回的消息在保存时检查，避免与同时进行的撤回交错
+	err = redis.SaveMessageEdit(scope, req.ID, original.Sender, edit)
+	if errors.Is(err, redis.ErrMessageDeleted) {
+		sendError(c, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("保存消息%s|%s的修改记录失败:%v", scope, req.ID, err)
+		sendError(c, "操作失败，请稍后重试")
+		return
+	}
+	action := "edit_message"
+	msgType := "message_edited"
+	if deleted {
+		action, msgType = "delete_message", "message_deleted"
+	}
+	//处理他人的消息时记录审计日志
+	if !own {
+		s.audit(c.Name, action, scope+"|"+req.ID)
+	}
+
+	update.Sender = original.Sender
+	update.Content = edit.Content
+	update.Deleted = edit.Deleted
+	update.EditedBy = edit.EditedBy
+	update.EditedAt = edit.EditedAt
+	notice := &protocol.Message{
+		Type:    msgType,
+		Content: update,
+		From:    c.Name,
+		ID:      req.ID,
+	}
+	if update.Room != "" {
+		s.BroadcastRoom(update.Room, notice)
+		return
+	}
+	c.Outgoing <- notice
+	if peer := s.GetUser(req.Peer); peer != nil {
+		peer.Outgoing <- notice
+	}
+}
+
+// 向客户端发送一条错误提示
+func sendError(c *ClientConn, reason string) {
+	c.Outgoing <- &protocol.Message{
+		Type:    "error",
+		Content: reason,
+		From:    "system",
+	}
+}
+
+// 请求者要操作的聊天室（room，为空时为默认聊天室）或与peer的私聊，返回其标识
+func (s *Server) resolveScope(c *ClientConn, room, peer string) (string, error) {
+	if peer != "" {
+		if peer == c.Name {
+			return "", fmt.Errorf("无效的私聊对象")
+		}
+		return redis.PrivateScope(c.Name, peer), nil
+	}
+	if room == "" {
+		room = mainRoom
+	}
+	if !s.canAccessRoom(c, room) {
+		return "", fmt.Errorf("无法访问该聊天室")
+	}
+	return redis.RoomScope(room), nil
+}
+
+// 由标识得到聊天室或私聊会话，两者只有一个不为空
+func scopeFields(scope string) (room, conversation string) {
+	if room, ok := strings.CutPrefix(scope, "room:"); ok {
+		return room, ""
+	}
+	return "", strings.TrimPrefix(scope, "pm:")
+}
+
+// 查找一条消息（未应用修改记录），不存在时返回nil
+func (s *Server) findMessage(scope, id string) (*protocol.ChatMessage, error) {
+	if !redis.IsStreamID(id) {
+		return nil, nil
+	}
+	msgs, err := s.loadMessages(scope, []string{id})
+	if err != nil || len(msgs) == 0 {
+		return nil, err
+	}
+	return &msgs[0], nil
+}
+
+// 按ID批量读取消息，已经从Redis中清理掉的再到归档中查找，按ids的顺序返回还存在的消息
+func (s *Server) loadMessages(scope string, ids []string) ([]protocol.ChatMessage, error) {
+	found, err := redis.GetMessages(scope, ids)
+	if err != nil {
+		return nil, err
+	}
+	room, conversation := scopeFields(scope)
+	msgs := make([]protocol.ChatMessage, 0, len(ids))
+	for _, id := range ids {
+		var cm protocol.ChatMessage
+		if msg, ok := found[id]; ok {
+			cm = chatMessageFromStream(msg)
+		} else if s.archiveEnabled() {
+			archived, err := database.GetArchivedMessage(scope, id)
+			if err != nil {
+				return nil, err
+			}
+			if archived == nil {
+				continue
+			}
+			cm = chatMessageFromArchive(*archived)
+		} else {
+			continue
+		}
+		cm.Room, cm.Conversation = room, conversation
+		msgs = append(msgs, cm)
+	}
+	return msgs, nil
+}
+
+// 用修改记录覆盖消息内容，撤回的消息保留位置但清空内容
+func applyEdits(msgs []protocol.ChatMessage) error {
+	ids := make(map[string][]string)
+	for _, m := range msgs {
+		scope := messageScope(m)
+		ids[scope] = append(ids[scope], m.ID)
+	}
+	edits := make(map[string]map[string]redis.MessageEdit, len(ids))
+	for scope, list := range ids {
+		e, err := redis.GetMessageEdits(scope, list)
+		if err != nil {
+			return err
+		}
+		edits[scope] = e
+	}
+	for i := range msgs {
+		e, ok := edits[messageScope(msgs[i])][msgs[i].ID]
+		if !ok {
+			continue
+		}
+		msgs[i].Edited = e.EditedAt
+		msgs[i].Deleted = e.Deleted
+		msgs[i].Content = e.Content
+	}
+	return nil
+}
+
+// 消息所在的聊天室或私聊会话的标识
+func messageScope(m protocol.ChatMessage) string {
+	if m.Conversation != "" {
+		return redis.ConversationScope(m.Conversation)
+	}
+	return redis.RoomScope(m.Room)
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"strings"
+	"time"
+)
+
+const (
+	exportPageSize  = 200       //每次从历史中读取的条数
+	exportChunkSize = 32 * 1024 //每段导出内容的大致字节数
+)
+
+// 支持的导出格式和对应的文件扩展名
+var exportFormats = map[string]string{
+	"json": "json",
+	"csv":  "csv",
+	"md":   "md",
+	"html": "html",
+}
+
+// HandleExport 导出聊天室或私聊在某个时间范围内的记录，分段发送export_chunk，由客户端拼接成文件
+func (s *Server) HandleExport(msg *protocol.Message, c *ClientConn) {
+	var req protocol.ExportRequest
+	if err := protocol.DecodeContent(msg.Content, &req); err != nil {
+		sendExportFail(c, "无效的导出请求")
+		return
+	}
+	req.Format = strings.ToLower(strings.TrimSpace(req.Format))
+	if _, ok := exportFormats[req.Format]; !ok {
+		sendExportFail(c, "不支持的导出格式，可选json、csv、md、html")
+		return
+	}
+	if req.To > 0 && req.From > req.To {
+		sendExportFail(c, "开始时间不能晚于结束时间")
+		return
+	}
+	req.Room, req.Peer = strings.TrimSpace(req.Room), strings.TrimSpace(req.Peer)
+	if req.Peer == "" && req.Room == "" {
+		req.Room = mainRoom
+	}
+	if req.Peer != "" && req.Room != "" {
+		sendExportFail(c, "聊天室和私聊对象只能选一个")
+		return
+	}
+	if req.Room != "" && !s.canAccessRoom(c, req.Room) {
+		sendExportFail(c, "无法访问该聊天室")
+		return
+	}
+	if req.Peer == c.Name {
+		sendExportFail(c, "不能导出与自己的私聊")
+		return
+	}
+	//同一个连接同时只进行一次导出，避免占满发送队列
+	if !c.exporting.CompareAndSwap(false, true) {
+		sendExportFail(c, "已有导出正在进行，请等待完成")
+		return
+	}
+	id, err := protocol.NewNonce()
+	if err != nil {
+		c.exporting.Store(false)
+		sendExportFail(c, "导出失败")
+		return
+	}
+	go func() {
+		defer c.exporting.Store(false)
+		count, err := s.export(c, id, &req)
+		if err != nil {
+			log.Printf("用户%s导出聊天记录失败:%v", c.Name, err)
+			c.send(&protocol.Message{
+				Type:    "export_fail",
+				Content: fmt.Sprintf("导出失败，已导出%d条消息", count),
+				From:    "system",
+			})
+		}
+	}()
+}
+
+func sendExportFail(c *ClientConn, reason string) {
+	c.Outgoing <- &protocol.Message{
+		Type:    "export_fail",
+		Content: reason,
+		From:    "system",
+	}
+}
+
+// 从最早的位置向后遍历历史，边格式化边分段发送，返回导出的消息数
+func (s *Server) export(c *ClientConn, id string, req *protocol.ExportRequest) (int, error) {
+	title := "聊天室 " + req.Room
+	name := "room_" + req.Room
+	load := func(r redis.HistoryRange) ([]protocol.ChatMessage, bool, error) {
+		return s.loadRoomHistory(req.Room, r)
+	}
+	if req.Peer != "" {
+		title = fmt.Sprintf("%s 与 %s 的私聊", c.Name, req.Peer)
+		name = "private_" + req.Peer
+		load = func(r redis.HistoryRange) ([]protocol.ChatMessage, bool, error) {
+			return s.loadPrivateHistory(c.Name, req.Peer, r)
+		}
+	}
+	w := &transcriptWriter{
+		c:        c,
+		id:       id,
+		format:   req.Format,
+		loc:      time.FixedZone("", req.TzOffset),
+		filename: fmt.Sprintf("%s_%s.%s", name, s.clock().Format("20060102150405"), exportFormats[req.Format]),
+	}
+	w.header(title)
+
+	r := redis.HistoryRange{Limit: exportPageSize, Since: req.From}
+	if req.From <= 0 {
+		r.After = "0-0"
+	}
+	for {
+		//连接已断开时不再继续读取
+		select {
+		case <-c.quit:
+			return w.count, nil
+		default:
+		}
+		msgs, hasMore, err := load(r)
+		if err != nil {
+			return w.count, err
+		}
+		for _, m := range msgs {
+			if req.To > 0 && m.Ts > req.To {
+				return w.finish()
+			}
+			w.row(m)
+		}
+		if !hasMore || len(msgs) == 0 {
+			return w.finish()
+		}
+		r = redis.HistoryRange{Limit: exportPageSize, After: msgs[len(msgs)-1].ID}
+	}
+}
+
+// transcriptWriter 按格式输出聊天记录，缓冲满一段后发送
+type transcriptWriter struct {
+	c        *ClientConn
+	id       string
+	format   string
+	loc      *time.Location
+	filename string
+	buf      bytes.Buffer
+	csv      *csv.Writer
+	seq      int
+	count    int
+}
+
+func (w *transcriptWriter) header(title string) {
+	switch w.format {
+	case "json":
+		w.buf.WriteString("[")
+	case "csv":
+		//带BOM，表格软件打开时能正确识别UTF-8
+		w.buf.WriteString("\ufeff")
+		w.csv = csv.NewWriter(&w.buf)
+		_ = w.csv.Write([]string{"id", "time", "sender", "content"})
+	case "md":
+		fmt.Fprintf(&w.buf, "# %s\n\n", title)
+	case "html":
+		fmt.Fprintf(&w.buf, `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>%[1]s</title>
+<style>
+body{font-family:sans-serif;max-width:860px;margin:2em auto;color:#222}
+.msg{padding:.4em 0;border-bottom:1px solid #eee}
+.meta{color:#888;font-size:.85em}
+.sender{font-weight:bold;color:#2a6ebb}
+.content{white-space:pre-wrap;word-break:break-word}
+</style>
+</head>
+<body>
+<h1>%[1]s</h1>
+`, html.EscapeString(title))
+	}
+}
+
+func (w *transcriptWriter) row(m protocol.ChatMessage) {
+	t := time.Unix(m.Ts, 0).In(w.loc).Format("2006-01-02 15:04:05")
+	//JSON中保留deleted、edited字段，其他格式在内容中注明
+	if w.format != "json" {
+		if m.Deleted {
+			m.Content = "(消息已撤回)"
+		} else if m.Edited > 0 {
+			m.Content += " (已编辑)"
+		}
+		if m.ReplyTo != nil {
+			m.Content = quoteReply(m.ReplyTo) + m.Content
+		}
+		if len(m.Reactions) > 0 {
+			m.Content += " " + formatReactions(m.Reactions)
+		}
+	}
+	switch w.format {
+	case "json":
+		data, _ := json.Marshal(m)
+		if w.count > 0 {
+			w.buf.WriteString(",")
+		}
+		w.buf.WriteString("\n  ")
+		w.buf.Write(data)
+	case "csv":
+		_ = w.csv.Write([]string{m.ID, t, csvEscape(m.Sender), csvEscape(m.Content)})
+		w.csv.Flush()
+	case "md":
+		//多行内容缩进到同一条列表项下
+		content := strings.ReplaceAll(m.Content, "\n", "\n  ")
+		fmt.Fprintf(&w.buf, "- **%s** `%s`  \n  %s\n", markdownEscape(m.Sender), t, content)
+	case "html":
+		fmt.Fprintf(&w.buf, "<div class=\"msg\" id=\"m-%s\"><span class=\"sender\">%s</span> <span class=\"meta\">%s</span><div class=\"content\">%s</div></div>\n",
+			html.EscapeString(m.ID), html.EscapeString(m.Sender), t, html.EscapeString(m.Content))
+	}
+	w.count++
+	if w.buf.Len() >= exportChunkSize {
+		w.flush(false)
+	}
+}
+
+func (w *transcriptWriter) finish() (int, error) {
+	switch w.format {
+	case "json":
+		if w.count > 0 {
+			w.buf.WriteString("\n")
+		}
+		w.buf.WriteString("]\n")
+	case "md":
+		if w.count == 0 {
+			w.buf.WriteString("(没有消息)\n")
+		}
+	case "html":
+		if w.count == 0 {
+			w.buf.WriteString("<p class=\"meta\">没有消息</p>\n")
+		}
+		w.buf.WriteString("</body>\n</html>\n")
+	}
+	w.flush(true)
+	return w.count, nil
+}
+
+func (w *transcriptWriter) flush(done bool) {
+	chunk := protocol.ExportChunk{
+		ID:   w.id,
+		Seq:  w.seq,
+		Data: w.buf.String(),
+		Done: done,
+	}
+	if w.seq == 0 {
+		chunk.Filename = w.filename
+	}
+	if done {
+		chunk.Count = w.count
+	}
+	w.c.send(&protocol.Message{
+		Type:    "export_chunk",
+		Content: chunk,
+		From:    "system",
+	})
+	w.seq++
+	w.buf.Reset()
+}
+
+// 转义用户名中的Markdown标记
+func markdownEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`").Replace(s)
+}
+
+// 以公式字符开头的单元格前加单引号，防止表格软件把消息内容当成公式执行
+func csvEscape(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// 回复在非JSON格式中以引用开头
+func quoteReply(r *protocol.ReplyContext) string {
+	if r.Deleted {
+		return "[回复已撤回的消息] "
+	}
+	return fmt.Sprintf("[回复 %s: %s] ", r.Sender, r.Snippet)
+}
+
+// 表情回应在非JSON格式中附在内容后面，如 [👍×3 🎉×1]
+func formatReactions(reactions []protocol.Reaction) string {
+	parts := make([]string, 0, len(reactions))
+	for _, r := range reactions {
+		parts = append(parts, fmt.Sprintf("%s×%d", r.Emoji, r.Count))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
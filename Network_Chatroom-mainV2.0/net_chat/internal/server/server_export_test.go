@@ -0,0 +1,25 @@
+package server
+
+import "testing"
+
+func TestCSVEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"hello", "hello"},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+1", "'+1"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"a=1", "a=1"},
+		{"你好", "你好"},
+	}
+	for _, tt := range tests {
+		if got := csvEscape(tt.in); got != tt.want {
+			t.Errorf("csvEscape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
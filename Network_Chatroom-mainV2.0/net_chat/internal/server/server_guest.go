@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net_chat/internal/protocol"
+	"strings"
+)
+
+// 访客用户名前缀，注册时不允许使用
+const guestPrefix = "guest-"
+
+// 访客登录后允许发送的请求，其余一律拒绝
+var guestAllowed = map[string]bool{
+	"chat":          true, //只能在开放给访客的聊天室中发言，私聊在HandleChat中拒绝
+	"list":          true,
+	"room_messages": true,
+	"activityDay":   true,
+	"activityWeek":  true,
+	"activityTotal": true,
+	"logout":        true,
+}
+
+// SetGuestRooms 设置允许访客阅读和发言的聊天室，需要在Start之前调用
+func (s *Server) SetGuestRooms(rooms []string) {
+	s.guestRooms = make(map[string]bool)
+	for _, room := range rooms {
+		if room = strings.TrimSpace(room); room != "" {
+			s.guestRooms[room] = true
+		}
+	}
+}
+
+// 判断用户名是否为访客
+func isGuest(name string) bool {
+	return strings.HasPrefix(name, guestPrefix)
+}
+
+// 当前连接能否访问该聊天室，还没登录的连接都不能访问
+func (s *Server) canAccessRoom(c *ClientConn, room string) bool {
+	if c.Name == "" {
+		return false
+	}
+	return !c.Guest || s.guestRooms[room]
+}
+
+// HandleGuestLogin 访客登录：分配一个临时的guest-XXXX用户名，不写入users表
+func (s *Server) HandleGuestLogin(c *ClientConn) {
+	if c.Name != "" {
+		return
+	}
+	//随机分配名字，和在线用户重名时重新生成
+	for i := 0; i < 10; i++ {
+		buf := make([]byte, 2)
+		if _, err := rand.Read(buf); err != nil {
+			break
+		}
+		name := guestPrefix + hex.EncodeToString(buf)
+		if s.GetUser(name) != nil {
+			continue
+		}
+		c.Guest = true
+		s.loginSuccess(c, name, "login_success", protocol.LoginResult{})
+		if c.Name == name {
+			return
+		}
+		c.Guest = false
+	}
+	c.Outgoing <- &protocol.Message{
+		Type:    "login_fail",
+		Content: "访客名额已满，请稍后再试",
+		From:    "system",
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net_chat/internal/database"
+	"net_chat/internal/protocol"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 邀请码默认的有效期
+const defaultInviteTTL = 72 * time.Hour
+
+// SetInviteOnly 开启只邀请注册模式，需要在Start之前调用
+func (s *Server) SetInviteOnly(on bool) {
+	s.inviteOnly = on
+}
+
+// HandleCreateInvite 生成邀请码，内容为 可用次数|有效小时数（都可省略，默认1次、72小时，小时数为0表示永不过期）
+// 管理员不受限制，普通用户每个可用次数消耗一个邀请额度
+func (s *Server) HandleCreateInvite(msg *protocol.Message, c *ClientConn) {
+	if c.Name == "" {
+		return
+	}
+	if _, ok := s.accounts(); !ok {
+		c.Outgoing <- &protocol.Message{
+			Type:    "invite_fail",
+			Content: "当前服务器不支持邀请码",
+			From:    "system",
+		}
+		return
+	}
+
+	maxUses, ttl := 1, defaultInviteTTL
+	content, _ := msg.Content.(string)
+	parts := strings.Split(content, "|")
+	if v := strings.TrimSpace(parts[0]); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.Outgoing <- &protocol.Message{
+				Type:    "invite_fail",
+				Content: "可用次数必须是正整数",
+				From:    "system",
+			}
+			return
+		}
+		maxUses = n
+	}
+	if len(parts) > 1 {
+		hours, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || hours < 0 {
+			c.Outgoing <- &protocol.Message{
+				Type:    "invite_fail",
+				Content: "有效小时数必须是非负整数",
+				From:    "system",
+			}
+			return
+		}
+		ttl = time.Duration(hours) * time.Hour
+	}
+	admin := s.isAdmin(c.Name)
+	code, err := database.CreateInvite(c.Name, maxUses, ttl, !admin)
+	if err != nil {
+		if !errors.Is(err, database.ErrNoInviteQuota) {
+			log.Printf("用户%s生成邀请码失败:%v", c.Name, err)
+		}
+		c.Outgoing <- &protocol.Message{
+			Type:    "invite_fail",
+			Content: err.Error(),
+			From:    "system",
+		}
+		return
+	}
+	s.audit(c.Name, "invite_create", code)
+
+	expires := "永不过期"
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).Format("2006-01-02 15:04:05") + "前有效"
+	}
+	c.Outgoing <- &protocol.Message{
+		Type:    "invite_created",
+		Content: fmt.Sprintf("邀请码：%s（可使用%d次，%s）", code, maxUses, expires),
+		From:    "system",
+	}
+}
+
+// HandleInviteQuota 管理员设置用户的邀请额度，内容为 用户名|额度
+func (s *Server) HandleInviteQuota(msg *protocol.Message, c *ClientConn) {
+	if !s.isAdmin(c.Name) {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "只有管理员可以设置邀请额度",
+			From:    "system",
+		}
+		return
+	}
+	if _, ok := s.accounts(); !ok {
+		return
+	}
+	content, _ := msg.Content.(string)
+	parts := strings.Split(content, "|")
+	var quota int
+	var err error
+	if len(parts) == 2 {
+		quota, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+	}
+	if len(parts) != 2 || err != nil || quota < 0 {
+		c.Outgoing <- &protocol.Message{
+			Type:    "invite_fail",
+			Content: "格式错误，请使用‘用户名|额度’的格式",
+			From:    "system",
+		}
+		return
+	}
+	target := strings.TrimSpace(parts[0])
+	if err := database.SetInviteQuota(target, quota); err != nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "invite_fail",
+			Content: err.Error(),
+			From:    "system",
+		}
+		return
+	}
+	s.audit(c.Name, "invite_quota", target)
+	c.Outgoing <- &protocol.Message{
+		Type:    "invite_created",
+		Content: fmt.Sprintf("已将用户%s的邀请额度设置为%d", target, quota),
+		From:    "system",
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net_chat/internal/protocol"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnLimits 连接限制配置，数值为0表示不限制
+type ConnLimits struct {
+	Allow       []string //允许的网段（CIDR或单个IP），为空表示全部允许
+	Deny        []string //拒绝的网段，优先于Allow
+	MaxConns    int      //最大连接总数
+	MaxPerIP    int      //每个IP的最大连接数
+	AcceptRate  float64  //每秒最多接受的新连接数
+	AcceptBurst int      //突发时最多一次接受的连接数
+}
+
+// ConnStats 连接统计，用于监控
+type ConnStats struct {
+	Active         int64 `json:"active"`          //当前连接数
+	Accepted       int64 `json:"accepted"`        //累计接受的连接
+	RejectedDenied int64 `json:"rejected_denied"` //因黑白名单被拒绝
+	RejectedTotal  int64 `json:"rejected_total"`  //因连接总数已满被拒绝
+	RejectedPerIP  int64 `json:"rejected_per_ip"` //因单个IP连接数已满被拒绝
+	RejectedRate   int64 `json:"rejected_rate"`   //因接入速率过快被拒绝
+}
+
+// 连接限制器
+type connLimiter struct {
+	allow, deny []*net.IPNet
+	maxConns    int
+	maxPerIP    int
+
+	mu     sync.Mutex
+	active int
+	perIP  map[string]int
+	//令牌桶，控制接受新连接的速率
+	rate     float64
+	burst    float64
+	tokens   float64
+	lastFill time.Time
+
+	accepted, rejectedDenied, rejectedTotal, rejectedPerIP, rejectedRate atomic.Int64
+}
+
+// 解析网段，单个IP视为/32或/128
+func parseCIDRs(items []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的IP:%s", item)
+			}
+			//IPv4映射的IPv6地址也按IPv4处理，直接拼上/32会变成::/32
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的网段:%s", item)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetConnLimits 设置连接限制，需要在Start之前调用
+func (s *Server) SetConnLimits(cfg ConnLimits) error {
+	allow, err := parseCIDRs(cfg.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseCIDRs(cfg.Deny)
+	if err != nil {
+		return err
+	}
+	burst := float64(cfg.AcceptBurst)
+	if burst < 1 {
+		burst = 1
+	}
+	s.limiter = &connLimiter{
+		allow:    allow,
+		deny:     deny,
+		maxConns: cfg.MaxConns,
+		maxPerIP: cfg.MaxPerIP,
+		perIP:    make(map[string]int),
+		rate:     cfg.AcceptRate,
+		burst:    burst,
+		tokens:   burst,
+		lastFill: time.Now(),
+	}
+	return nil
+}
+
+// admit 判断是否接受新连接，接受时返回释放函数，连接关闭时调用；拒绝时返回原因
+func (l *connLimiter) admit(addr net.Addr) (func(), string) {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || containsIP(l.deny, ip) || (len(l.allow) > 0 && !containsIP(l.allow, ip)) {
+		l.rejectedDenied.Add(1)
+		return nil, "你的IP不允许连接本服务器"
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate > 0 {
+		now := time.Now()
+		l.tokens += now.Sub(l.lastFill).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.lastFill = now
+		if l.tokens < 1 {
+			l.rejectedRate.Add(1)
+			return nil, "连接过于频繁，请稍后再试"
+		}
+		l.tokens--
+	}
+	if l.maxConns > 0 && l.active >= l.maxConns {
+		l.rejectedTotal.Add(1)
+		return nil, "服务器连接数已满，请稍后再试"
+	}
+	if l.maxPerIP > 0 && l.perIP[host] >= l.maxPerIP {
+		l.rejectedPerIP.Add(1)
+		return nil, "来自你的IP的连接数过多"
+	}
+	l.active++
+	l.perIP[host]++
+	l.accepted.Add(1)
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.active--
+		if l.perIP[host]--; l.perIP[host] <= 0 {
+			delete(l.perIP, host)
+		}
+	}, ""
+}
+
+// Stats 返回连接统计
+func (s *Server) Stats() ConnStats {
+	l := s.limiter
+	l.mu.Lock()
+	active := l.active
+	l.mu.Unlock()
+	return ConnStats{
+		Active:         int64(active),
+		Accepted:       l.accepted.Load(),
+		RejectedDenied: l.rejectedDenied.Load(),
+		RejectedTotal:  l.rejectedTotal.Load(),
+		RejectedPerIP:  l.rejectedPerIP.Load(),
+		RejectedRate:   l.rejectedRate.Load(),
+	}
+}
+
+// 拒绝连接：先发送一条错误消息再关闭，在单独的协程中进行，不阻塞Accept
+func rejectConn(conn net.Conn, reason string) {
+	go func() {
+		_ = conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+		if err := protocol.SendMsg(conn, &protocol.Message{
+			Type:    "error",
+			Content: reason,
+			From:    "system",
+		}); err != nil {
+			log.Printf("向%s发送拒绝原因失败:%v", conn.RemoteAddr(), err)
+		}
+		_ = conn.Close()
+	}()
+}
+
+// HandleStats 管理员查看连接统计
+func (s *Server) HandleStats(c *ClientConn) {
+	if !s.isAdmin(c.Name) {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "只有管理员可以查看连接统计",
+			From:    "system",
+		}
+		return
+	}
+	c.Outgoing <- &protocol.Message{
+		Type:    "stats",
+		Content: s.Stats(),
+		From:    "system",
+	}
+}
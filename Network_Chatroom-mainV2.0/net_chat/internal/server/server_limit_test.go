@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		items   []string
+		want    []string
+		wantErr bool
+	}{
+		{"empty", nil, nil, false},
+		{"blank items skipped", []string{"", "  "}, nil, false},
+		{"ipv4 single", []string{"10.0.0.1"}, []string{"10.0.0.1/32"}, false},
+		{"ipv6 single", []string{"::1"}, []string{"::1/128"}, false},
+		{"mapped ipv4 single", []string{"::ffff:10.0.0.1"}, []string{"10.0.0.1/32"}, false},
+		{"cidr", []string{" 192.168.1.7/24 "}, []string{"192.168.1.0/24"}, false},
+		{"ipv6 cidr", []string{"2001:db8::1/32"}, []string{"2001:db8::/32"}, false},
+		{"bad ip", []string{"10.0.0.256"}, nil, true},
+		{"bad cidr", []string{"10.0.0.0/33"}, nil, true},
+		{"bad item among good", []string{"10.0.0.1", "host"}, nil, true},
+	}
+	for _, tt := range tests {
+		nets, err := parseCIDRs(tt.items)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, n := range nets {
+			got = append(got, n.String())
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func newTestLimiter(t *testing.T, cfg ConnLimits) *connLimiter {
+	t.Helper()
+	s := &Server{}
+	if err := s.SetConnLimits(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return s.limiter
+}
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+}
+
+func TestConnLimiterAllowDeny(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   ConnLimits
+		addr  string
+		admit bool
+	}{
+		{"no lists", ConnLimits{}, "203.0.113.5", true},
+		{"in allow", ConnLimits{Allow: []string{"10.0.0.0/8"}}, "10.1.2.3", true},
+		{"outside allow", ConnLimits{Allow: []string{"10.0.0.0/8"}}, "11.0.0.1", false},
+		{"single ip allow", ConnLimits{Allow: []string{"10.0.0.1"}}, "10.0.0.2", false},
+		{"in deny", ConnLimits{Deny: []string{"10.0.0.1"}}, "10.0.0.1", false},
+		{"deny beats allow", ConnLimits{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.0/24"}}, "10.0.0.9", false},
+		{"allowed next to deny", ConnLimits{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.0/24"}}, "10.0.1.9", true},
+		{"ipv6 deny", ConnLimits{Deny: []string{"::1"}}, "::1", false},
+		{"ipv6 outside ipv4 allow", ConnLimits{Allow: []string{"10.0.0.0/8"}}, "2001:db8::1", false},
+	}
+	for _, tt := range tests {
+		l := newTestLimiter(t, tt.cfg)
+		release, reason := l.admit(tcpAddr(tt.addr))
+		if (release != nil) != tt.admit {
+			t.Errorf("%s: admit(%s) = %q, want admit %v", tt.name, tt.addr, reason, tt.admit)
+			continue
+		}
+		if !tt.admit && l.rejectedDenied.Load() != 1 {
+			t.Errorf("%s: rejectedDenied = %d", tt.name, l.rejectedDenied.Load())
+		}
+	}
+}
+
+func TestConnLimiterRate(t *testing.T) {
+	l := newTestLimiter(t, ConnLimits{AcceptRate: 1, AcceptBurst: 2})
+	for i := 0; i < 2; i++ {
+		if release, reason := l.admit(tcpAddr("10.0.0.1")); release == nil {
+			t.Fatalf("burst connection %d rejected: %s", i, reason)
+		}
+	}
+	if release, _ := l.admit(tcpAddr("10.0.0.1")); release != nil {
+		t.Fatal("connection beyond burst admitted")
+	}
+	//一秒后补回一个令牌
+	l.lastFill = l.lastFill.Add(-time.Second)
+	if release, reason := l.admit(tcpAddr("10.0.0.1")); release == nil {
+		t.Fatalf("refilled token not used: %s", reason)
+	}
+	if release, _ := l.admit(tcpAddr("10.0.0.1")); release != nil {
+		t.Fatal("second connection after one token refill admitted")
+	}
+	//长时间空闲也最多补满burst
+	l.lastFill = l.lastFill.Add(-time.Hour)
+	for i := 0; i < 2; i++ {
+		if release, _ := l.admit(tcpAddr("10.0.0.1")); release == nil {
+			t.Fatalf("connection %d after idle rejected", i)
+		}
+	}
+	if release, _ := l.admit(tcpAddr("10.0.0.1")); release != nil {
+		t.Fatal("tokens refilled beyond burst")
+	}
+	if got := l.rejectedRate.Load(); got != 3 {
+		t.Fatalf("rejectedRate = %d", got)
+	}
+}
+
+func TestConnLimiterRelease(t *testing.T) {
+	l := newTestLimiter(t, ConnLimits{MaxConns: 2, MaxPerIP: 1})
+	releaseA, reason := l.admit(tcpAddr("10.0.0.1"))
+	if releaseA == nil {
+		t.Fatal(reason)
+	}
+	if release, _ := l.admit(tcpAddr("10.0.0.1")); release != nil {
+		t.Fatal("second connection from the same IP admitted")
+	}
+	releaseB, reason := l.admit(tcpAddr("10.0.0.2"))
+	if releaseB == nil {
+		t.Fatal(reason)
+	}
+	if release, _ := l.admit(tcpAddr("10.0.0.3")); release != nil {
+		t.Fatal("connection beyond MaxConns admitted")
+	}
+
+	//释放后同一个IP可以再次连接，计数清零的IP从表中删除
+	releaseA()
+	if _, ok := l.perIP["10.0.0.1"]; ok {
+		t.Fatalf("released IP still tracked: %v", l.perIP)
+	}
+	if l.perIP["10.0.0.2"] != 1 || l.active != 1 {
+		t.Fatalf("perIP = %v, active = %d", l.perIP, l.active)
+	}
+	if release, reason := l.admit(tcpAddr("10.0.0.1")); release == nil {
+		t.Fatalf("released IP rejected: %s", reason)
+	}
+	releaseB()
+	if l.active != 1 || len(l.perIP) != 1 {
+		t.Fatalf("perIP = %v, active = %d", l.perIP, l.active)
+	}
+	if l.rejectedPerIP.Load() != 1 || l.rejectedTotal.Load() != 1 || l.accepted.Load() != 3 {
+		t.Fatalf("stats = %+v", (&Server{limiter: l}).Stats())
+	}
+}
@@ -2,19 +2,31 @@ package server
 
 import (
 	"fmt"
+	"log"
+	"net_chat/internal/database/redis"
 	"net_chat/internal/protocol"
 	"strings"
 )
 
 func (s *Server) HandleLogin(msg *protocol.Message, c *ClientConn) {
-	content := strings.Split(msg.Content.(string), "|")
+	//已经登录的连接不能再登录其他账号
+	if c.Name != "" {
+		return
+	}
+	text, _ := msg.Content.(string)
+	content := strings.Split(text, "|")
+	if len(content) != 2 {
+		c.Outgoing <- &protocol.Message{
+			Type:    "login_fail",
+			Content: "格式错误，请使用‘用户名|密码’的格式",
+			From:    "system",
+		}
+		return
+	}
 	username := strings.TrimSpace(content[0])
 	password := strings.TrimSpace(content[1])
-	//1.先在数据库中检查是否存在和账号密码的正确性
 	// 1. 先检查用户是否已经在线
-	s.mu.RLock()
-	if _, ok := s.users[username]; ok {
-		s.mu.RUnlock()
+	if s.GetUser(username) != nil {
 		c.Outgoing <- &protocol.Message{
 			Type:    "login_fail",
 			Content: "用户在线中",
@@ -22,56 +34,101 @@ func (s *Server) HandleLogin(msg *protocol.Message, c *ClientConn) {
 		}
 		return
 	}
-	s.mu.RUnlock()
-	err := s.CheckUser(username, password)
-	if err != nil {
-		c.Outgoing <- &protocol.Message{
-			Type:    "login_fail",
-			Content: err.Error(),
-			From:    "system",
+	//2.已经有挑战-应答校验值的账号不接受明文密码，防止登录被降级到发送密码
+	//  各种失败的提示相同，避免通过明文登录探测账号是否存在、是否已经升级
+	if store, ok := s.accounts(); ok {
+		if stored, err := store.ScramVerifier(username); err != nil || stored != "" {
+			sendLoginFail(c)
+			return
 		}
-		//2.账号密码正确且存在检查在线用户列表是否已经存在该用户
 	}
-	if err == nil {
-		err = s.AddUser(username, c)
-		c.Name = username
-		//发送登录成功的消息
+	//3.在数据库中检查是否存在和账号密码的正确性
+	if err := s.CheckUser(username, password); err != nil {
+		sendLoginFail(c)
+		return
+	}
+	s.completeLogin(c, username, protocol.LoginResult{})
+}
+
+func sendLoginFail(c *ClientConn) {
+	c.Outgoing <- &protocol.Message{
+		Type:    "login_fail",
+		Content: "登录失败: 用户名或密码错误",
+		From:    "system",
+	}
+}
+
+// loginSuccess 身份验证通过后的统一流程：加入用户列表、签发会话令牌、推送提醒并广播上线
+// result中可以预先填好各登录方式特有的字段，用户已经在线导致登录失败时返回false
+func (s *Server) loginSuccess(c *ClientConn, username, replyType string, result protocol.LoginResult) bool {
+	if err := s.AddUser(username, c); err != nil {
 		c.Outgoing <- &protocol.Message{
-			Type:    "login_success",
-			Content: "Welcome" + username,
+			Type:    strings.TrimSuffix(replyType, "_success") + "_fail",
+			Content: "用户" + err.Error(),
 			From:    "system",
 		}
-		//发送未读消息提醒
-		s.sendUnreadMessages(c, username)
-		//用户活跃度+1
-		OnUserLogin(username)
-		//广播用户上线通知
-		s.Broadcast(&protocol.Message{
-			Type:    "notice",
-			Content: fmt.Sprintf("%s 加入了聊天室", username),
-			From:    "system",
-		})
+		return false
+	}
+	c.Name = username
 
-	} else {
+	//签发会话令牌，客户端断线后凭令牌重连，无需再次发送密码
+	//访客是临时身份，不签发令牌
+	if !c.Guest {
+		token, expiresAt, err := redis.CreateSession(username)
+		if err != nil {
+			log.Printf("为用户%s签发会话令牌失败:%v", username, err)
+		} else {
+			c.Token = token
+			result.Token = token
+			result.ExpiresAt = expiresAt.Unix()
+		}
+	}
+
+	//发送登录成功的消息
+	result.Username = username
+	result.Welcome = "Welcome" + username
+	c.Outgoing <- &protocol.Message{
+		Type:    replyType,
+		Content: result,
+		From:    "system",
+	}
+	//使用一次性密码登录的用户必须先修改密码
+	if store, ok := s.accounts(); ok && store.MustChangePassword(username) {
+		c.mustChange = true
 		c.Outgoing <- &protocol.Message{
-			Type:    "login_fail",
-			Content: "登录失败: " + err.Error(),
+			Type:    "password_change_required",
+			Content: "你正在使用管理员重置的一次性密码，请先修改密码",
 			From:    "system",
 		}
 	}
+	//投递离线私聊，推送聊天室未读数
+	s.sendUnreadMessages(c, username)
+	if !c.Guest {
+		s.sendRoomUnread(c, true)
+		s.sendMentionNotice(c)
+	}
+	//用户活跃度+1
+	OnUserLogin(username)
+	//广播用户上线通知
+	s.Broadcast(&protocol.Message{
+		Type:    "notice",
+		Content: fmt.Sprintf("%s 加入了聊天室", username),
+		From:    "system",
+	})
+	return true
 }
 
 // AddUser 添加用户到用户列表map中,便于后续的私聊和查看用户列表
 func (s *Server) AddUser(name string, c *ClientConn) error {
 	//加锁保证数据添加无误
 	s.mu.Lock()
+	defer s.mu.Unlock()
 	//检查重名
 	if _, ok := s.users[name]; ok {
 		return fmt.Errorf("在线中") //名字存在
 	}
 	//每个名字维持一个对应连接
 	s.users[name] = c
-	fmt.Printf("添加用户%s成功", name)
-	s.mu.Unlock()
+	fmt.Printf("添加用户%s成功\n", name)
 	return nil
 }
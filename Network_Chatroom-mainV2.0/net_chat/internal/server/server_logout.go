@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"log"
+	"net_chat/internal/database/redis"
 	"net_chat/internal/protocol"
 )
 
@@ -13,6 +14,14 @@ func (s *Server) HandleLogout(c *ClientConn) {
 		if err != nil {
 			log.Printf("在删除用户时发生错误%s:", err)
 		}
+		//主动登出时吊销本次的会话令牌，之后无法再用它恢复会话
+		if err := redis.RevokeSession(c.Token); err != nil {
+			log.Printf("吊销用户%s的会话令牌失败:%v", username, err)
+		}
+		c.Name = ""
+		c.Token = ""
+		c.mustChange = false
+		c.Guest = false
 		c.Outgoing <- &protocol.Message{
 			Type:    "logout_success",
 			Content: "你已经从聊天室退出",
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxMentionsPerMessage = 10 //一条消息最多通知的用户数，防止刷屏
+	defaultMentionLimit   = 20
+	maxMentionLimit       = 50
+)
+
+var mentionPattern = regexp.MustCompile(`@([^\s@|]+)`)
+
+// 找出消息中@到的用户名，去掉重复、自己和访客，名字后面紧跟的标点不算在内
+func parseMentions(content, sender string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.TrimRight(m[1], ",，.。:：;；!！?？)）」'\"")
+		if name == "" || name == sender || isGuest(name) || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) == maxMentionsPerMessage {
+			break
+		}
+	}
+	return names
+}
+
+// 保存聊天室消息中的@提及，并通知在线的被提及者
+func (s *Server) notifyMentions(room string, cm protocol.ChatMessage) {
+	names := parseMentions(cm.Content, cm.Sender)
+	if len(names) == 0 {
+		return
+	}
+	//只记录已注册的用户，避免为随手输入的@建立数据
+	users := make([]string, 0, len(names))
+	for _, name := range names {
+		ok, err := s.auth.UserExists(name)
+		if err != nil {
+			log.Printf("检查被提及的用户%s失败:%v", name, err)
+			continue
+		}
+		if ok {
+			users = append(users, name)
+		}
+	}
+	if len(users) == 0 {
+		return
+	}
+	ref := redis.MentionRef{Scope: redis.RoomScope(room), ID: cm.ID}
+	if err := redis.AddMentions(ref.Scope, ref.ID, users); err != nil {
+		log.Printf("保存消息%s的提及失败:%v", cm.ID, err)
+		return
+	}
+	for _, name := range users {
+		target := s.GetUser(name)
+		if target == nil || !s.canAccessRoom(target, room) {
+			continue
+		}
+		//不管对方在哪个聊天室或菜单都会收到，收到即视为看过这一条
+		target.Outgoing <- &protocol.Message{
+			Type:    "mention",
+			Content: protocol.Mention{Message: cm},
+			From:    cm.Sender,
+			ID:      cm.ID,
+		}
+		if err := redis.MarkMentionsSeen(name, []redis.MentionRef{ref}); err != nil {
+			log.Printf("记录%s看过的提及失败:%v", name, err)
+		}
+	}
+}
+
+// 登录后提示离线期间被提及的次数
+func (s *Server) sendMentionNotice(c *ClientConn) {
+	n, err := redis.CountUnseenMentions(c.Name)
+	if err != nil {
+		log.Printf("统计用户%s未读的提及失败:%v", c.Name, err)
+		return
+	}
+	if n == 0 {
+		return
+	}
+	c.Outgoing <- &protocol.Message{
+		Type:    "notice",
+		Content: fmt.Sprintf("有%d条消息提到了你，可以在主菜单中查看", n),
+		From:    "system",
+	}
+}
+
+// HandleMentions 按时间倒序返回提到请求者的消息，返回的提及标记为已看
+func (s *Server) HandleMentions(msg *protocol.Message, c *ClientConn) {
+	if c.Guest {
+		sendError(c, "访客无法使用该功能")
+		return
+	}
+	var q protocol.MentionQuery
+	if msg.Content != nil {
+		if err := protocol.DecodeContent(msg.Content, &q); err != nil {
+			sendError(c, "无效的请求")
+			return
+		}
+	}
+	if q.Before != "" && !redis.IsStreamID(q.Before) {
+		sendError(c, "无效的消息ID")
+		return
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultMentionLimit
+	}
+	if q.Limit > maxMentionLimit {
+		q.Limit = maxMentionLimit
+	}
+	list, refs, err := s.loadMentions(c, q)
+	if err != nil {
+		log.Printf("读取用户%s的提及失败:%v", c.Name, err)
+		sendError(c, "获取提及失败")
+		return
+	}
+	c.Outgoing <- &protocol.Message{
+		Type:    "mentions",
+		Content: list,
+		From:    "system",
+	}
+	if err := redis.MarkMentionsSeen(c.Name, refs); err != nil {
+		log.Printf("记录%s看过的提及失败:%v", c.Name, err)
+	}
+}
+
+// 读取一页提及，refs为这一页包含的全部提及（包括已经找不到的消息）
+func (s *Server) loadMentions(c *ClientConn, q protocol.MentionQuery) (list protocol.MentionList, refs []redis.MentionRef, err error) {
+	list.Unseen, err = redis.CountUnseenMentions(c.Name)
+	if err != nil {
+		return list, nil, err
+	}
+	refs, unseen, hasMore, err := redis.GetMentions(c.Name, q.Before, q.Limit)
+	if err != nil {
+		return list, nil, err
+	}
+	list.HasMore = hasMore
+	ids := make(map[string][]string)
+	for _, r := range refs {
+		ids[r.Scope] = append(ids[r.Scope], r.ID)
+	}
+	found := make(map[string]protocol.ChatMessage, len(refs))
+	for scope, group := range ids {
+		msgs, err := s.loadMessages(scope, group)
+		if err != nil {
+			return list, nil, err
+		}
+		for _, m := range msgs {
+			found[scope+"|"+m.ID] = m
+		}
+	}
+	msgs := make([]protocol.ChatMessage, 0, len(refs))
+	msgRefs := make([]redis.MentionRef, 0, len(refs))
+	var missing []redis.MentionRef
+	for _, r := range refs {
+		m, ok := found[r.Scope+"|"+r.ID]
+		//已经删除的消息，或者请求者已经不能进入的聊天室
+		if !ok {
+			missing = append(missing, r)
+			continue
+		}
+		if m.Room != "" && !s.canAccessRoom(c, m.Room) {
+			continue
+		}
+		msgs = append(msgs, m)
+		msgRefs = append(msgRefs, r)
+	}
+	if len(missing) > 0 && !s.archiveEnabled() {
+		if err := redis.RemoveMentions(c.Name, missing); err != nil {
+			log.Printf("清理用户%s的提及失败:%v", c.Name, err)
+		}
+	}
+	if err := s.decorateMessages(msgs); err != nil {
+		log.Printf("读取提及消息的附加信息失败:%v", err)
+	}
+	list.Mentions = make([]protocol.Mention, 0, len(msgs))
+	for i, m := range msgs {
+		list.Mentions = append(list.Mentions, protocol.Mention{
+			Message: m,
+			Unseen:  unseen[msgRefs[i]],
+		})
+	}
+	return list, refs, nil
+}
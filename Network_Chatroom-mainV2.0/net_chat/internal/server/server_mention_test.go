@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"reflect"
+	"testing"
+)
+
+// 只认识固定几个用户的认证后端
+type fakeUsers map[string]bool
+
+func (f fakeUsers) Authenticate(username, password string) error { return nil }
+
+func (f fakeUsers) UserExists(username string) (bool, error) { return f[username], nil }
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"hello", nil},
+		{"@bob 你好", []string{"bob"}},
+		{"@bob,@carol。@dave！", []string{"bob", "carol", "dave"}},
+		{"(@bob) 和「@carol」", []string{"bob", "carol"}},
+		{"@bob @bob @alice", []string{"bob"}},
+		{"mail@bob", []string{"bob"}},
+		{"@guest-1 @@ @", nil},
+		{"@a @b @c @d @e @f @g @h @i @j @k", []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}},
+	}
+	for _, tt := range tests {
+		if got := parseMentions(tt.content, "alice"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMentions(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestLiveMentionKeepsOlderUnseen(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	s.SetAuthenticator(fakeUsers{"bob": true})
+
+	//bob离线时被提到一次
+	id, _ := redis.AddRoomMessage(mainRoom, "alice", "@bob 在吗", "", testNow.Unix())
+	s.notifyMentions(mainRoom, protocol.ChatMessage{ID: id, Sender: "alice", Content: "@bob 在吗", Room: mainRoom})
+
+	//上线后又被提到，实时收到的这条不影响之前那条
+	bob := newTestConn(t, "bob")
+	s.AddUser("bob", bob)
+	live, _ := redis.AddRoomMessage(mainRoom, "alice", "@bob @nobody", "", testNow.Unix())
+	s.notifyMentions(mainRoom, protocol.ChatMessage{ID: live, Sender: "alice", Content: "@bob @nobody", Room: mainRoom})
+	expectMsg(t, bob, "mention")
+	if n, _ := redis.CountUnseenMentions("bob"); n != 1 {
+		t.Fatalf("unseen = %d, want 1", n)
+	}
+	if n, _ := redis.CountUnseenMentions("nobody"); n != 0 {
+		t.Fatal("mention stored for unknown user")
+	}
+
+	s.HandleMentions(&protocol.Message{Type: "mentions"}, bob)
+	var list protocol.MentionList
+	if err := protocol.DecodeContent(expectMsg(t, bob, "mentions").Content, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Mentions) != 2 || list.Unseen != 1 {
+		t.Fatalf("list = %+v", list)
+	}
+	if list.Mentions[0].Message.ID != live || list.Mentions[0].Unseen || !list.Mentions[1].Unseen {
+		t.Fatalf("unseen flags = %+v", list.Mentions)
+	}
+	if n, _ := redis.CountUnseenMentions("bob"); n != 0 {
+		t.Fatalf("unseen after listing = %d", n)
+	}
+}
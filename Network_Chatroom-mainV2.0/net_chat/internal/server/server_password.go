@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"strings"
+	"time"
+)
+
+// 管理员重置的一次性密码的有效期
+const oneTimePasswordTTL = 24 * time.Hour
+
+// HandleChangePassword 用户修改密码，内容为 旧密码|新密码
+func (s *Server) HandleChangePassword(msg *protocol.Message, c *ClientConn) {
+	if c.Name == "" {
+		return
+	}
+	store, ok := s.accounts()
+	if !ok {
+		c.Outgoing <- &protocol.Message{
+			Type:    "change_password_fail",
+			Content: "当前服务器不支持修改密码",
+			From:    "system",
+		}
+		return
+	}
+	content, _ := msg.Content.(string)
+	parts := strings.Split(content, "|")
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+		c.Outgoing <- &protocol.Message{
+			Type:    "change_password_fail",
+			Content: "格式错误，请使用‘旧密码|新密码’的格式",
+			From:    "system",
+		}
+		return
+	}
+	oldPassword := strings.TrimSpace(parts[0])
+	newPassword := strings.TrimSpace(parts[1])
+
+	//1.必须提供正确的当前密码
+	if err := s.CheckUser(c.Name, oldPassword); err != nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "change_password_fail",
+			Content: "当前密码错误",
+			From:    "system",
+		}
+		return
+	}
+	//2.更新密码并清除缓存
+	if err := store.ChangePassword(c.Name, newPassword, false); err != nil {
+		log.Printf("用户%s修改密码失败:%v", c.Name, err)
+		c.Outgoing <- &protocol.Message{
+			Type:    "change_password_fail",
+			Content: "修改密码失败",
+			From:    "system",
+		}
+		return
+	}
+	c.mustChange = false
+	//3.旧密码签发的令牌全部作废，再给当前连接换发一个新令牌
+	if err := redis.RevokeUserSessions(c.Name); err != nil {
+		log.Printf("吊销用户%s的会话令牌失败:%v", c.Name, err)
+	}
+	result := protocol.LoginResult{Welcome: "密码修改成功"}
+	token, expiresAt, err := redis.CreateSession(c.Name)
+	if err != nil {
+		log.Printf("为用户%s签发会话令牌失败:%v", c.Name, err)
+	} else {
+		result.Token = token
+		result.ExpiresAt = expiresAt.Unix()
+	}
+	c.Token = token
+	s.audit(c.Name, "change_password", c.Name)
+	c.Outgoing <- &protocol.Message{
+		Type:    "change_password_success",
+		Content: result,
+		From:    "system",
+	}
+}
+
+// HandleResetPassword 管理员为用户重置密码，内容为目标用户名
+// 生成一次性密码，用户用它登录后必须先修改密码
+func (s *Server) HandleResetPassword(msg *protocol.Message, c *ClientConn) {
+	if !s.isAdmin(c.Name) {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "只有管理员可以重置密码",
+			From:    "system",
+		}
+		return
+	}
+	store, ok := s.accounts()
+	if !ok {
+		c.Outgoing <- &protocol.Message{
+			Type:    "reset_password_fail",
+			Content: "当前服务器不支持重置密码",
+			From:    "system",
+		}
+		return
+	}
+	target, _ := msg.Content.(string)
+	target = strings.TrimSpace(target)
+
+	otp, err := newOneTimePassword()
+	if err == nil {
+		err = store.ChangePassword(target, otp, true)
+	}
+	if err == nil {
+		err = redis.SetOneTimePassword(target, oneTimePasswordTTL)
+	}
+	if err != nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "reset_password_fail",
+			Content: fmt.Sprintf("重置用户%s的密码失败:%v", target, err),
+			From:    "system",
+		}
+		return
+	}
+
+	//吊销目标用户的所有会话，并把在线的连接踢下线
+	if err := redis.RevokeUserSessions(target); err != nil {
+		log.Printf("吊销用户%s的会话令牌失败:%v", target, err)
+	}
+	if tc := s.GetUser(target); tc != nil {
+		//提示写出后再关闭连接，之后readLoop会负责把用户从列表中删除
+		tc.closeAfterFlush(&protocol.Message{
+			Type:    "notice",
+			Content: "你的密码已被管理员重置，请使用新密码重新登录",
+			From:    "system",
+		})
+	}
+	s.audit(c.Name, "reset_password", target)
+	c.Outgoing <- &protocol.Message{
+		Type:    "reset_password_success",
+		Content: fmt.Sprintf("用户%s的一次性密码为：%s（%d小时内有效，只能登录一次，登录后需立即修改）", target, otp, int(oneTimePasswordTTL.Hours())),
+		From:    "system",
+	}
+}
+
+// 密码（以及两步验证码）校验通过后登录，使用一次性密码时将其消耗，已经用过或过期的不能再登录
+func (s *Server) passwordLoginSuccess(c *ClientConn, username string, result protocol.LoginResult) {
+	if store, ok := s.accounts(); ok && store.MustChangePassword(username) {
+		ok, err := redis.UseOneTimePassword(username)
+		if err != nil {
+			log.Println(err)
+		}
+		if !ok {
+			c.Outgoing <- &protocol.Message{
+				Type:    "login_fail",
+				Content: "一次性密码已失效，请联系管理员重新重置",
+				From:    "system",
+			}
+			return
+		}
+	}
+	s.loginSuccess(c, username, "login_success", result)
+}
+
+// 生成一次性密码
+func newOneTimePassword() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net_chat/internal/protocol"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 内存中的账号后端
+type fakeAccounts struct {
+	passwords  map[string]string
+	verifiers  map[string]string //没有校验值的是还没迁移的老用户
+	mustChange map[string]bool
+}
+
+func newFakeAccounts() *fakeAccounts {
+	return &fakeAccounts{
+		passwords:  make(map[string]string),
+		verifiers:  make(map[string]string),
+		mustChange: make(map[string]bool),
+	}
+}
+
+func (f *fakeAccounts) Authenticate(username, password string) error {
+	if p, ok := f.passwords[username]; !ok || p != password {
+		return errors.New("用户名或密码错误")
+	}
+	//和数据库后端一样，密码登录成功时补上校验值
+	if f.verifiers[username] == "" {
+		v, err := protocol.NewScramVerifier(password)
+		if err != nil {
+			return err
+		}
+		f.verifiers[username] = v
+	}
+	return nil
+}
+
+func (f *fakeAccounts) UserExists(username string) (bool, error) {
+	_, ok := f.passwords[username]
+	return ok, nil
+}
+
+func (f *fakeAccounts) Register(username, password, inviteCode string) error {
+	return f.ChangePassword(username, password, false)
+}
+
+func (f *fakeAccounts) ScramVerifier(username string) (string, error) {
+	if _, ok := f.passwords[username]; !ok {
+		return "", errors.New("用户不存在")
+	}
+	return f.verifiers[username], nil
+}
+
+func (f *fakeAccounts) ChangePassword(username, password string, mustChange bool) error {
+	v, err := protocol.NewScramVerifier(password)
+	if err != nil {
+		return err
+	}
+	f.passwords[username] = password
+	f.verifiers[username] = v
+	f.mustChange[username] = mustChange
+	return nil
+}
+
+func (f *fakeAccounts) MustChangePassword(username string) bool {
+	return f.mustChange[username]
+}
+
+// 重置密码，返回一次性密码
+func resetPassword(t *testing.T, s *Server, admin *ClientConn, target string) string {
+	t.Helper()
+	s.HandleResetPassword(&protocol.Message{Type: "reset_password", Content: target}, admin)
+	reply, _ := expectMsg(t, admin, "reset_password_success").Content.(string)
+	otp := strings.SplitN(strings.SplitN(reply, "：", 2)[1], "（", 2)[0]
+	if otp == "" {
+		t.Fatalf("no password in %q", reply)
+	}
+	return otp
+}
+
+func TestOneTimePasswordSingleUse(t *testing.T) {
+	s, mr, _ := newTestServer(t)
+	store := newFakeAccounts()
+	store.Register("bob", "old", "")
+	s.SetAuthenticator(store)
+	s.SetAdmins([]string{"root"})
+	admin := newTestConn(t, "root")
+
+	otp := resetPassword(t, s, admin, "bob")
+	first := newTestConn(t, "")
+	scramLogin(t, s, first, "bob", otp)
+	expectMsg(t, first, "login_success")
+	expectMsg(t, first, "password_change_required")
+	s.RemoveUser("bob")
+
+	//同一个一次性密码不能再次登录
+	second := newTestConn(t, "")
+	scramLogin(t, s, second, "bob", otp)
+	expectMsg(t, second, "login_fail")
+
+	//过期后也不能登录
+	otp = resetPassword(t, s, admin, "bob")
+	mr.FastForward(oneTimePasswordTTL + time.Second)
+	expired := newTestConn(t, "")
+	scramLogin(t, s, expired, "bob", otp)
+	expectMsg(t, expired, "login_fail")
+}
+
+func TestResetPasswordFlushesNotice(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	store := newFakeAccounts()
+	store.Register("bob", "old", "")
+	s.SetAuthenticator(store)
+	s.SetAdmins([]string{"root"})
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	bob := NewClientConn(local)
+	bob.Name = "bob"
+	s.AddUser("bob", bob)
+	go bob.writeLoop()
+
+	resetPassword(t, s, newTestConn(t, "root"), "bob")
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	r := bufio.NewReader(remote)
+	msg, err := protocol.ReadMsg(r)
+	if err != nil || msg.Type != "notice" {
+		t.Fatalf("first message = %+v, %v", msg, err)
+	}
+	//提示之后连接被关闭
+	if _, err := protocol.ReadMsg(r); err == nil {
+		t.Fatal("connection still open after reset")
+	}
+}
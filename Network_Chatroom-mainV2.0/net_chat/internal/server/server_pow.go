@@ -0,0 +1,106 @@
+package server
+
+import (
+	"log"
+	"math/bits"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// 默认的基础难度，普通电脑不到一秒即可解出
+	defaultPowBits = 18
+	// 每分钟超过这么多次注册（解出挑战）时开始提高难度，之后次数每翻一倍难度加1位
+	powBurstThreshold = 10
+	// 难度上限，避免正常用户也要算很久
+	powMaxDifficulty = 26
+	// 挑战的有效期
+	powChallengeTTL = 2 * time.Minute
+)
+
+// 连接上保存的工作量证明状态
+type powState struct {
+	challenge *protocol.PowChallenge
+	issuedAt  time.Time
+	solved    bool //已解出，可以注册一次
+}
+
+// SetRegisterPow 设置注册时工作量证明的基础难度（前导0位数），0表示关闭，需要在Start之前调用
+func (s *Server) SetRegisterPow(bits int) {
+	s.powBits = bits
+}
+
+// 根据这一分钟内解出挑战的次数计算难度，下发挑战本身不计数
+func (s *Server) powDifficulty() int {
+	count, err := redis.CountRegisterAttempts(s.clock())
+	if err != nil {
+		log.Println(err)
+		return s.powBits
+	}
+	difficulty := s.powBits
+	if count > powBurstThreshold {
+		difficulty += bits.Len64(uint64(count / powBurstThreshold))
+	}
+	if difficulty > powMaxDifficulty {
+		difficulty = powMaxDifficulty
+	}
+	return difficulty
+}
+
+// HandleRegisterChallenge 下发注册用的工作量证明挑战
+func (s *Server) HandleRegisterChallenge(c *ClientConn) {
+	nonce, err := protocol.NewNonce()
+	if err != nil {
+		log.Printf("生成随机数失败:%v", err)
+		return
+	}
+	ch := &protocol.PowChallenge{Nonce: nonce}
+	if s.powBits > 0 {
+		ch.Difficulty = s.powDifficulty()
+	}
+	c.pow = &powState{challenge: ch, issuedAt: s.clock()}
+	c.Outgoing <- &protocol.Message{
+		Type:    "register_challenge",
+		Content: ch,
+		From:    "system",
+	}
+}
+
+// HandleRegisterPow 校验客户端提交的计数器，内容为计数器的十进制字符串
+// 这里不回复，结果在随后的register请求中体现，保证一次注册只有一条回复
+func (s *Server) HandleRegisterPow(msg *protocol.Message, c *ClientConn) {
+	state := c.pow
+	if state == nil || state.challenge == nil {
+		return
+	}
+	//每个挑战只能提交一次
+	ch := state.challenge
+	state.challenge = nil
+	if s.clock().Sub(state.issuedAt) > powChallengeTTL {
+		return
+	}
+	content, _ := msg.Content.(string)
+	counter, err := strconv.ParseUint(strings.TrimSpace(content), 10, 64)
+	if err != nil {
+		return
+	}
+	state.solved = protocol.CheckPow(ch, counter)
+	if state.solved {
+		if err := redis.RecordRegisterAttempt(s.clock()); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// 注册前检查并消耗工作量证明
+func (s *Server) consumePow(c *ClientConn) bool {
+	if s.powBits <= 0 {
+		return true
+	}
+	state := c.pow
+	c.pow = nil
+	return state != nil && state.solved
+}
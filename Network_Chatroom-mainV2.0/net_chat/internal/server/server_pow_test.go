@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"strconv"
+	"testing"
+)
+
+func challenge(t *testing.T, s *Server, c *ClientConn) *protocol.PowChallenge {
+	t.Helper()
+	s.HandleRegisterChallenge(c)
+	var ch protocol.PowChallenge
+	if err := protocol.DecodeContent(expectMsg(t, c, "register_challenge").Content, &ch); err != nil {
+		t.Fatal(err)
+	}
+	return &ch
+}
+
+func TestPowChallengesDoNotRaiseDifficulty(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	s.SetRegisterPow(4)
+	c := newTestConn(t, "")
+	for i := 0; i < 10*powBurstThreshold; i++ {
+		if ch := challenge(t, s, c); ch.Difficulty != 4 {
+			t.Fatalf("challenge %d difficulty = %d, want 4", i, ch.Difficulty)
+		}
+	}
+}
+
+func TestPowSolvedChallengesRaiseDifficulty(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	s.SetRegisterPow(4)
+	c := newTestConn(t, "")
+	for i := 0; i <= powBurstThreshold; i++ {
+		ch := challenge(t, s, c)
+		counter := protocol.SolvePow(ch)
+		s.HandleRegisterPow(&protocol.Message{Type: "register_pow", Content: strconv.FormatUint(counter, 10)}, c)
+		if !c.pow.solved {
+			t.Fatalf("solution %d rejected", i)
+		}
+	}
+	if ch := challenge(t, s, c); ch.Difficulty != 5 {
+		t.Fatalf("difficulty after burst = %d, want 5", ch.Difficulty)
+	}
+}
+
+func TestPowDifficultyCapped(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	s.SetRegisterPow(18)
+	if got := s.powDifficulty(); got != 18 {
+		t.Fatalf("base difficulty = %d", got)
+	}
+	for i := 0; i < 600*powBurstThreshold; i++ {
+		if err := redis.RecordRegisterAttempt(s.clock()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := s.powDifficulty(); got != powMaxDifficulty {
+		t.Fatalf("difficulty = %d, want cap %d", got, powMaxDifficulty)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 表情最多的字符数，组合表情（如带肤色的手势）由多个字符组成
+const maxEmojiRunes = 8
+
+// 检查表情：不能为空、不能太长、不能包含空白和控制字符
+func validEmoji(emoji string) bool {
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxEmojiRunes || strings.Contains(emoji, "|") {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleReaction 对消息添加（add为true）或取消表情，成功后通知能看到该消息的在线用户
+func (s *Server) HandleReaction(msg *protocol.Message, c *ClientConn, add bool) {
+	var req protocol.ReactionRequest
+	if err := protocol.DecodeContent(msg.Content, &req); err != nil || strings.TrimSpace(req.ID) == "" {
+		sendError(c, "无效的请求，需要指定消息ID")
+		return
+	}
+	req.ID, req.Emoji = strings.TrimSpace(req.ID), strings.TrimSpace(req.Emoji)
+	if !validEmoji(req.Emoji) {
+		sendError(c, "无效的表情")
+		return
+	}
+	scope, err := s.resolveScope(c, req.Room, req.Peer)
+	if err != nil {
+		sendError(c, err.Error())
+		return
+	}
+	target, err := s.findMessage(scope, req.ID)
+	if err != nil {
+		log.Printf("查找消息%s|%s失败:%v", scope, req.ID, err)
+		sendError(c, "查找消息失败")
+		return
+	}
+	if target == nil {
+		sendError(c, "消息不存在")
+		return
+	}
+	//已撤回的消息不能再添加表情，取消不受影响
+	if add {
+		msgs := []protocol.ChatMessage{*target}
+		if err := applyEdits(msgs); err != nil {
+			log.Printf("读取消息%s|%s的修改记录失败:%v", scope, req.ID, err)
+			sendError(c, "查找消息失败")
+			return
+		}
+		if msgs[0].Deleted {
+			sendError(c, "该消息已撤回")
+			return
+		}
+	}
+
+	changed, err := redis.React(scope, req.ID, c.Name, req.Emoji, add)
+	if errors.Is(err, redis.ErrTooManyReactions) {
+		sendError(c, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("保存%s对消息%s|%s的表情失败:%v", c.Name, scope, req.ID, err)
+		sendError(c, "操作失败，请稍后重试")
+		return
+	}
+	if !changed {
+		if add {
+			sendError(c, "你已经添加过该表情")
+		} else {
+			sendError(c, "你没有添加过该表情")
+		}
+		return
+	}
+
+	update := protocol.ReactionUpdate{
+		ID:    req.ID,
+		User:  c.Name,
+		Emoji: req.Emoji,
+		Added: add,
+	}
+	update.Room, update.Conversation = scopeFields(scope)
+	reactions, err := redis.GetReactions(scope, []string{req.ID})
+	if err != nil {
+		log.Printf("读取消息%s|%s的表情失败:%v", scope, req.ID, err)
+	}
+	update.Reactions = reactionsToProtocol(reactions[req.ID])
+	notice := &protocol.Message{
+		Type:    "reaction_update",
+		Content: update,
+		From:    c.Name,
+		ID:      req.ID,
+	}
+	if update.Room != "" {
+		s.BroadcastRoom(update.Room, notice)
+		return
+	}
+	c.Outgoing <- notice
+	if peer := s.GetUser(req.Peer); peer != nil {
+		peer.Outgoing <- notice
+	}
+}
+
+// 为消息补上表情回应
+func attachReactions(msgs []protocol.ChatMessage) error {
+	ids := make(map[string][]string)
+	for _, m := range msgs {
+		scope := messageScope(m)
+		ids[scope] = append(ids[scope], m.ID)
+	}
+	reactions := make(map[string]map[string][]redis.Reaction, len(ids))
+	for scope, list := range ids {
+		r, err := redis.GetReactions(scope, list)
+		if err != nil {
+			return err
+		}
+		reactions[scope] = r
+	}
+	for i := range msgs {
+		msgs[i].Reactions = reactionsToProtocol(reactions[messageScope(msgs[i])][msgs[i].ID])
+	}
+	return nil
+}
+
+func reactionsToProtocol(list []redis.Reaction) []protocol.Reaction {
+	if len(list) == 0 {
+		return nil
+	}
+	out := make([]protocol.Reaction, 0, len(list))
+	for _, r := range list {
+		out = append(out, protocol.Reaction{Emoji: r.Emoji, Count: r.Count, Users: r.Users})
+	}
+	return out
+}
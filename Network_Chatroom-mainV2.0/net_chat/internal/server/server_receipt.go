@@ -0,0 +1,93 @@
+package server
+
+import (
+	"log"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+)
+
+//私聊回执
+//接收方客户端收到私聊后回复 pm_delivered，用户看到后回复 pm_read，消息的To为原发送者，ID为消息ID
+//服务端持久化位置后给原发送者推送 pm_status，Content为 delivered 或 read
+
+const (
+	receiptDelivered = "delivered"
+	receiptRead      = "read"
+)
+
+// HandleReceipt 处理接收方的送达/已读确认
+func (s *Server) HandleReceipt(msg *protocol.Message, c *ClientConn) {
+	sender := msg.To
+	ok, err := redis.IsPrivateMessageFrom(c.Name, sender, msg.ID)
+	if err != nil {
+		log.Printf("校验私聊回执失败:%v", err)
+		return
+	}
+	if !ok {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "无效的消息回执",
+			From:    "system",
+		}
+		return
+	}
+	if msg.Type == "pm_read" {
+		s.markPrivateRead(c.Name, sender, msg.ID)
+		return
+	}
+	//客户端确认收到后才前移投递位置，下次登录从这里之后重发
+	advanced, err := redis.MarkPrivateAcked(c.Name, sender, msg.ID)
+	if err != nil {
+		log.Printf("保存私聊送达位置失败:%v", err)
+		return
+	}
+	if advanced {
+		s.notifyReceipt(sender, c.Name, receiptDelivered, msg.ID)
+	}
+}
+
+// 记录reader已读sender截至id的消息，并通知sender
+func (s *Server) markPrivateRead(reader, sender, id string) {
+	advanced, err := redis.MarkPrivateRead(reader, sender, id)
+	if err != nil {
+		log.Printf("保存私聊已读位置失败:%v", err)
+		return
+	}
+	if advanced {
+		s.notifyReceipt(sender, reader, receiptRead, id)
+	}
+}
+
+// 发送者在线时推送回执，不在线时等其下次打开私聊再补发
+func (s *Server) notifyReceipt(sender, reader, status, id string) {
+	if u := s.GetUser(sender); u != nil {
+		u.Outgoing <- &protocol.Message{
+			Type:    "pm_status",
+			Content: status,
+			From:    reader,
+			ID:      id,
+		}
+	}
+}
+
+// 打开与peer的私聊时：把对方发来的消息标记为已读，并补发自己所发消息的回执
+func (s *Server) syncPrivateReceipts(c *ClientConn, peer string) {
+	last, err := redis.LastPrivateMessageFrom(c.Name, peer)
+	if err != nil {
+		log.Printf("查询私聊消息失败:%v", err)
+	} else if last != "" {
+		s.markPrivateRead(c.Name, peer, last)
+	}
+
+	delivered, read, err := redis.GetPrivateReceipts(c.Name, peer)
+	if err != nil {
+		log.Printf("查询私聊回执失败:%v", err)
+		return
+	}
+	if delivered != "" && delivered != read {
+		c.Outgoing <- &protocol.Message{Type: "pm_status", Content: receiptDelivered, From: peer, ID: delivered}
+	}
+	if read != "" {
+		c.Outgoing <- &protocol.Message{Type: "pm_status", Content: receiptRead, From: peer, ID: read}
+	}
+}
@@ -1,15 +1,25 @@
 package server
 
 import (
+	"fmt"
 	"log"
-	"net_chat/internal/database"
 	"net_chat/internal/protocol"
 	"strings"
 )
 
 // HandleRegister 处理注册消息
 func (s *Server) HandleRegister(msg *protocol.Message, c *ClientConn) {
-	err, username := s.RegisterUser(msg.Content.(string))
+	//先完成工作量证明才能注册，限制批量注册
+	if !s.consumePow(c) {
+		c.Outgoing <- &protocol.Message{
+			Type:    "register_fail",
+			Content: "工作量证明无效或已过期，请重试",
+			From:    "system",
+		}
+		return
+	}
+	content, _ := msg.Content.(string)
+	err, username := s.RegisterUser(content)
 	if err == nil {
 		c.Outgoing <- &protocol.Message{
 			Type:    "register_success",
@@ -28,20 +38,39 @@ func (s *Server) HandleRegister(msg *protocol.Message, c *ClientConn) {
 
 // RegisterUser 用户注册
 func (s *Server) RegisterUser(cotent string) (error, string) {
-	//验证逻辑放在这里，内容为用户名|密码
+	//验证逻辑放在这里，内容为用户名|密码，只邀请注册模式下为用户名|密码|邀请码
 	userinfo := strings.Split(cotent, "|")
+	if len(userinfo) < 2 || len(userinfo) > 3 {
+		return fmt.Errorf("格式错误"), ""
+	}
 	username := strings.TrimSpace(userinfo[0])
 	password := strings.TrimSpace(userinfo[1])
-	err := database.RegisterUser(username, password)
+	var inviteCode string
+	if s.inviteOnly {
+		if len(userinfo) == 3 {
+			inviteCode = strings.ToUpper(strings.TrimSpace(userinfo[2]))
+		}
+		if inviteCode == "" {
+			return fmt.Errorf("注册需要邀请码"), ""
+		}
+	}
+	if isGuest(username) {
+		return fmt.Errorf("不能以%s开头", guestPrefix), ""
+	}
+	store, ok := s.accounts()
+	if !ok {
+		return fmt.Errorf("当前服务器不开放注册"), ""
+	}
+	err := store.Register(username, password, inviteCode)
 	if err != nil {
 		return err, ""
 	}
 	return nil, username
 }
 
-// CheckUser 检查用户是否在数据库中以及账号密码的正确性
+// CheckUser 检查用户是否存在以及账号密码的正确性
 func (s *Server) CheckUser(username, password string) error {
-	err := database.AuthenticateUser(username, password)
+	err := s.auth.Authenticate(username, password)
 	if err != nil {
 		log.Printf("在检测用户的账号密码时发生错误: %v", err)
 		return err
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"log"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+)
+
+// HandleResume 客户端断线重连后凭会话令牌恢复登录，不需要再次发送密码
+func (s *Server) HandleResume(msg *protocol.Message, c *ClientConn) {
+	//已经登录的连接不能再恢复其他账号的会话
+	if c.Name != "" {
+		return
+	}
+	token, _ := msg.Content.(string)
+	username, err := redis.GetSessionUser(token)
+	if err != nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "resume_fail",
+			Content: err.Error(),
+			From:    "system",
+		}
+		return
+	}
+	//旧连接可能还没被服务端发现断开，此时让客户端稍后重试
+	if s.GetUser(username) != nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "resume_fail",
+			Content: "用户在线中",
+			From:    "system",
+		}
+		return
+	}
+	//令牌只使用一次，恢复成功后换发新的令牌，失败时旧令牌仍可以再试
+	if !s.loginSuccess(c, username, "resume_success", protocol.LoginResult{}) {
+		return
+	}
+	if err := redis.RevokeSession(token); err != nil {
+		log.Printf("吊销用户%s的旧会话令牌失败:%v", username, err)
+	}
+}
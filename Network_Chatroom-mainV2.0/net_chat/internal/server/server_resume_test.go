@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"testing"
+)
+
+func TestResumeRevokesTokenOnlyAfterSuccess(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	s.SetAuthenticator(newFakeAccounts())
+	token, _, err := redis.CreateSession("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddUser("bob", newTestConn(t, "bob"))
+
+	//旧连接还在时恢复失败，令牌仍然有效
+	retry := newTestConn(t, "")
+	s.HandleResume(&protocol.Message{Type: "resume", Content: token}, retry)
+	expectMsg(t, retry, "resume_fail")
+	if user, err := redis.GetSessionUser(token); err != nil || user != "bob" {
+		t.Fatalf("token revoked by failed resume: %q, %v", user, err)
+	}
+
+	s.RemoveUser("bob")
+	ok := newTestConn(t, "")
+	s.HandleResume(&protocol.Message{Type: "resume", Content: token}, ok)
+	expectMsg(t, ok, "resume_success")
+	if _, err := redis.GetSessionUser(token); err == nil {
+		t.Fatal("token still valid after resume")
+	}
+}
+
+func TestLoggedInConnCannotSwitchAccount(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	store := newFakeAccounts()
+	store.Register("bob", "pencil", "")
+	s.SetAuthenticator(store)
+	token, _, err := redis.CreateSession("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := newTestConn(t, "alice")
+	s.AddUser("alice", alice)
+
+	for _, msg := range []*protocol.Message{
+		{Type: "resume", Content: token},
+		{Type: "login", Content: "bob|pencil"},
+		{Type: "login_start", Content: protocol.ScramStart{Username: "bob", ClientNonce: "n"}},
+		{Type: "login_proof", Content: protocol.ScramProof{Proof: "x"}},
+		{Type: "login_totp", Content: "000000"},
+	} {
+		s.Dispatch(msg, alice)
+		if msgs := drainMsgs(alice); len(msgs) != 0 {
+			t.Errorf("%s while logged in got %q", msg.Type, msgs[0].Type)
+		}
+	}
+	if alice.Name != "alice" || s.GetUser("bob") != nil {
+		t.Fatalf("connection switched account: name %q, bob online %v", alice.Name, s.GetUser("bob") != nil)
+	}
+	if _, err := redis.GetSessionUser(token); err != nil {
+		t.Fatal("token consumed by rejected resume")
+	}
+}
+
+func TestNonStringCredentialsDoNotPanic(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	s.SetAuthenticator(newFakeAccounts())
+	for _, tt := range []struct{ msgType, reply string }{
+		{"login", "login_fail"},
+		{"register", "register_fail"},
+	} {
+		for _, content := range []interface{}{nil, 1.0, map[string]interface{}{"a": 1}} {
+			c := newTestConn(t, "")
+			c.pow = &powState{solved: true}
+			s.Dispatch(&protocol.Message{Type: tt.msgType, Content: content}, c)
+			expectMsg(t, c, tt.reply)
+		}
+	}
+}
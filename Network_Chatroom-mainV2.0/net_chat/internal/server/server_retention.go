@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"log"
+	"net_chat/internal/database/redis"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SetRetention 设置消息的保留策略，interval大于0时服务端启动后定期清理全部stream
+// 需要在Start之前调用
+func (s *Server) SetRetention(policy redis.RetentionPolicy, interval time.Duration) {
+	redis.SetRetention(policy)
+	if policy.Enabled() {
+		s.retentionInterval = interval
+	}
+}
+
+// 定期按保留策略清理，写入时只会检查被写入的stream，不再活跃的聊天靠这里清理
+func (s *Server) retentionJanitor() {
+	ticker := time.NewTicker(s.retentionInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := redis.TrimAllStreams(s.clock())
+		if err != nil {
+			log.Printf("定期清理消息失败:%v", err)
+		}
+		if n > 0 {
+			log.Printf("定期清理删除了%d条过期消息", n)
+		}
+	}
+}
+
+// NewFileArchiver 返回把消息追加到dir下JSON Lines文件的归档函数，每个stream一个文件
+func NewFileArchiver(dir string) (func(stream string, msgs []goredis.XMessage) error, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("创建归档目录失败:%w", err)
+	}
+	var mu sync.Mutex
+	return func(stream string, msgs []goredis.XMessage) error {
+		//stream:room:main_room -> room_main_room.jsonl
+		name := strings.ReplaceAll(strings.TrimPrefix(stream, "stream:"), ":", "_") + ".jsonl"
+		mu.Lock()
+		defer mu.Unlock()
+		f, err := os.OpenFile(filepath.Join(dir, filepath.Base(name)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		for _, msg := range msgs {
+			if err := enc.Encode(chatMessageFromStream(msg)); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		return f.Close()
+	}, nil
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net_chat/internal/protocol"
+	"strings"
+)
+
+// 一次挑战-应答登录进行中的状态，保存在连接上
+type scramState struct {
+	username string
+	authMsg  string
+	verifier *protocol.ScramVerifier //用户不存在时为nil，应答必然失败
+}
+
+// HandleLoginStart 挑战-应答登录第一步：根据用户名下发盐值和随机数
+func (s *Server) HandleLoginStart(msg *protocol.Message, c *ClientConn) {
+	if c.Name != "" {
+		return
+	}
+	var start protocol.ScramStart
+	if err := protocol.DecodeContent(msg.Content, &start); err != nil || start.Username == "" || start.ClientNonce == "" {
+		c.Outgoing <- &protocol.Message{
+			Type:    "login_fail",
+			Content: "登录请求格式错误",
+			From:    "system",
+		}
+		return
+	}
+	username := strings.TrimSpace(start.Username)
+	if s.GetUser(username) != nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "login_fail",
+			Content: "用户在线中",
+			From:    "system",
+		}
+		return
+	}
+
+	//没有完整账号体系的后端（htpasswd、机器人令牌）只能使用密码登录
+	store, ok := s.accounts()
+	if !ok {
+		c.Outgoing <- &protocol.Message{
+			Type:    "login_legacy",
+			Content: "当前服务器只支持密码登录",
+			From:    "system",
+		}
+		return
+	}
+
+	state := &scramState{username: username}
+	var salt []byte
+	iterations := protocol.ScramIterations
+	//老用户还没有校验值时和用户不存在一样处理，这类账号需要客户端明确选择用密码登录一次，成功时补上校验值
+	if stored, err := store.ScramVerifier(username); err == nil && stored != "" {
+		v, err := protocol.ParseScramVerifier(stored)
+		if err != nil {
+			log.Printf("解析用户%s的校验值失败:%v", username, err)
+		} else {
+			state.verifier = v
+			salt = v.Salt
+			iterations = v.Iterations
+		}
+	}
+	//没有校验值时返回一个由用户名确定的假盐值，避免通过挑战探测用户是否存在、是否已经迁移
+	if state.verifier == nil {
+		h := hmac.New(sha256.New, s.scramSecret)
+		h.Write([]byte(username))
+		salt = h.Sum(nil)[:16]
+	}
+
+	serverNonce := make([]byte, 18)
+	if _, err := rand.Read(serverNonce); err != nil {
+		log.Printf("生成随机数失败:%v", err)
+		return
+	}
+	challenge := protocol.ScramChallenge{
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Iterations: iterations,
+		Nonce:      start.ClientNonce + base64.RawURLEncoding.EncodeToString(serverNonce),
+	}
+	state.authMsg = protocol.ScramAuthMessage(username, start.ClientNonce, &challenge)
+	c.scram = state
+	c.Outgoing <- &protocol.Message{
+		Type:    "login_challenge",
+		Content: challenge,
+		From:    "system",
+	}
+}
+
+// HandleLoginProof 挑战-应答登录第二步：校验客户端的证明
+func (s *Server) HandleLoginProof(msg *protocol.Message, c *ClientConn) {
+	if c.Name != "" {
+		return
+	}
+	state := c.scram
+	//每个挑战只能应答一次
+	c.scram = nil
+	var proof protocol.ScramProof
+	if state == nil || protocol.DecodeContent(msg.Content, &proof) != nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "login_fail",
+			Content: "请先发起登录",
+			From:    "system",
+		}
+		return
+	}
+	var serverSig string
+	ok := false
+	if state.verifier != nil {
+		serverSig, ok = state.verifier.Verify(state.authMsg, proof.Proof)
+	}
+	if !ok {
+		sendLoginFail(c)
+		return
+	}
+	s.completeLogin(c, state.username, protocol.LoginResult{ServerSignature: serverSig})
+}
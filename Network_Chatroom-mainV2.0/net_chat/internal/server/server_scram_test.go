@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net_chat/internal/protocol"
+	"testing"
+)
+
+// 发起挑战-应答登录，返回服务端的挑战
+func scramStart(t *testing.T, s *Server, c *ClientConn, username, nonce string) protocol.ScramChallenge {
+	t.Helper()
+	s.HandleLoginStart(&protocol.Message{Type: "login_start", Content: protocol.ScramStart{Username: username, ClientNonce: nonce}}, c)
+	var ch protocol.ScramChallenge
+	if err := protocol.DecodeContent(expectMsg(t, c, "login_challenge").Content, &ch); err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+// 完成一次挑战-应答登录，结果留在c.Outgoing中
+func scramLogin(t *testing.T, s *Server, c *ClientConn, username, password string) {
+	t.Helper()
+	ch := scramStart(t, s, c, username, "nonce")
+	proof, _, err := protocol.ScramClientProof(username, password, "nonce", &ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.HandleLoginProof(&protocol.Message{Type: "login_proof", Content: protocol.ScramProof{Proof: proof}}, c)
+}
+
+func TestScramLogin(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	store := newFakeAccounts()
+	store.Register("alice", "pencil", "")
+	s.SetAuthenticator(store)
+
+	c := newTestConn(t, "")
+	ch := scramStart(t, s, c, "alice", "n1")
+	proof, serverSig, err := protocol.ScramClientProof("alice", "pencil", "n1", &ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.HandleLoginProof(&protocol.Message{Type: "login_proof", Content: protocol.ScramProof{Proof: proof}}, c)
+	var result protocol.LoginResult
+	if err := protocol.DecodeContent(expectMsg(t, c, "login_success").Content, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ServerSignature != serverSig {
+		t.Fatal("server signature mismatch")
+	}
+}
+
+func TestScramUnmigratedLooksLikeUnknownUser(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	store := newFakeAccounts()
+	store.Register("bob", "pencil", "")
+	delete(store.verifiers, "bob")
+	s.SetAuthenticator(store)
+
+	//还没迁移的老用户和不存在的用户都拿到挑战，且每次的盐值不变
+	c := newTestConn(t, "")
+	legacy := scramStart(t, s, c, "bob", "n1")
+	again := scramStart(t, s, c, "bob", "n2")
+	unknown := scramStart(t, s, c, "nobody", "n3")
+	if legacy.Salt != again.Salt || legacy.Iterations != unknown.Iterations || legacy.Salt == unknown.Salt {
+		t.Fatalf("unexpected challenges %+v %+v %+v", legacy, again, unknown)
+	}
+
+	c = newTestConn(t, "")
+	ch := scramStart(t, s, c, "bob", "n4")
+	proof, _, _ := protocol.ScramClientProof("bob", "pencil", "n4", &ch)
+	s.HandleLoginProof(&protocol.Message{Type: "login_proof", Content: protocol.ScramProof{Proof: proof}}, c)
+	expectMsg(t, c, "login_fail")
+
+	//客户端退回密码登录，成功后补上校验值，下次可以用挑战-应答登录
+	s.HandleLogin(&protocol.Message{Type: "login", Content: "bob|pencil"}, c)
+	expectMsg(t, c, "login_success")
+	s.RemoveUser("bob")
+	c = newTestConn(t, "")
+	ch = scramStart(t, s, c, "bob", "n5")
+	proof, _, _ = protocol.ScramClientProof("bob", "pencil", "n5", &ch)
+	s.HandleLoginProof(&protocol.Message{Type: "login_proof", Content: protocol.ScramProof{Proof: proof}}, c)
+	expectMsg(t, c, "login_success")
+}
+
+func TestPlaintextLoginRejectedAfterMigration(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	store := newFakeAccounts()
+	store.Register("alice", "pencil", "")
+	s.SetAuthenticator(store)
+
+	//已有校验值的账号、不存在的账号和密码错误得到相同的回复
+	var replies []interface{}
+	for _, content := range []string{"alice|pencil", "nobody|pencil", "alice|wrong"} {
+		c := newTestConn(t, "")
+		s.HandleLogin(&protocol.Message{Type: "login", Content: content}, c)
+		replies = append(replies, expectMsg(t, c, "login_fail").Content)
+	}
+	if replies[0] != replies[1] || replies[1] != replies[2] {
+		t.Fatalf("plaintext login replies differ: %v", replies)
+	}
+	c := newTestConn(t, "")
+	scramLogin(t, s, c, "alice", "pencil")
+	expectMsg(t, c, "login_success")
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"strings"
+)
+
+// 搜索结果默认条数和最大条数
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+// HandleSearch 在请求者能看到的聊天室和私聊中搜索消息
+func (s *Server) HandleSearch(msg *protocol.Message, c *ClientConn) {
+	var q protocol.SearchQuery
+	if err := protocol.DecodeContent(msg.Content, &q); err != nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "search_fail",
+			Content: "无效的搜索条件",
+			From:    "system",
+		}
+		return
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultSearchLimit
+	}
+	if q.Limit > maxSearchLimit {
+		q.Limit = maxSearchLimit
+	}
+	opts := redis.SearchOptions{
+		Keyword: strings.TrimSpace(q.Keyword),
+		Sender:  strings.TrimSpace(q.Sender),
+		From:    q.From,
+		To:      q.To,
+		Limit:   q.Limit,
+	}
+	//只能搜索自己能进入的聊天室和自己参与的私聊
+	allow := func(room, conversation string) bool {
+		if room != "" {
+			return s.canAccessRoom(c, room)
+		}
+		users := strings.SplitN(conversation, ":", 2)
+		return len(users) == 2 && (users[0] == c.Name || users[1] == c.Name)
+	}
+	hits, hasMore, err := redis.Search(opts, allow)
+	if err != nil {
+		log.Printf("用户%s搜索失败:%v", c.Name, err)
+		c.Outgoing <- &protocol.Message{
+			Type:    "search_fail",
+			Content: err.Error(),
+			From:    "system",
+		}
+		return
+	}
+	result := protocol.SearchResult{Messages: make([]protocol.ChatMessage, 0, len(hits)), HasMore: hasMore}
+	for _, hit := range hits {
+		cm := chatMessageFromStream(hit.Message)
+		cm.Room, cm.Conversation = hit.Room, hit.Conversation
+		result.Messages = append(result.Messages, cm)
+	}
+	if err := s.decorateMessages(result.Messages); err != nil {
+		log.Printf("读取搜索结果的附加信息失败:%v", err)
+	}
+	c.Outgoing <- &protocol.Message{
+		Type:    "search_result",
+		Content: result,
+		From:    "system",
+	}
+}
+
+// HandleSearchReindex 管理员从现有的stream重建搜索索引，完成后通知
+func (s *Server) HandleSearchReindex(c *ClientConn) {
+	if !s.isAdmin(c.Name) {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "只有管理员可以重建搜索索引",
+			From:    "system",
+		}
+		return
+	}
+	s.audit(c.Name, "search_reindex", "all")
+	c.Outgoing <- &protocol.Message{
+		Type:    "notice",
+		Content: "开始重建搜索索引",
+		From:    "system",
+	}
+	go func() {
+		count, err := redis.RebuildSearchIndex()
+		if err != nil {
+			log.Printf("重建搜索索引失败:%v", err)
+			c.send(&protocol.Message{
+				Type:    "error",
+				Content: fmt.Sprintf("重建搜索索引失败，已处理%d条消息", count),
+				From:    "system",
+			})
+			return
+		}
+		c.send(&protocol.Message{
+			Type:    "notice",
+			Content: fmt.Sprintf("搜索索引重建完成，共%d条消息", count),
+			From:    "system",
+		})
+	}()
+}
@@ -2,117 +2,260 @@ package server
 
 import (
 	"fmt"
+	goredis "github.com/go-redis/redis/v8"
 	"log"
 	"net_chat/internal/database/redis"
 	"net_chat/internal/protocol"
-	"time"
+	"sort"
+	"strconv"
+	"strings"
 )
 
-// 发送未读消息提醒
+// 投递离线期间收到的私聊消息，以及上次在线时发出但客户端没有确认的消息
+// 按发送者逐个投递，每个发送者的消息按时间顺序；客户端确认后才前移投递位置，没有确认的消息下次登录时重发
 func (s *Server) sendUnreadMessages(c *ClientConn, username string) {
 	//获取未读消息数
 	unread, err := redis.GetUnreadForUser(username)
 	if err != nil {
 		log.Printf("获取用户%s未读消息失败%s", username, err)
+		return
+	}
+	pending, err := redis.GetPendingSenders(username)
+	if err != nil {
+		log.Printf("获取用户%s待投递的私聊失败%s", username, err)
+		return
+	}
+	set := make(map[string]bool, len(unread)+len(pending))
+	for sender := range unread {
+		set[sender] = true
+	}
+	for _, sender := range pending {
+		set[sender] = true
+	}
+	if len(set) == 0 {
+		return
 	}
 
-	//发送未读消息数
-	if len(unread) > 0 {
-		var unreadInfo string
-		for sender, count := range unread {
-			unreadInfo = fmt.Sprintf("%s(%s)", sender, count)
+	senders := make([]string, 0, len(set))
+	for sender := range set {
+		senders = append(senders, sender)
+	}
+	sort.Strings(senders)
+
+	batches := make([]protocol.OfflineMessages, 0, len(senders))
+	counts := make([]string, 0, len(senders))
+	for _, sender := range senders {
+		legacy, _ := strconv.ParseInt(unread[sender], 10, 64)
+		msgs, err := redis.GetPendingPrivateMessages(username, sender, legacy)
+		if err != nil {
+			log.Printf("读取%s发给%s的离线消息失败:%v", sender, username, err)
+			continue
+		}
+		if len(msgs) == 0 {
+			//消息都已确认收到，未读计数已经过时
+			if err := redis.ClearUnreadForUser(username, sender); err != nil {
+				log.Printf("清除用户%s的未读计数失败:%v", username, err)
+			}
+			if err := redis.ClearPendingSender(username, sender); err != nil {
+				log.Printf("清除用户%s的待投递记录失败:%v", username, err)
+			}
+			continue
 		}
+		batch := protocol.OfflineMessages{
+			From:     sender,
+			Messages: privateMessagesFromStream(username, sender, msgs),
+		}
+		if err := s.decorateMessages(batch.Messages); err != nil {
+			log.Printf("读取离线消息的附加信息失败:%v", err)
+		}
+		batches = append(batches, batch)
+		counts = append(counts, fmt.Sprintf("%s(%d)", sender, len(msgs)))
+	}
+	if len(batches) == 0 {
+		return
+	}
+
+	//先发送所有发送者的未读数，再逐个投递消息
+	c.Outgoing <- &protocol.Message{
+		Type:    "notice",
+		Content: fmt.Sprintf("您有来自%s的未读私聊消息", strings.Join(counts, "、")),
+		From:    "system",
+	}
+	for _, batch := range batches {
 		c.Outgoing <- &protocol.Message{
-			Type:    "notice",
-			Content: fmt.Sprintf("您有来自%s的未读私聊消息", unreadInfo),
-			From:    "system",
+			Type:    "offline_messages",
+			Content: batch,
+			From:    batch.From,
+		}
+		if err := redis.ClearUnreadForUser(username, batch.From); err != nil {
+			log.Printf("清除用户%s的未读计数失败:%v", username, err)
 		}
 	}
+}
 
+// 历史消息每页默认条数和最大条数
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+// 将客户端的查询条件转换为查询范围，Content为空或不是查询条件时返回最新一页
+func historyRange(content interface{}) redis.HistoryRange {
+	var q protocol.HistoryQuery
+	if _, ok := content.(map[string]interface{}); ok {
+		_ = protocol.DecodeContent(content, &q)
+	}
+	r := redis.HistoryRange{Limit: q.Limit, Before: q.Before, After: q.After, Since: q.Time}
+	if r.Limit <= 0 {
+		r.Limit = defaultHistoryLimit
+	}
+	if r.Limit > maxHistoryLimit {
+		r.Limit = maxHistoryLimit
+	}
+	return r
 }
 
-// 发送最近的聊天消息
-func (s *Server) sendRecentRoomMessages(c *ClientConn) {
-	msgs, err := redis.GetRoomLastNMessage("main_room", 10)
-	if err != nil {
+// 发送聊天室的历史消息
+func (s *Server) sendRecentRoomMessages(c *ClientConn, query interface{}) {
+	if !s.canAccessRoom(c, mainRoom) {
 		c.Outgoing <- &protocol.Message{
 			Type:    "error",
-			Content: "获取历史消息失败",
+			Content: "访客无法查看该聊天室",
 			From:    "system",
 		}
-		log.Fatalf("获取聊天室历史消息失败%v", err)
-
+		return
 	}
-	var result string
-	if len(msgs) == 0 {
-		result = "暂无历史消息"
-	} else {
-		for _, msg := range msgs {
-			sender := msg.Values["sender"].(string)
-			content := msg.Values["content"].(string)
-			var timestamp int64
-			switch t := msg.Values["ts"].(type) {
-			case int64:
-				timestamp = t
-			case string:
-				// 如果是字符串，尝试转换为 int64
-				_, err2 := fmt.Sscanf(t, "%d", &timestamp)
-				if err2 != nil {
-					log.Printf("在转换用户历史消息的时间时发生错误:%s", err2)
-				}
-			}
-			t := time.Unix(timestamp, 0).Format("2006-01-02 15:04:05")
-			result += fmt.Sprintf("[%s] %s: %s\n", t, sender, content)
+	r := historyRange(query)
+	page := protocol.HistoryPage{Room: mainRoom}
+	var err error
+	if page.Messages, page.HasMore, err = s.loadRoomHistory(mainRoom, r); err != nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "获取历史消息失败",
+			From:    "system",
 		}
+		log.Printf("获取聊天室历史消息失败%v", err)
+		return
 	}
-	c.Outgoing <- &protocol.Message{
-		Type:    "recent_room_messages",
-		Content: result,
-		From:    "system",
-	}
+	sendHistoryPage(c, "recent_room_messages", page)
 }
 
 // 发送私聊历史消息
-func (s *Server) sendRecentPrivateMessages(c *ClientConn, userB string) {
-	msgs, err := redis.GetPrivateLastNMessage(c.Name, userB, 10)
-	fmt.Printf("聊天记录长度为%d", len(msgs))
-	if err != nil {
+func (s *Server) sendRecentPrivateMessages(c *ClientConn, userB string, query interface{}) {
+	if userB == "" {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "请指定私聊对象",
+			From:    "system",
+		}
+		return
+	}
+	r := historyRange(query)
+	page := protocol.HistoryPage{Peer: userB}
+	var err error
+	if page.Messages, page.HasMore, err = s.loadPrivateHistory(c.Name, userB, r); err != nil {
 		c.Outgoing <- &protocol.Message{
 			Type:    "error",
 			Content: "获取私聊历史消息失败",
 			From:    "system",
 		}
-		log.Fatalf("获取私聊历史消息失败%v", err)
+		log.Printf("获取私聊历史消息失败%v", err)
+		return
+	}
+	sendHistoryPage(c, "recent_private_messages", page)
+	if err = redis.ClearUnreadForUser(c.Name, userB); err != nil {
+		log.Printf("清除对应用户的离线消息提醒失败:%v", err)
+	}
+}
+
+// 读取一页聊天室历史，Redis中没有的部分从归档中补上
+func (s *Server) loadRoomHistory(room string, r redis.HistoryRange) ([]protocol.ChatMessage, bool, error) {
+	msgs, hasMore, err := redis.GetRoomHistory(room, r)
+	if err != nil {
+		return nil, false, err
+	}
+	page, hasMore, err := s.fillFromArchive(redis.RoomScope(room), r, roomMessagesFromStream(room, msgs), hasMore)
+	if err != nil {
+		return nil, false, err
+	}
+	return page, hasMore, s.decorateMessages(page)
+}
+
+// 读取一页私聊历史，Redis中没有的部分从归档中补上
+func (s *Server) loadPrivateHistory(userA, userB string, r redis.HistoryRange) ([]protocol.ChatMessage, bool, error) {
+	msgs, hasMore, err := redis.GetPrivateHistory(userA, userB, r)
+	if err != nil {
+		return nil, false, err
+	}
+	page, hasMore, err := s.fillFromArchive(redis.PrivateScope(userA, userB), r, privateMessagesFromStream(userA, userB, msgs), hasMore)
+	if err != nil {
+		return nil, false, err
+	}
+	return page, hasMore, s.decorateMessages(page)
+}
 
+// 为消息补上修改和撤回、回复引用、表情回应等保存在stream之外的信息
+func (s *Server) decorateMessages(msgs []protocol.ChatMessage) error {
+	if err := applyEdits(msgs); err != nil {
+		return err
 	}
-	var result string
-	if len(msgs) == 0 {
-		result = "暂无历史消息"
+	if err := s.attachReplies(msgs); err != nil {
+		return err
+	}
+	return attachReactions(msgs)
+}
+
+// 填好翻页位置后发送一页历史消息
+func sendHistoryPage(c *ClientConn, msgType string, page protocol.HistoryPage) {
+	if n := len(page.Messages); n > 0 {
+		page.Before, page.After = page.Messages[0].ID, page.Messages[n-1].ID
 	} else {
-		for _, msg := range msgs {
-			sender := msg.Values["sender"].(string)
-			content := msg.Values["content"].(string)
-			var timestamp int64
-			switch t := msg.Values["ts"].(type) {
-			case int64:
-				timestamp = t
-			case string:
-				// 如果是字符串，尝试转换为 int64
-				_, err2 := fmt.Sscanf(t, "%d", &timestamp)
-				if err2 != nil {
-					log.Printf("在转换用户历史消息的时间时发生错误:%s", err2)
-				}
-			}
-			t := time.Unix(timestamp, 0).Format("2006-01-02 15:04:05")
-			result += fmt.Sprintf("[%s] %s: %s\n", t, sender, content)
-		}
+		page.Messages = []protocol.ChatMessage{}
 	}
 	c.Outgoing <- &protocol.Message{
-		Type:    "recent_private_messages",
-		Content: result,
+		Type:    msgType,
+		Content: page,
 		From:    "system",
 	}
-	if err = redis.ClearUnreadForUser(c.Name, userB); err != nil {
-		log.Fatal("清除对应用户的离线消息提醒失败")
+}
+
+// 将stream中的一条记录转换为聊天消息，聊天室和私聊的历史、离线消息都使用它
+func chatMessageFromStream(msg goredis.XMessage) protocol.ChatMessage {
+	cm := protocol.ChatMessage{ID: msg.ID}
+	cm.Sender, _ = msg.Values["sender"].(string)
+	cm.Content, _ = msg.Values["content"].(string)
+	if parent, _ := msg.Values["reply_to"].(string); parent != "" {
+		cm.ReplyTo = &protocol.ReplyContext{ID: parent}
+	}
+	switch t := msg.Values["ts"].(type) {
+	case int64:
+		cm.Ts = t
+	case string:
+		var err error
+		if cm.Ts, err = strconv.ParseInt(t, 10, 64); err != nil {
+			log.Printf("在转换历史消息%s的时间时发生错误:%s", msg.ID, err)
+		}
+	}
+	return cm
+}
+
+func roomMessagesFromStream(room string, msgs []goredis.XMessage) []protocol.ChatMessage {
+	out := make([]protocol.ChatMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		cm := chatMessageFromStream(msg)
+		cm.Room = room
+		out = append(out, cm)
+	}
+	return out
+}
+
+func privateMessagesFromStream(userA, userB string, msgs []goredis.XMessage) []protocol.ChatMessage {
+	conversation := redis.ConversationID(userA, userB)
+	out := make([]protocol.ChatMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		cm := chatMessageFromStream(msg)
+		cm.Conversation = conversation
+		out = append(out, cm)
 	}
+	return out
 }
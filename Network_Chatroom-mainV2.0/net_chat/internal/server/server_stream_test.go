@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"testing"
+)
+
+// 模拟登录时投递离线私聊，返回投递的消息ID
+func deliverOffline(t *testing.T, s *Server, user string) []string {
+	t.Helper()
+	c := newTestConn(t, user)
+	s.sendUnreadMessages(c, user)
+	var ids []string
+	for _, msg := range drainMsgs(c) {
+		if msg.Type != "offline_messages" {
+			continue
+		}
+		var batch protocol.OfflineMessages
+		if err := protocol.DecodeContent(msg.Content, &batch); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range batch.Messages {
+			ids = append(ids, m.ID)
+		}
+	}
+	return ids
+}
+
+func ack(t *testing.T, s *Server, user, sender, id string) {
+	t.Helper()
+	s.HandleReceipt(&protocol.Message{Type: "pm_delivered", To: sender, ID: id}, newTestConn(t, user))
+}
+
+func TestOfflineMessagesResentUntilAcked(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	first, _ := redis.AddPrivateMessage("alice", "bob", "one", "", testNow.Unix(), false)
+	second, _ := redis.AddPrivateMessage("alice", "bob", "two", "", testNow.Unix(), false)
+
+	if got := deliverOffline(t, s, "bob"); len(got) != 2 || got[0] != first || got[1] != second {
+		t.Fatalf("first login delivered %v", got)
+	}
+	//连接在客户端确认之前断开，下次登录重发
+	if got := deliverOffline(t, s, "bob"); len(got) != 2 {
+		t.Fatalf("unacked messages not resent: %v", got)
+	}
+	ack(t, s, "bob", "alice", first)
+	if got := deliverOffline(t, s, "bob"); len(got) != 1 || got[0] != second {
+		t.Fatalf("after partial ack delivered %v", got)
+	}
+	ack(t, s, "bob", "alice", second)
+	if got := deliverOffline(t, s, "bob"); len(got) != 0 {
+		t.Fatalf("acked messages resent: %v", got)
+	}
+	if senders, _ := redis.GetPendingSenders("bob"); len(senders) != 0 {
+		t.Fatalf("pending senders = %v", senders)
+	}
+}
+
+func TestLiveMessageResentWhenNotAcked(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	//对方在线时的旧消息已经确认
+	old, _ := redis.AddPrivateMessage("alice", "bob", "old", "", testNow.Unix(), true)
+	ack(t, s, "bob", "alice", old)
+
+	//在线投递的消息还没写出连接就断开了
+	id, _ := redis.AddPrivateMessage("alice", "bob", "lost", "", testNow.Unix(), true)
+	if got := deliverOffline(t, s, "bob"); len(got) != 1 || got[0] != id {
+		t.Fatalf("live message not resent: %v", got)
+	}
+	ack(t, s, "bob", "alice", id)
+	if got := deliverOffline(t, s, "bob"); len(got) != 0 {
+		t.Fatalf("acked live message resent: %v", got)
+	}
+}
+
+func TestFirstLiveMessageResentWhenNotAcked(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	//第一次私聊就没有确认，也不会把整段历史当成待投递
+	id, _ := redis.AddPrivateMessage("alice", "bob", "hello", "", testNow.Unix(), true)
+	redis.AddPrivateMessage("bob", "alice", "reply", "", testNow.Unix(), true)
+	if got := deliverOffline(t, s, "bob"); len(got) != 1 || got[0] != id {
+		t.Fatalf("first live message not resent: %v", got)
+	}
+}
+
+func TestHistoryRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		content interface{}
+		want    redis.HistoryRange
+	}{
+		{"no query", nil, redis.HistoryRange{Limit: defaultHistoryLimit}},
+		{"plain text", "20", redis.HistoryRange{Limit: defaultHistoryLimit}},
+		{"limit", map[string]interface{}{"limit": 5}, redis.HistoryRange{Limit: 5}},
+		{"negative limit", map[string]interface{}{"limit": -1}, redis.HistoryRange{Limit: defaultHistoryLimit}},
+		{"limit capped", map[string]interface{}{"limit": 1000}, redis.HistoryRange{Limit: maxHistoryLimit}},
+		{"before", map[string]interface{}{"before": "5-0"}, redis.HistoryRange{Limit: defaultHistoryLimit, Before: "5-0"}},
+		{"after", map[string]interface{}{"after": "5-0", "limit": 3}, redis.HistoryRange{Limit: 3, After: "5-0"}},
+		{"time", map[string]interface{}{"time": 1714564800}, redis.HistoryRange{Limit: defaultHistoryLimit, Since: 1714564800}},
+		{"bad field type", map[string]interface{}{"limit": "many"}, redis.HistoryRange{Limit: defaultHistoryLimit}},
+	}
+	for _, tt := range tests {
+		if got := historyRange(tt.content); got != tt.want {
+			t.Errorf("%s: historyRange = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadReceiptStopsRedelivery(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	id, _ := redis.AddPrivateMessage("alice", "bob", "hi", "", testNow.Unix(), false)
+	if delivered, _, _ := redis.GetPrivateReceipts("alice", "bob"); delivered != "" {
+		t.Fatalf("delivered before any ack: %q", delivered)
+	}
+	//已读回执与送达回执前移同一个位置
+	s.HandleReceipt(&protocol.Message{Type: "pm_read", To: "alice", ID: id}, newTestConn(t, "bob"))
+	if got := deliverOffline(t, s, "bob"); len(got) != 0 {
+		t.Fatalf("read message resent: %v", got)
+	}
+	if delivered, read, _ := redis.GetPrivateReceipts("alice", "bob"); delivered != id || read != id {
+		t.Fatalf("receipts = %q, %q", delivered, read)
+	}
+}
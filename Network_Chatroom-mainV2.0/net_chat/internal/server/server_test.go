@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// 测试用的固定时间
+var testNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// 连接到内存redis、使用固定时钟的服务端，返回的now可以修改当前时间
+func newTestServer(t *testing.T) (*Server, *miniredis.Miniredis, *time.Time) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	if err := redis.InitRedis(mr.Addr(), "", 0); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("127.0.0.1:0")
+	now := testNow
+	s.clock = func() time.Time { return now }
+	return s, mr, &now
+}
+
+// 不经过网络的连接，发给客户端的消息留在Outgoing中
+func newTestConn(t *testing.T, name string) *ClientConn {
+	t.Helper()
+	local, remote := net.Pipe()
+	t.Cleanup(func() { remote.Close() })
+	c := NewClientConn(local)
+	c.Outgoing = make(chan *protocol.Message, 256)
+	c.Name = name
+	return c
+}
+
+// 取出发给客户端的下一条指定类型的消息，跳过其他类型
+func expectMsg(t *testing.T, c *ClientConn, msgType string) *protocol.Message {
+	t.Helper()
+	for {
+		select {
+		case msg := <-c.Outgoing:
+			if msg.Type == msgType {
+				return msg
+			}
+		default:
+			t.Fatalf("没有收到%s消息", msgType)
+			return nil
+		}
+	}
+}
+
+// 清空发给客户端的消息
+func drainMsgs(c *ClientConn) []*protocol.Message {
+	var msgs []*protocol.Message
+	for {
+		select {
+		case msg := <-c.Outgoing:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"strings"
+)
+
+const (
+	replySnippetLen  = 40  //回复中引用的原消息摘要长度（字符数）
+	maxThreadReplies = 200 //一次最多返回的回复数
+)
+
+// 被回复消息的ID，不是回复时为空
+func replyID(reply *protocol.ReplyContext) string {
+	if reply == nil {
+		return ""
+	}
+	return reply.ID
+}
+
+// 取消息开头的一部分作为摘要
+func snippet(content string) string {
+	content = strings.Join(strings.Fields(content), " ")
+	runes := []rune(content)
+	if len(runes) <= replySnippetLen {
+		return content
+	}
+	return string(runes[:replySnippetLen]) + "…"
+}
+
+// 由被回复的消息生成引用，found为false表示消息已经不存在
+func replyContext(id string, parent protocol.ChatMessage, found bool) *protocol.ReplyContext {
+	ctx := &protocol.ReplyContext{ID: id}
+	if !found {
+		ctx.Deleted = true
+		return ctx
+	}
+	ctx.Sender = parent.Sender
+	if parent.Deleted {
+		ctx.Deleted = true
+		return ctx
+	}
+	ctx.Snippet = snippet(parent.Content)
+	return ctx
+}
+
+// 检查被回复的消息在同一个聊天室或私聊中存在且没有撤回，返回引用
+func (s *Server) checkReplyTo(scope, id string) (*protocol.ReplyContext, error) {
+	parent, err := s.findMessage(scope, id)
+	if err != nil {
+		log.Printf("查找被回复的消息%s|%s失败:%v", scope, id, err)
+		return nil, fmt.Errorf("查找被回复的消息失败")
+	}
+	if parent == nil {
+		return nil, fmt.Errorf("被回复的消息不存在")
+	}
+	parents := []protocol.ChatMessage{*parent}
+	if err := applyEdits(parents); err != nil {
+		log.Printf("读取消息%s|%s的修改记录失败:%v", scope, id, err)
+	}
+	if parents[0].Deleted {
+		return nil, fmt.Errorf("被回复的消息已撤回")
+	}
+	return replyContext(id, parents[0], true), nil
+}
+
+// 为回复消息补上被回复消息的发送者和摘要
+func (s *Server) attachReplies(msgs []protocol.ChatMessage) error {
+	ids := make(map[string][]string)
+	for _, m := range msgs {
+		if m.ReplyTo != nil {
+			scope := messageScope(m)
+			ids[scope] = append(ids[scope], m.ReplyTo.ID)
+		}
+	}
+	parents := make(map[string]protocol.ChatMessage)
+	for scope, list := range ids {
+		found, err := s.loadMessages(scope, list)
+		if err != nil {
+			return err
+		}
+		if err := applyEdits(found); err != nil {
+			return err
+		}
+		for _, p := range found {
+			parents[scope+"|"+p.ID] = p
+		}
+	}
+	for i := range msgs {
+		if msgs[i].ReplyTo == nil {
+			continue
+		}
+		id := msgs[i].ReplyTo.ID
+		parent, ok := parents[messageScope(msgs[i])+"|"+id]
+		msgs[i].ReplyTo = replyContext(id, parent, ok)
+	}
+	return nil
+}
+
+// HandleThread 返回一条消息和它的所有回复
+func (s *Server) HandleThread(msg *protocol.Message, c *ClientConn) {
+	var q protocol.ThreadQuery
+	if err := protocol.DecodeContent(msg.Content, &q); err != nil || strings.TrimSpace(q.ID) == "" {
+		sendError(c, "无效的请求，需要指定消息ID")
+		return
+	}
+	q.ID = strings.TrimSpace(q.ID)
+	scope, err := s.resolveScope(c, q.Room, q.Peer)
+	if err != nil {
+		sendError(c, err.Error())
+		return
+	}
+	parent, err := s.findMessage(scope, q.ID)
+	if err == nil && parent == nil {
+		sendError(c, "消息不存在")
+		return
+	}
+	var page protocol.ThreadPage
+	var ids []string
+	if err == nil {
+		ids, page.HasMore, err = redis.GetThreadReplies(scope, q.ID, maxThreadReplies)
+	}
+	if err == nil {
+		page.Replies, err = s.loadMessages(scope, ids)
+	}
+	if err == nil {
+		//Redis中已经删除、又没有归档的回复从索引中去掉
+		if !s.archiveEnabled() && len(page.Replies) < len(ids) {
+			s.pruneThread(scope, q.ID, ids, page.Replies)
+		}
+		all := append([]protocol.ChatMessage{*parent}, page.Replies...)
+		err = s.decorateMessages(all)
+		page.Parent, page.Replies = all[0], all[1:]
+	}
+	if err != nil {
+		log.Printf("读取消息%s|%s的回复失败:%v", scope, q.ID, err)
+		sendError(c, "获取回复失败")
+		return
+	}
+	c.Outgoing <- &protocol.Message{
+		Type:    "thread",
+		Content: page,
+		From:    "system",
+		ID:      q.ID,
+	}
+}
+
+// 从回复索引中去掉找不到的回复
+func (s *Server) pruneThread(scope, parent string, ids []string, found []protocol.ChatMessage) {
+	exists := make(map[string]bool, len(found))
+	for _, m := range found {
+		exists[m.ID] = true
+	}
+	var missing []string
+	for _, id := range ids {
+		if !exists[id] {
+			missing = append(missing, id)
+		}
+	}
+	if err := redis.RemoveThreadReplies(scope, parent, missing); err != nil {
+		log.Printf("清理消息%s|%s的回复索引失败:%v", scope, parent, err)
+	}
+}
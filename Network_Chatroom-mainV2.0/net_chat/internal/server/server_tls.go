@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net_chat/internal/database"
+	"net_chat/internal/protocol"
+	"os"
+	"time"
+)
+
+// TLS握手的超时时间，防止恶意连接一直占着协程
+const tlsHandshakeTimeout = 10 * time.Second
+
+// LoadTLSConfig 加载服务端证书，clientCAFile不为空时校验客户端证书
+// clientAuth: none（不要求客户端证书）、request（有证书就校验）、require（必须提供证书）
+func LoadTLSConfig(certFile, keyFile, clientCAFile, clientAuth string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载服务端证书失败:%w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch clientAuth {
+	case "", "none":
+		return cfg, nil
+	case "request":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("未知的客户端证书模式:%s", clientAuth)
+	}
+	if clientCAFile == "" {
+		return nil, fmt.Errorf("校验客户端证书需要配置CA证书")
+	}
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取客户端CA证书失败:%w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("客户端CA证书格式错误")
+	}
+	cfg.ClientCAs = pool
+	return cfg, nil
+}
+
+// SetTLS 开启TLS，需要在Start之前调用
+func (s *Server) SetTLS(cfg *tls.Config) {
+	s.tlsConfig = cfg
+}
+
+// 证书中可以用来映射用户的标识：SAN中的DNS名、邮箱、URI，以及CN
+func certSubjects(cert *x509.Certificate) []string {
+	var subjects []string
+	subjects = append(subjects, cert.DNSNames...)
+	subjects = append(subjects, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		subjects = append(subjects, u.String())
+	}
+	if cert.Subject.CommonName != "" {
+		subjects = append(subjects, cert.Subject.CommonName)
+	}
+	return subjects
+}
+
+// TLS握手，客户端提供了已登记的证书时直接登录，跳过密码校验
+// 握手失败时返回错误，调用方关闭连接
+func (s *Server) tlsHandshake(c *ClientConn, tc *tls.Conn) error {
+	_ = tc.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	_ = tc.SetDeadline(time.Time{})
+
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil
+	}
+	//证书和用户的对应关系保存在MySQL中
+	if _, ok := s.accounts(); !ok {
+		return nil
+	}
+	username, err := database.GetUserByCertificate(certSubjects(certs[0]))
+	if err != nil {
+		log.Printf("客户端证书%s没有对应的用户:%v", certs[0].Subject, err)
+		c.Outgoing <- &protocol.Message{
+			Type:    "cert_login_fail",
+			Content: "客户端证书没有登记对应的用户，请使用密码登录",
+			From:    "system",
+		}
+		return nil
+	}
+	//证书本身就是凭据，不再需要密码和两步验证
+	s.loginSuccess(c, username, "login_success", protocol.LoginResult{})
+	if c.Name == username {
+		s.audit(username, "cert_login", certs[0].Subject.String())
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"log"
+	"net_chat/internal/database"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"net_chat/internal/totp"
+	"strings"
+	"time"
+)
+
+// 两步验证输错的最大次数，超过后需要重新输入密码
+const maxTOTPAttempts = 3
+
+// 恢复码数量
+const recoveryCodeCount = 8
+
+// 生成的密钥等待确认的时间，过期后需要重新生成
+const totpEnrollTTL = 10 * time.Minute
+
+// 密码已验证、等待两步验证码的登录
+type pendingLogin struct {
+	username string
+	result   protocol.LoginResult
+	attempts int
+}
+
+// completeLogin 密码验证通过后调用：开启了两步验证的用户还需要输入验证码，之后才会加入在线用户列表
+func (s *Server) completeLogin(c *ClientConn, username string, result protocol.LoginResult) {
+	//两步验证的设置保存在MySQL中，其他后端没有这一步
+	store, ok := s.totpStore()
+	if !ok {
+		s.passwordLoginSuccess(c, username, result)
+		return
+	}
+	info, err := store.GetTOTP(username)
+	if err != nil {
+		log.Printf("查询用户%s的两步验证设置失败:%v", username, err)
+		c.Outgoing <- &protocol.Message{
+			Type:    "login_fail",
+			Content: "登录失败，请稍后重试",
+			From:    "system",
+		}
+		return
+	}
+	if !info.Enabled {
+		s.passwordLoginSuccess(c, username, result)
+		return
+	}
+	c.pendingLogin = &pendingLogin{username: username, result: result}
+	c.Outgoing <- &protocol.Message{
+		Type:    "login_totp_required",
+		Content: "请输入验证器中的6位验证码（或恢复码）",
+		From:    "system",
+	}
+}
+
+// HandleLoginTOTP 登录第二步：校验验证码或恢复码
+func (s *Server) HandleLoginTOTP(msg *protocol.Message, c *ClientConn) {
+	if c.Name != "" {
+		return
+	}
+	pending := c.pendingLogin
+	if pending == nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "login_fail",
+			Content: "请先输入用户名和密码",
+			From:    "system",
+		}
+		return
+	}
+	store, ok := s.totpStore()
+	if !ok {
+		return
+	}
+	code, _ := msg.Content.(string)
+	code = strings.TrimSpace(code)
+
+	ok = false
+	if info, err := store.GetTOTP(pending.username); err != nil {
+		log.Printf("查询用户%s的两步验证设置失败:%v", pending.username, err)
+	} else if step, valid := totp.Validate(info.Secret, code, s.clock()); valid {
+		//同一个验证码只能使用一次，防止被截获后重放
+		ok, err = redis.MarkTOTPStepUsed(pending.username, step, totp.Period*(2*totp.Skew+2))
+		if err != nil {
+			log.Println(err)
+		}
+	} else if len(code) != totp.Digits {
+		if ok, err = store.UseRecoveryCode(pending.username, code); err != nil {
+			log.Println(err)
+		} else if ok {
+			s.audit(pending.username, "totp_recovery_used", pending.username)
+		}
+	}
+
+	if !ok {
+		pending.attempts++
+		if pending.attempts >= maxTOTPAttempts {
+			c.pendingLogin = nil
+			c.Outgoing <- &protocol.Message{
+				Type:    "login_fail",
+				Content: "验证码错误次数过多，请重新登录",
+				From:    "system",
+			}
+			return
+		}
+		c.Outgoing <- &protocol.Message{
+			Type:    "login_totp_fail",
+			Content: "验证码错误，请重新输入",
+			From:    "system",
+		}
+		return
+	}
+	c.pendingLogin = nil
+	s.passwordLoginSuccess(c, pending.username, pending.result)
+}
+
+// HandleTOTPEnroll 生成两步验证密钥和恢复码，用户确认验证码后才会开启
+// 已经开启的用户在确认之前继续使用原来的密钥
+func (s *Server) HandleTOTPEnroll(c *ClientConn) {
+	if c.Name == "" {
+		return
+	}
+	if _, ok := s.totpStore(); !ok {
+		c.Outgoing <- &protocol.Message{
+			Type:    "totp_fail",
+			Content: "当前服务器不支持两步验证",
+			From:    "system",
+		}
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	var codes []string
+	if err == nil {
+		codes, err = newRecoveryCodes()
+	}
+	if err == nil {
+		pending := redis.PendingTOTP{Secret: secret, Recovery: make([]string, 0, len(codes))}
+		for _, code := range codes {
+			pending.Recovery = append(pending.Recovery, database.HashRecoveryCode(code))
+		}
+		err = redis.SetPendingTOTP(c.Name, pending, totpEnrollTTL)
+	}
+	if err != nil {
+		log.Printf("为用户%s生成两步验证密钥失败:%v", c.Name, err)
+		c.Outgoing <- &protocol.Message{
+			Type:    "totp_fail",
+			Content: "生成两步验证密钥失败",
+			From:    "system",
+		}
+		return
+	}
+	c.Outgoing <- &protocol.Message{
+		Type: "totp_enroll",
+		Content: protocol.TOTPEnrollment{
+			URI:           totp.URI("net_chat", c.Name, secret),
+			Secret:        secret,
+			RecoveryCodes: codes,
+		},
+		From: "system",
+	}
+}
+
+// HandleTOTPConfirm 用户输入验证器中的验证码，确认后用新密钥开启两步验证
+func (s *Server) HandleTOTPConfirm(msg *protocol.Message, c *ClientConn) {
+	if c.Name == "" {
+		return
+	}
+	store, ok := s.totpStore()
+	if !ok {
+		return
+	}
+	code, _ := msg.Content.(string)
+	pending, err := redis.GetPendingTOTP(c.Name)
+	if err != nil {
+		log.Printf("读取用户%s待确认的两步验证密钥失败:%v", c.Name, err)
+	}
+	if pending == nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "totp_fail",
+			Content: "请先生成两步验证密钥",
+			From:    "system",
+		}
+		return
+	}
+	step, valid := totp.Validate(pending.Secret, code, s.clock())
+	if !valid {
+		c.Outgoing <- &protocol.Message{
+			Type:    "totp_fail",
+			Content: "验证码错误，两步验证未开启",
+			From:    "system",
+		}
+		return
+	}
+	if err := store.EnableTOTP(c.Name, pending.Secret, pending.Recovery); err != nil {
+		log.Println(err)
+		c.Outgoing <- &protocol.Message{
+			Type:    "totp_fail",
+			Content: "开启两步验证失败",
+			From:    "system",
+		}
+		return
+	}
+	if err := redis.DeletePendingTOTP(c.Name); err != nil {
+		log.Printf("删除用户%s待确认的两步验证密钥失败:%v", c.Name, err)
+	}
+	//确认用过的验证码不能再用来登录
+	if _, err := redis.MarkTOTPStepUsed(c.Name, step, totp.Period*(2*totp.Skew+2)); err != nil {
+		log.Println(err)
+	}
+	s.audit(c.Name, "totp_enable", c.Name)
+	c.Outgoing <- &protocol.Message{
+		Type:    "totp_enabled",
+		Content: "两步验证已开启，下次登录时需要输入验证码",
+		From:    "system",
+	}
+}
+
+// 生成恢复码，格式为 XXXXX-XXXXX
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 6)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := base32.StdEncoding.EncodeToString(buf)[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"net_chat/internal/database"
+	"net_chat/internal/protocol"
+	"net_chat/internal/totp"
+	"testing"
+)
+
+// 内存中的两步验证后端
+type fakeTOTPStore struct {
+	info map[string]*database.TOTPInfo
+}
+
+func newFakeTOTPStore() *fakeTOTPStore {
+	return &fakeTOTPStore{info: make(map[string]*database.TOTPInfo)}
+}
+
+func (f *fakeTOTPStore) Authenticate(username, password string) error { return nil }
+
+func (f *fakeTOTPStore) UserExists(username string) (bool, error) { return true, nil }
+
+func (f *fakeTOTPStore) GetTOTP(username string) (*database.TOTPInfo, error) {
+	if info, ok := f.info[username]; ok {
+		copied := *info
+		return &copied, nil
+	}
+	return &database.TOTPInfo{}, nil
+}
+
+func (f *fakeTOTPStore) EnableTOTP(username, secret string, recoveryHashes []string) error {
+	f.info[username] = &database.TOTPInfo{Secret: secret, Enabled: true, Recovery: recoveryHashes}
+	return nil
+}
+
+func (f *fakeTOTPStore) UseRecoveryCode(username, code string) (bool, error) {
+	info, ok := f.info[username]
+	if !ok {
+		return false, nil
+	}
+	target := database.HashRecoveryCode(code)
+	for i, h := range info.Recovery {
+		if h == target {
+			info.Recovery = append(info.Recovery[:i], info.Recovery[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func enroll(t *testing.T, s *Server, c *ClientConn) protocol.TOTPEnrollment {
+	t.Helper()
+	s.HandleTOTPEnroll(c)
+	var e protocol.TOTPEnrollment
+	if err := protocol.DecodeContent(expectMsg(t, c, "totp_enroll").Content, &e); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func codeNow(t *testing.T, s *Server, secret string) string {
+	t.Helper()
+	code, err := totp.Code(secret, s.clock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTPEnrollConfirmLogin(t *testing.T) {
+	s, _, now := newTestServer(t)
+	store := newFakeTOTPStore()
+	s.SetAuthenticator(store)
+	c := newTestConn(t, "alice")
+
+	e := enroll(t, s, c)
+	if len(e.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes", len(e.RecoveryCodes))
+	}
+	if info, _ := store.GetTOTP("alice"); info.Enabled || info.Secret != "" {
+		t.Fatal("enroll must not change the active settings before confirmation")
+	}
+
+	s.HandleTOTPConfirm(&protocol.Message{Type: "totp_confirm", Content: "000000"}, c)
+	expectMsg(t, c, "totp_fail")
+	s.HandleTOTPConfirm(&protocol.Message{Type: "totp_confirm", Content: codeNow(t, s, e.Secret)}, c)
+	expectMsg(t, c, "totp_enabled")
+	if info, _ := store.GetTOTP("alice"); !info.Enabled || info.Secret != e.Secret {
+		t.Fatalf("totp not enabled after confirm: %+v", info)
+	}
+	//确认后待确认的密钥已删除
+	s.HandleTOTPConfirm(&protocol.Message{Type: "totp_confirm", Content: codeNow(t, s, e.Secret)}, c)
+	expectMsg(t, c, "totp_fail")
+
+	//确认用过的验证码不能用来登录
+	login := newTestConn(t, "")
+	s.completeLogin(login, "alice", protocol.LoginResult{})
+	expectMsg(t, login, "login_totp_required")
+	s.HandleLoginTOTP(&protocol.Message{Type: "login_totp", Content: codeNow(t, s, e.Secret)}, login)
+	expectMsg(t, login, "login_totp_fail")
+
+	*now = now.Add(2 * totp.Period)
+	code := codeNow(t, s, e.Secret)
+	s.HandleLoginTOTP(&protocol.Message{Type: "login_totp", Content: code}, login)
+	expectMsg(t, login, "login_success")
+	if login.Name != "alice" {
+		t.Fatalf("login name = %q", login.Name)
+	}
+	s.RemoveUser("alice")
+
+	//同一个验证码不能重放
+	replay := newTestConn(t, "")
+	s.completeLogin(replay, "alice", protocol.LoginResult{})
+	expectMsg(t, replay, "login_totp_required")
+	s.HandleLoginTOTP(&protocol.Message{Type: "login_totp", Content: code}, replay)
+	expectMsg(t, replay, "login_totp_fail")
+
+	//恢复码只能用一次
+	s.HandleLoginTOTP(&protocol.Message{Type: "login_totp", Content: e.RecoveryCodes[0]}, replay)
+	expectMsg(t, replay, "login_success")
+	s.RemoveUser("alice")
+	again := newTestConn(t, "")
+	s.completeLogin(again, "alice", protocol.LoginResult{})
+	s.HandleLoginTOTP(&protocol.Message{Type: "login_totp", Content: e.RecoveryCodes[0]}, again)
+	expectMsg(t, again, "login_totp_fail")
+}
+
+func TestTOTPReenrollKeepsActiveSecret(t *testing.T) {
+	s, _, now := newTestServer(t)
+	store := newFakeTOTPStore()
+	s.SetAuthenticator(store)
+	c := newTestConn(t, "alice")
+
+	first := enroll(t, s, c)
+	s.HandleTOTPConfirm(&protocol.Message{Type: "totp_confirm", Content: codeNow(t, s, first.Secret)}, c)
+	expectMsg(t, c, "totp_enabled")
+
+	//重新生成但不确认，两步验证保持开启且仍使用原来的密钥
+	second := enroll(t, s, c)
+	info, _ := store.GetTOTP("alice")
+	if !info.Enabled || info.Secret != first.Secret {
+		t.Fatalf("re-enroll changed active settings: %+v", info)
+	}
+	login := newTestConn(t, "")
+	s.completeLogin(login, "alice", protocol.LoginResult{})
+	expectMsg(t, login, "login_totp_required")
+
+	//确认之后换成新密钥
+	*now = now.Add(2 * totp.Period)
+	s.HandleTOTPConfirm(&protocol.Message{Type: "totp_confirm", Content: codeNow(t, s, second.Secret)}, c)
+	expectMsg(t, c, "totp_enabled")
+	if info, _ := store.GetTOTP("alice"); info.Secret != second.Secret {
+		t.Fatal("confirm should switch to the new secret")
+	}
+}
+
+func TestTOTPPendingExpires(t *testing.T) {
+	s, mr, _ := newTestServer(t)
+	store := newFakeTOTPStore()
+	s.SetAuthenticator(store)
+	c := newTestConn(t, "alice")
+
+	e := enroll(t, s, c)
+	mr.FastForward(totpEnrollTTL + 1)
+	s.HandleTOTPConfirm(&protocol.Message{Type: "totp_confirm", Content: codeNow(t, s, e.Secret)}, c)
+	expectMsg(t, c, "totp_fail")
+	if info, _ := store.GetTOTP("alice"); info.Enabled {
+		t.Fatal("expired enrollment must not enable totp")
+	}
+}
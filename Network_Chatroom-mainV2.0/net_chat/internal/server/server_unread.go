@@ -0,0 +1,73 @@
+package server
+
+import (
+	"log"
+	"net_chat/internal/database/redis"
+	"net_chat/internal/protocol"
+	"sort"
+)
+
+//聊天室未读数
+//客户端进入、离开聊天室时发送 mark_read（Content为聊天室名，ID为空表示全部已读），
+//服务端按已读位置计算各聊天室的未读数，登录时推送有未读的聊天室，也可以用 room_unread 主动查询
+
+// HandleMarkRead 标记聊天室消息已读
+func (s *Server) HandleMarkRead(msg *protocol.Message, c *ClientConn) {
+	room, _ := msg.Content.(string)
+	if room == "" {
+		room = mainRoom
+	}
+	if !s.canAccessRoom(c, room) {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "无法访问该聊天室",
+			From:    "system",
+		}
+		return
+	}
+	if err := redis.MarkRoomRead(c.Name, room, msg.ID); err != nil {
+		c.Outgoing <- &protocol.Message{
+			Type:    "error",
+			Content: "标记已读失败:" + err.Error(),
+			From:    "system",
+		}
+	}
+}
+
+// HandleRoomUnread 查询所有聊天室的未读数
+func (s *Server) HandleRoomUnread(c *ClientConn) {
+	s.sendRoomUnread(c, false)
+}
+
+// 发送聊天室未读汇总，onlyUnread为true时只包含有未读消息的聊天室，没有则不发送
+func (s *Server) sendRoomUnread(c *ClientConn, onlyUnread bool) {
+	rooms, err := redis.ListRooms()
+	if err != nil {
+		log.Printf("获取聊天室列表失败:%v", err)
+		return
+	}
+	sort.Strings(rooms)
+	summary := make([]protocol.RoomUnread, 0, len(rooms))
+	for _, room := range rooms {
+		if !s.canAccessRoom(c, room) {
+			continue
+		}
+		lastRead, unread, err := redis.GetRoomUnread(c.Name, room)
+		if err != nil {
+			log.Printf("计算用户%s在%s的未读数失败:%v", c.Name, room, err)
+			continue
+		}
+		if onlyUnread && unread == 0 {
+			continue
+		}
+		summary = append(summary, protocol.RoomUnread{Room: room, Unread: unread, LastRead: lastRead})
+	}
+	if onlyUnread && len(summary) == 0 {
+		return
+	}
+	c.Outgoing <- &protocol.Message{
+		Type:    "room_unread",
+		Content: summary,
+		From:    "system",
+	}
+}
@@ -0,0 +1,129 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录B的SHA1测试密钥 "12345678901234567890"
+var rfcSecret = b32.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	//RFC中是8位验证码，这里只用后6位
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseSecret(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	got, err := Code(" "+strings.ToLower(rfcSecret)+" ", now)
+	if err != nil || got != "081804" {
+		t.Fatalf("Code(lowercase) = %q, %v", got, err)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", time.Unix(59, 0)); err == nil {
+		t.Fatal("expected error for invalid secret")
+	}
+}
+
+func TestStep(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want int64
+	}{
+		{0, 0},
+		{29, 0},
+		{30, 1},
+		{59, 1},
+		{1111111109, 37037036},
+	}
+	for _, tt := range tests {
+		if got := Step(time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("Step(%d) = %d, want %d", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+	codeAt := func(s int64) string {
+		code, err := CodeAt(rfcSecret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current", codeAt(step), step, true},
+		{"previous window", codeAt(step - 1), step - 1, true},
+		{"next window", codeAt(step + 1), step + 1, true},
+		{"surrounding spaces", " " + codeAt(step) + " ", step, true},
+		{"too old", codeAt(step - 2), 0, false},
+		{"too new", codeAt(step + 2), 0, false},
+		{"wrong length", "12345", 0, false},
+		{"recovery code", "ABCDE-FGHIJ", 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.code, gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Fatal("secrets should be random")
+	}
+	key, err := b32.DecodeString(a)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes, %v", a, len(key), err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("net_chat", "alice", "ABC")
+	for _, part := range []string{"otpauth://totp/net_chat:alice?", "secret=ABC", "issuer=net_chat", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("URI %q missing %q", uri, part)
+		}
+	}
+}